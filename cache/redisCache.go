@@ -5,39 +5,73 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"io"
 	"math/rand"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/blutspende/bloodlab-common/internal/redisutil"
 	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/blutspende/bloodlab-common/timezone"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type RedisCache interface {
 	// Initialization
 	Init(config RedisCacheConfig, refreshFillerFunc func(ctx context.Context) error, refreshInitFunc func(ctx context.Context) error)
+	WithTenant(tenant string) (RedisCache, error)
 	// Refreshing and validity
 	IsValid(ctx context.Context) bool
 	SetToInvalid(ctx context.Context)
 	SetToValid(ctx context.Context)
 	RefreshCacheAsync(ctx context.Context, forceUpdate bool)
+	// Scheduling
+	Start(ctx context.Context) error
+	Stop()
+	RefreshStatus() RefreshStatus
+	Status(ctx context.Context) (CacheStatus, error)
+	CircuitState() CircuitState
 	// CRUD
 	Store(ctx context.Context, key string, content interface{}) error
 	StoreWithExpiration(ctx context.Context, key string, content interface{}, expirationTime *time.Duration) error
 	Read(ctx context.Context, key string, modelPtr interface{}) error
 	ReadWithExpiration(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration) error
 	ReadGroup(ctx context.Context, keys []string, modelArrayPtr interface{}) error
+	ReadGroupAsMap(ctx context.Context, keys []string, modelMapPtr interface{}) (missingKeys []string, err error)
+	ReadOrCompute(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration, compute func(ctx context.Context) (interface{}, error)) error
 	Delete(ctx context.Context, key string) error
+	StoreGroup(ctx context.Context, contents map[string]interface{}, expirationTime *time.Duration) error
+	DeleteGroup(ctx context.Context, keys []string) error
+	// JSON path handling
+	ReadPath(ctx context.Context, key string, path string, modelPtr interface{}) error
+	StorePath(ctx context.Context, key string, path string, content interface{}) error
+	MergePath(ctx context.Context, key string, path string, content interface{}) error
+	AppendToArray(ctx context.Context, key string, path string, contents ...interface{}) error
+	IncrementNumber(ctx context.Context, key string, path string, value float64) (float64, error)
 	// Set handling
 	AddItemToSet(ctx context.Context, key string, item string) error
 	IsItemInSet(ctx context.Context, key string, item string) (bool, error)
 	GetItemsInSetAsMap(ctx context.Context, key string) (map[string]struct{}, error)
 	DeleteItemFromSet(ctx context.Context, key string, item string) error
+	// Tag handling
+	StoreWithTags(ctx context.Context, key string, content interface{}, expirationTime *time.Duration, tags ...string) error
+	InvalidateTags(ctx context.Context, tags ...string) (deletedCount int, err error)
+	// Job handling
+	EnqueueJob(ctx context.Context, queue string, payload interface{}, dueAt time.Time, dedupKey string) (jobId string, enqueued bool, err error)
+	ClaimJobs(ctx context.Context, queue string, count int) ([]Job, error)
+	CompleteJob(ctx context.Context, job Job) error
+	RetryJob(ctx context.Context, job Job, delay *time.Duration) error
+	// Counter handling
+	IncrementCounter(ctx context.Context, counter string, value int64, resetAt *WallClock) (int64, error)
+	GetCounter(ctx context.Context, counter string) (int64, error)
+	ResetCounter(ctx context.Context, counter string) error
+	NextSequenceNumber(ctx context.Context, sequence string, seed SequenceSeedFunc) (SequenceNumber, error)
 	// Flag handling
 	SetFlag(ctx context.Context, key string) error
 	SetFlagWithExpiration(ctx context.Context, key string, expirationTime *time.Duration) error
@@ -46,7 +80,18 @@ type RedisCache interface {
 	// Index handling
 	CreateIndex(ctx context.Context, index string, options *redis.FTCreateOptions, fieldSchemas []*redis.FieldSchema) (string, error)
 	SearchInIndex(ctx context.Context, indexName string, queryString string, options *redis.FTSearchOptions, modelArrayPtr interface{}) (totalCount int, err error)
+	SearchInIndexPaginated(ctx context.Context, indexName string, queryString string, page pagination.FilteredPaginatedQuery, allowedSortFields []string, modelArrayPtr interface{}) (pagination.PaginatedResponse, error)
+	Aggregate(ctx context.Context, indexName string, aggregation *Aggregation, modelArrayPtr interface{}) error
 	DeleteIndex(ctx context.Context, index string, deleteDocuments bool) error
+	EnsureIndex(ctx context.Context, definition IndexDefinition) (indexName string, err error)
+	// Administration
+	ListKeys(ctx context.Context, category KeyCategory, cursor uint64, count int64) (KeyPage, error)
+	InspectKey(ctx context.Context, key string) (KeyInfo, error)
+	CountKeys(ctx context.Context) (map[KeyCategory]int64, error)
+	DeleteKeysByCategory(ctx context.Context, category KeyCategory) (deletedCount int64, err error)
+	// Snapshots
+	ExportSnapshot(ctx context.Context, w io.Writer) (SnapshotStats, error)
+	ImportSnapshot(ctx context.Context, r io.Reader) (SnapshotStats, error)
 	// Key handling
 	KeyForAll() string
 	KeyForOne(id uuid.UUID) string
@@ -55,13 +100,18 @@ type RedisCache interface {
 	KeyForCustom(customKey string) string
 	KeyForValuedCustom(name string, values ...string) string
 	KeyForNotFound() string
+	KeyForTag(tag string) string
+	DecodeKey(key string) (DecodedKey, error)
 	// Helper functions
 	GuidToString(id uuid.UUID) string
 }
 
 type redisCache struct {
-	redisClient          *redis.Client
+	redisClient          redis.UniversalClient
 	name                 string
+	tenant               string
+	nameErr              error
+	keyPrefix            string
 	refreshMutex         *sync.Mutex
 	rnd                  rand.Rand
 	cacheValid           bool
@@ -69,12 +119,27 @@ type redisCache struct {
 	config               *RedisCacheConfig
 	refreshFillerFunc    func(ctx context.Context) error
 	refreshInitFunc      func(ctx context.Context) error
-}
-
-func NewRedisCache(redisClient *redis.Client, name string) RedisCache {
+	instanceId           string
+	scheduler            *refreshScheduler
+	refreshStatusMutex   *sync.RWMutex
+	refreshStatus        RefreshStatus
+	telemetry            *cacheTelemetry
+	breaker              *circuitBreaker
+	breakerInherited     bool
+}
+
+// NewRedisCache creates a cache on a single node, sentinel (failover) or cluster client, in the latter case
+// all keys of the cache share one hash slot, so multi-key operations are possible. The name must consist of
+// letters, digits, '_', '-' and '.', otherwise the cache is never initialized and all calls return
+// ErrInvalidCacheName.
+func NewRedisCache(redisClient redis.UniversalClient, name string) RedisCache {
+	redisClient = redisutil.Client(redisClient)
+	keyPrefix := redisutil.KeyPrefix(redisClient, name)
 	return &redisCache{
 		redisClient:          redisClient,
 		name:                 name,
+		nameErr:              validateCacheName(name),
+		keyPrefix:            keyPrefix,
 		refreshMutex:         &sync.Mutex{},
 		rnd:                  *rand.New(rand.NewSource(time.Now().UnixNano())),
 		cacheValid:           false,
@@ -82,6 +147,11 @@ func NewRedisCache(redisClient *redis.Client, name string) RedisCache {
 		config:               nil,
 		refreshFillerFunc:    nil,
 		refreshInitFunc:      nil,
+		instanceId:           uuid.NewString(),
+		scheduler:            nil,
+		refreshStatusMutex:   &sync.RWMutex{},
+		refreshStatus:        RefreshStatus{},
+		telemetry:            noopTelemetry(name),
 	}
 }
 
@@ -100,13 +170,25 @@ var (
 	ErrMutexExpirationNotSet = errors.New("no mutex expiration set for multiserver mode")
 	ErrNoClientSet           = errors.New("no redis client set in cache")
 	ErrCachingDisabled       = errors.New("redis caching is disabled")
+	ErrRefreshIntervalNotSet = errors.New("no refresh interval set for scheduled refresh")
+	ErrSchedulerRunning      = errors.New("refresh scheduler is already running")
 )
 
 var (
-	cacheValidFlagKey = "CACHE_VALID"
-	mutexLockFlagKey  = "MUTEX_LOCK"
+	cacheValidFlagKey    = "CACHE_VALID"
+	mutexLockFlagKey     = "MUTEX_LOCK"
+	schedulerFlagKey     = "SCHEDULER_LOCK"
+	refreshCheckpointKey = "REFRESH_CHECKPOINT"
 )
 
+// unlockMutexScript deletes the mutex lock only if it is still held by the instance ARGV[1]
+var unlockMutexScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
 // Config
 
 type RedisCacheConfig struct {
@@ -117,14 +199,32 @@ type RedisCacheConfig struct {
 	MultiserverMode          bool
 	MutexExpiration          *time.Duration
 	IsDisabled               bool
+	RefreshInterval          *time.Duration
+	RefreshIntervalJitter    *time.Duration
+	RefreshHooks             RefreshHooks
+	IncrementalRefreshFunc   IncrementalRefreshFunc
+	UseOpenTelemetry         bool
+	Codec                    Codec
+	GroupChunkSize           int
+	ExpirationJitter         *time.Duration
+	EarlyExpirationBeta      float64
+	CircuitBreaker           *CircuitBreakerConfig
+	JobQueue                 JobQueueConfig
+	CounterTimeZone          timezone.TimeZone
 }
 
 // Initialization
 
 func (c *redisCache) Init(config RedisCacheConfig, refreshFillerFunc func(ctx context.Context) error, refreshInitFunc func(ctx context.Context) error) {
+	if c.nameErr != nil {
+		log.Error().Err(c.nameErr).Msg(c.fmtMsg("cache not initialized"))
+		return
+	}
 	c.config = &config
 	c.refreshFillerFunc = refreshFillerFunc
 	c.refreshInitFunc = refreshInitFunc
+	c.initTelemetry()
+	c.initCircuitBreaker()
 	if c.config.IsDisabled {
 		log.Warn().Msg(c.fmtMsg("redis cache is disabled"))
 	}
@@ -134,12 +234,15 @@ func (c *redisCache) Init(config RedisCacheConfig, refreshFillerFunc func(ctx co
 
 func (c *redisCache) IsValid(ctx context.Context) bool {
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
 		return false
 	}
 	if c.config.IsDisabled {
 		return false
 	}
+	if c.checkCircuit() != nil {
+		return false
+	}
 	if c.config != nil && c.config.MultiserverMode {
 		valid, err := c.GetFlag(ctx, c.keyForSystem(cacheValidFlagKey))
 		if err != nil {
@@ -152,7 +255,7 @@ func (c *redisCache) IsValid(ctx context.Context) bool {
 }
 func (c *redisCache) SetToInvalid(ctx context.Context) {
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
 		return
 	}
 	if c.config.IsDisabled {
@@ -169,7 +272,7 @@ func (c *redisCache) SetToInvalid(ctx context.Context) {
 }
 func (c *redisCache) SetToValid(ctx context.Context) {
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
 		return
 	}
 	if c.config.IsDisabled {
@@ -187,30 +290,27 @@ func (c *redisCache) SetToValid(ctx context.Context) {
 
 func (c *redisCache) mutexTryLock(ctx context.Context) bool {
 	if c.config != nil && c.config.MultiserverMode {
-		locked, err := c.GetFlag(ctx, c.keyForSystem(mutexLockFlagKey))
-		if err != nil {
-			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting mutex flag failed"))
-			return false
-		}
-		if locked {
-			return false
-		}
 		if c.config.MutexExpiration == nil {
 			log.Error().Ctx(ctx).Err(c.fmtErr(ErrMutexExpirationNotSet)).Send()
 			return false
 		}
-		err = c.SetFlagWithExpiration(ctx, c.keyForSystem(mutexLockFlagKey), c.config.MutexExpiration)
+		// The lock holds the instance id, so the refreshing instance can be reported in the status
+		locked, err := c.redisClient.SetNX(ctx, c.keyForSystem(mutexLockFlagKey), c.instanceId, *c.config.MutexExpiration).Result()
 		if err != nil {
 			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("setting mutex flag failed"))
 			return false
 		}
+		if !locked {
+			return false
+		}
 		return true
 	}
 	return c.refreshMutex.TryLock()
 }
 func (c *redisCache) mutexUnlock(ctx context.Context) {
 	if c.config != nil && c.config.MultiserverMode {
-		err := c.DeleteFlag(ctx, c.keyForSystem(mutexLockFlagKey))
+		// Note: an expired lock may have been taken by another instance meanwhile, which must keep it
+		err := unlockMutexScript.Run(ctx, c.redisClient, []string{c.keyForSystem(mutexLockFlagKey)}, c.instanceId).Err()
 		if err != nil {
 			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("unlocking mutex flag failed"))
 		}
@@ -219,17 +319,30 @@ func (c *redisCache) mutexUnlock(ctx context.Context) {
 	}
 }
 
+// RefreshCacheAsync refreshes the cache in the background, with an IncrementalRefreshFunc only the changes
+// since the last refresh are applied while the cache stays valid, unless forceUpdate requests a full refresh
 func (c *redisCache) RefreshCacheAsync(ctx context.Context, forceUpdate bool) {
-	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
+	ctx = c.circuitContext(ctx)
+	mode := c.startRefresh(ctx, forceUpdate)
+	if mode == refreshNone {
 		return
 	}
+	go c.runRefresh(ctx, mode)
+}
+
+// startRefresh checks the preconditions of a refresh and acquires the refresh mutex, returns the mode of the
+// refresh to run, a full refresh invalidates the cache
+func (c *redisCache) startRefresh(ctx context.Context, forceUpdate bool) refreshMode {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return refreshNone
+	}
 	if c.config.IsDisabled {
-		return
+		return refreshNone
 	}
 	if c.redisClient == nil {
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
-		return
+		return refreshNone
 	}
 	if !c.mutexTryLock(ctx) {
 		if forceUpdate {
@@ -238,95 +351,179 @@ func (c *redisCache) RefreshCacheAsync(ctx context.Context, forceUpdate bool) {
 		} else {
 			log.Debug().Ctx(ctx).Msg(c.fmtMsg("refresh already running, skipping new request"))
 		}
-		return
+		return refreshNone
+	}
+	if !forceUpdate && c.canRefreshIncrementally(ctx) {
+		return refreshIncremental
 	}
 	c.SetToInvalid(ctx)
-	go func() {
-		defer func() {
-			c.mutexUnlock(ctx)
-			if c.forceUpdateRequested {
-				log.Debug().Ctx(ctx).Msg(c.fmtMsg("processing forced re-refresh request"))
-				c.forceUpdateRequested = false
-				go c.RefreshCacheAsync(ctx, false)
-			}
-		}()
-		err := c.retry(c.config.RefreshRetryAttempts, (time.Duration)(c.config.RefreshRetryWaitStartMs)*time.Millisecond, (float64)(c.config.RefreshRetryWaitExponent), func() error {
-			err := c.clearCache(ctx)
+	return refreshFull
+}
+
+// runRefresh executes the refresh with the retry policy and releases the refresh mutex, startRefresh must be called before
+func (c *redisCache) runRefresh(ctx context.Context, mode refreshMode) {
+	ctx, span := c.startSpan(c.withTenantContext(ctx), "Refresh", attribute.Bool("cache.refresh.incremental", mode == refreshIncremental))
+	defer func() {
+		c.mutexUnlock(context.WithoutCancel(ctx))
+		if c.forceUpdateRequested {
+			log.Debug().Ctx(ctx).Msg(c.fmtMsg("processing forced re-refresh request"))
+			c.forceUpdateRequested = false
+			go c.RefreshCacheAsync(ctx, true)
+		}
+	}()
+	startedAt := time.Now()
+	c.recordRefreshStart(startedAt)
+	c.callRefreshHook(ctx, c.config.RefreshHooks.OnStart, RefreshEvent{StartedAt: startedAt, Incremental: mode == refreshIncremental})
+	attempt := 0
+	err := c.retry(ctx, c.config.RefreshRetryAttempts, (time.Duration)(c.config.RefreshRetryWaitStartMs)*time.Millisecond, (float64)(c.config.RefreshRetryWaitExponent), func() error {
+		attempt++
+		c.recordRefreshAttempt(attempt)
+		if mode == refreshIncremental {
+			return c.refreshIncrementally(ctx, startedAt)
+		}
+		err := c.clearCache(ctx)
+		if err != nil {
+			log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh clear cache failed"))
+			return err
+		}
+		if c.refreshInitFunc != nil {
+			err = c.refreshInitFunc(ctx)
 			if err != nil {
-				log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh clear cache failed"))
+				log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh init function failed"))
 				return err
 			}
-			if c.refreshInitFunc != nil {
-				err = c.refreshInitFunc(ctx)
-				if err != nil {
-					log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh init function failed"))
-					return err
-				}
-			}
-			if c.refreshFillerFunc != nil {
-				err = c.refreshFillerFunc(ctx)
-				if err != nil {
-					log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh refill cache function failed"))
-					return err
-				}
-			} else {
-				log.Error().Ctx(ctx).Msg(c.fmtMsg("refresh called with no filler function provided"))
+		}
+		if c.refreshFillerFunc != nil {
+			err = c.refreshFillerFunc(ctx)
+			if err != nil {
+				log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh refill cache function failed"))
+				return err
 			}
-			c.SetToValid(ctx)
-			return nil
-		})
-		if err != nil {
-			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh failed"))
 		} else {
-			log.Trace().Ctx(ctx).Msg(c.fmtMsg("refresh succeeded"))
+			log.Error().Ctx(ctx).Msg(c.fmtMsg("refresh called with no filler function provided"))
 		}
-	}()
-	return
+		if c.config.IncrementalRefreshFunc != nil {
+			err = c.storeRefreshCheckpoint(ctx, RefreshCheckpoint{RefreshedAt: startedAt})
+			if err != nil {
+				log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("storing refresh checkpoint failed"))
+				return err
+			}
+		}
+		c.SetToValid(ctx)
+		return nil
+	})
+	c.recordRefreshResult(startedAt, err)
+	c.recordRefresh(ctx, time.Since(startedAt), err)
+	c.endSpan(span, err)
+	event := RefreshEvent{StartedAt: startedAt, Attempt: attempt, Duration: time.Since(startedAt), Err: err, Incremental: mode == refreshIncremental}
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("refresh failed"))
+		c.callRefreshHook(ctx, c.config.RefreshHooks.OnFailure, event)
+	} else {
+		log.Trace().Ctx(ctx).Msg(c.fmtMsg("refresh succeeded"))
+		c.callRefreshHook(ctx, c.config.RefreshHooks.OnSuccess, event)
+	}
 }
 
-// retry executes fn with progressive backoff
-func (c *redisCache) retry(attempts int, sleep time.Duration, exponent float64, fn func() error) error {
+// retry executes fn with progressive backoff, waiting is aborted if the context is cancelled
+func (c *redisCache) retry(ctx context.Context, attempts int, sleep time.Duration, exponent float64, fn func() error) error {
 	var err error
 	for i := 0; i < attempts; i++ {
 		err = fn()
 		if err == nil {
 			return nil
 		}
-		backoff := time.Duration(math.Pow(exponent, float64(i))) * sleep
-		jitter := time.Duration(c.rnd.Int63n(int64(sleep)))
-		wait := backoff + jitter
+		wait := progressiveBackoff(i, sleep, exponent, c.rnd.Float64())
 		log.Warn().Msg(c.fmtMsg(fmt.Sprintf("attempt %d failed: %v, retrying in %v...\n", i+1, err, wait)))
-		time.Sleep(wait)
+		select {
+		case <-ctx.Done():
+			return c.fmtErr(fmt.Errorf("aborted after %d attempts, last error: %w", i+1, err))
+		case <-time.After(wait):
+		}
 	}
 	return c.fmtErr(fmt.Errorf("after %d attempts, last error: %w", attempts, err))
 }
 
-// clearCache removes all keys with the cache's prefix
+// clearCache removes all keys of cached content with the cache's prefix, the system keys with the locks, and the
+// keys of job queues, counters, sequences, idempotency stores and rate limiters of the same name are kept
 func (c *redisCache) clearCache(ctx context.Context) error {
-	prefix := fmt.Sprintf("%s:*", c.name)
+	prefix := fmt.Sprintf("%s:*", c.keyPrefix)
+	err := c.scanKeys(ctx, prefix, 50, func(keys []string) error {
+		keys = slices.DeleteFunc(keys, func(key string) bool { return !c.isContentKey(key) })
+		if len(keys) > 0 {
+			_, _ = c.deleteKeys(ctx, keys)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Interface("prefix", prefix).Msg(c.fmtMsg("deleting all existing keys by prefix failed"))
+		return err
+	}
+	// Note: the system keys are kept for the locks, only the state of the cleared content is removed
+	_, err = c.deleteKeys(ctx, []string{c.keyForSystem(cacheValidFlagKey), c.keyForSystem(refreshCheckpointKey)})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("deleting validity flag and refresh checkpoint failed"))
+		return err
+	}
+	return nil
+}
+
+// scanKeys calls fn with batches of keys matching the pattern, in case of a cluster client the masters are
+// scanned concurrently, so fn must be safe for concurrent use
+func (c *redisCache) scanKeys(ctx context.Context, pattern string, batchSize int64, fn func(keys []string) error) error {
+	if clusterClient, isCluster := c.redisClient.(*redis.ClusterClient); isCluster {
+		return clusterClient.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scanKeysOnNode(ctx, client, pattern, batchSize, fn)
+		})
+	}
+	return scanKeysOnNode(ctx, c.redisClient, pattern, batchSize, fn)
+}
+func scanKeysOnNode(ctx context.Context, client redis.Cmdable, pattern string, batchSize int64, fn func(keys []string) error) error {
 	var cursor uint64
 	for {
-		var keys []string
-		var err error
-		keys, cursor, err = c.redisClient.Scan(ctx, cursor, prefix, 50).Result()
+		keys, nextCursor, err := client.Scan(ctx, cursor, pattern, batchSize).Result()
 		if err != nil {
-			log.Error().Ctx(ctx).Interface("prefix", prefix).Msg(c.fmtMsg("deleting all existing keys by prefix failed"))
 			return err
 		}
-		c.redisClient.Del(ctx, keys...)
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = nextCursor
 		if cursor == 0 {
-			break
+			return nil
 		}
 	}
-	return nil
+}
+
+// deleteKeys deletes the keys and returns the number of deleted keys and the error, in case of a cluster client
+// keys are deleted one by one, as keys not generated by the cache may belong to different hash slots
+func (c *redisCache) deleteKeys(ctx context.Context, keys []string) (int64, error) {
+	if _, isCluster := c.redisClient.(*redis.ClusterClient); isCluster {
+		pipeline := c.redisClient.Pipeline()
+		cmds := make([]*redis.IntCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipeline.Del(ctx, key)
+		}
+		_, err := pipeline.Exec(ctx)
+		var deleted int64
+		for _, cmd := range cmds {
+			deleted += cmd.Val()
+		}
+		return deleted, err
+	}
+	return c.redisClient.Del(ctx, keys...).Result()
 }
 
 // CRUD
 
-func (c *redisCache) Store(ctx context.Context, key string, content interface{}) error {
+func (c *redisCache) Store(ctx context.Context, key string, content interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "Store", attribute.String("cache.key", key))
+	defer func() { c.endSpan(span, err) }()
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -335,12 +532,20 @@ func (c *redisCache) Store(ctx context.Context, key string, content interface{})
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return ErrNoClientSet
 	}
-	return c.redisClient.JSONSet(ctx, key, "$", content).Err()
+	payload, err := c.codec().Marshal(content)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
+	return c.setValue(ctx, c.redisClient, key, payload).Err()
 }
-func (c *redisCache) StoreWithExpiration(ctx context.Context, key string, content interface{}, expirationTime *time.Duration) error {
+func (c *redisCache) StoreWithExpiration(ctx context.Context, key string, content interface{}, expirationTime *time.Duration) (err error) {
+	ctx, span := c.startSpan(ctx, "Store", attribute.String("cache.key", key))
+	defer func() { c.endSpan(span, err) }()
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -357,16 +562,22 @@ func (c *redisCache) StoreWithExpiration(ctx context.Context, key string, conten
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrExpirationNotSet)).Send()
 		return ErrExpirationNotSet
 	}
+	payload, err := c.codec().Marshal(content)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
 	pipeline := c.redisClient.Pipeline()
-	pipeline.JSONSet(ctx, key, "$", content)
-	pipeline.Expire(ctx, key, *expiration)
-	_, err := pipeline.Exec(ctx)
+	c.setValue(ctx, pipeline, key, payload)
+	pipeline.Expire(ctx, key, expirationWithJitter(*expiration, c.config.ExpirationJitter))
+	_, err = pipeline.Exec(ctx)
 	return err
 }
 func (c *redisCache) Read(ctx context.Context, key string, modelPtr interface{}) error {
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -375,8 +586,8 @@ func (c *redisCache) Read(ctx context.Context, key string, modelPtr interface{})
 }
 func (c *redisCache) ReadWithExpiration(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration) error {
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -391,25 +602,27 @@ func (c *redisCache) ReadWithExpiration(ctx context.Context, key string, modelPt
 	}
 	return c.read(ctx, key, modelPtr, expiration)
 }
-func (c *redisCache) read(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration) error {
+func (c *redisCache) read(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration) (err error) {
+	ctx, span := c.startSpan(ctx, "Read", attribute.String("cache.key", key))
+	defer func() { c.endSpan(span, err) }()
 	if c.redisClient == nil {
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return ErrNoClientSet
 	}
-	if !c.IsValid(ctx) {
-		return ErrCacheInvalid
+	if err := c.checkValidForRead(ctx); err != nil {
+		return err
 	}
-	var redisResult string
-	var err error
+	var redisResult []byte
 	if expirationTime == nil {
-		redisResult, err = c.redisClient.JSONGet(ctx, key).Result()
+		redisResult, err = c.getValue(ctx, c.redisClient, key)()
 	} else {
 		pipeline := c.redisClient.Pipeline()
 		ttl := pipeline.TTL(ctx, key)
-		jsonGet := pipeline.JSONGet(ctx, key)
+		getValue := c.getValue(ctx, pipeline, key)
 		_, err = pipeline.Exec(ctx)
 		if err != nil {
 			if errors.Is(err, redis.Nil) {
+				c.recordRead(ctx, 0, 1)
 				return ErrItemNotFound
 			}
 			log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("failed to execute pipeline"))
@@ -425,26 +638,31 @@ func (c *redisCache) read(ctx context.Context, key string, modelPtr interface{},
 				log.Warn().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("failed to update expiration"))
 			}
 		}
-		redisResult, err = jsonGet.Result()
+		redisResult, err = getValue()
 	}
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
+			c.recordRead(ctx, 0, 1)
 			return ErrItemNotFound
 		}
-		log.Warn().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("getting json failed"))
+		log.Warn().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("getting value failed"))
 		return err
 	}
-	err = json.Unmarshal([]byte(redisResult), modelPtr)
+	c.recordRead(ctx, 1, 0)
+	c.recordPayloadSize(ctx, "Read", len(redisResult))
+	err = c.codec().Unmarshal(redisResult, modelPtr)
 	if err != nil {
 		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("unmarshal failed"))
 		return err
 	}
 	return nil
 }
-func (c *redisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr interface{}) error {
+func (c *redisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "ReadGroup", attribute.Int("cache.key_count", len(keys)))
+	defer func() { c.endSpan(span, err) }()
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -453,15 +671,15 @@ func (c *redisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return ErrNoClientSet
 	}
-	if !c.IsValid(ctx) {
-		return ErrCacheInvalid
+	if err := c.checkValidForRead(ctx); err != nil {
+		return err
 	}
-	redisResult, err := c.redisClient.JSONMGet(ctx, "$", keys...).Result()
+	redisResult, err := c.getValues(ctx, keys)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return ErrItemNotFound
 		}
-		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting json failed"))
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting values failed"))
 		return err
 	}
 	v := reflect.ValueOf(modelArrayPtr)
@@ -477,13 +695,17 @@ func (c *redisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr
 		return err
 	}
 	elemType := v.Type().Elem()
+	hits := 0
+	defer func() { c.recordRead(ctx, hits, len(redisResult)-hits) }()
 	for i := range redisResult {
 		newElem := reflect.New(elemType)
 		if redisResult[i] == nil {
 			log.Debug().Ctx(ctx).Interface("key", keys[i]).Msg(MsgItemNotFound)
 			continue
 		}
-		err = json.Unmarshal([]byte(redisResult[i].(string)), newElem.Interface())
+		hits++
+		c.recordPayloadSize(ctx, "Read", len(redisResult[i]))
+		err = c.codec().Unmarshal(redisResult[i], newElem.Interface())
 		if err != nil {
 			log.Error().Ctx(ctx).Err(err).Interface("key", keys[i]).Msg(c.fmtMsg("unmarshal failed"))
 			return err
@@ -493,9 +715,10 @@ func (c *redisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr
 	return nil
 }
 func (c *redisCache) Delete(ctx context.Context, key string) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -510,9 +733,10 @@ func (c *redisCache) Delete(ctx context.Context, key string) error {
 // Set handling
 
 func (c *redisCache) AddItemToSet(ctx context.Context, key string, item string) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -524,9 +748,10 @@ func (c *redisCache) AddItemToSet(ctx context.Context, key string, item string)
 	return c.redisClient.SAdd(ctx, key, item).Err()
 }
 func (c *redisCache) IsItemInSet(ctx context.Context, key string, item string) (bool, error) {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return false, ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return false, c.configErr()
 	}
 	if c.config.IsDisabled {
 		return false, ErrCachingDisabled
@@ -535,12 +760,17 @@ func (c *redisCache) IsItemInSet(ctx context.Context, key string, item string) (
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return false, ErrNoClientSet
 	}
-	return c.redisClient.SIsMember(ctx, key, item).Result()
+	isMember, err := c.redisClient.SIsMember(ctx, key, item).Result()
+	if isMember && strings.HasPrefix(key, c.KeyForNotFound()) {
+		c.recordNegativeHit(ctx)
+	}
+	return isMember, err
 }
 func (c *redisCache) GetItemsInSetAsMap(ctx context.Context, key string) (map[string]struct{}, error) {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return nil, ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return nil, c.configErr()
 	}
 	if c.config.IsDisabled {
 		return nil, ErrCachingDisabled
@@ -552,9 +782,10 @@ func (c *redisCache) GetItemsInSetAsMap(ctx context.Context, key string) (map[st
 	return c.redisClient.SMembersMap(ctx, key).Result()
 }
 func (c *redisCache) DeleteItemFromSet(ctx context.Context, key string, item string) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -569,9 +800,10 @@ func (c *redisCache) DeleteItemFromSet(ctx context.Context, key string, item str
 // Flag handling
 
 func (c *redisCache) SetFlag(ctx context.Context, key string) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -583,9 +815,10 @@ func (c *redisCache) SetFlag(ctx context.Context, key string) error {
 	return c.redisClient.Set(ctx, key, "", 0).Err()
 }
 func (c *redisCache) SetFlagWithExpiration(ctx context.Context, key string, expirationTime *time.Duration) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -601,9 +834,10 @@ func (c *redisCache) SetFlagWithExpiration(ctx context.Context, key string, expi
 	return c.redisClient.Set(ctx, key, "", *expiration).Err()
 }
 func (c *redisCache) GetFlag(ctx context.Context, key string) (bool, error) {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return false, ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return false, c.configErr()
 	}
 	if c.config.IsDisabled {
 		return false, ErrCachingDisabled
@@ -616,9 +850,10 @@ func (c *redisCache) GetFlag(ctx context.Context, key string) (bool, error) {
 	return count > 0, err
 }
 func (c *redisCache) DeleteFlag(ctx context.Context, key string) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -633,9 +868,10 @@ func (c *redisCache) DeleteFlag(ctx context.Context, key string) error {
 // Index handling
 
 func (c *redisCache) CreateIndex(ctx context.Context, index string, options *redis.FTCreateOptions, fieldSchemas []*redis.FieldSchema) (string, error) {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return "", ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return "", c.configErr()
 	}
 	if c.config.IsDisabled {
 		return "", ErrCachingDisabled
@@ -644,12 +880,20 @@ func (c *redisCache) CreateIndex(ctx context.Context, index string, options *red
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return "", ErrNoClientSet
 	}
-	return c.redisClient.FTCreate(ctx, index, options, fieldSchemas...).Result()
+	if options != nil && options.OnJSON {
+		if err := c.checkSearchable(); err != nil {
+			log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+			return "", err
+		}
+	}
+	return c.redisClient.FTCreate(ctx, c.indexName(index), options, fieldSchemas...).Result()
 }
 func (c *redisCache) SearchInIndex(ctx context.Context, indexName string, queryString string, options *redis.FTSearchOptions, modelArrayPtr interface{}) (totalCount int, err error) {
+	ctx, span := c.startSpan(ctx, "SearchInIndex", attribute.String("cache.index", indexName))
+	defer func() { c.endSpan(span, err) }()
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return 0, ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return 0, c.configErr()
 	}
 	if c.config.IsDisabled {
 		return 0, ErrCachingDisabled
@@ -658,48 +902,17 @@ func (c *redisCache) SearchInIndex(ctx context.Context, indexName string, queryS
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return 0, ErrNoClientSet
 	}
-	redisResult, err := c.redisClient.FTSearchWithArgs(ctx, indexName, queryString, options).Result()
-	if err != nil {
-		if strings.Contains(err.Error(), "No such index") {
-			log.Error().Ctx(ctx).Err(err).Interface("index", indexName).Msg(c.fmtErr(ErrNoSuchIndexFound).Error())
-			return 0, ErrNoSuchIndexFound
-		}
-		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("searching in index failed"))
-		return 0, err
-	}
-	v := reflect.ValueOf(modelArrayPtr)
-	if v.Kind() != reflect.Ptr {
-		err = fmt.Errorf("modelArrayPtr must be a pointer")
-		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
-		return 0, err
-	}
-	v = v.Elem()
-	if v.Kind() != reflect.Slice {
-		err = fmt.Errorf("modelArrayPtr must be a slice")
+	if err = c.checkSearchable(); err != nil {
 		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
 		return 0, err
 	}
-	elemType := v.Type().Elem()
-	for i := range redisResult.Docs {
-		newElem := reflect.New(elemType)
-		if err = json.Unmarshal([]byte(redisResult.Docs[i].Fields["$"]), newElem.Interface()); err != nil {
-			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("unmarshal failed"))
-			return 0, err
-		}
-		v.Set(reflect.Append(v, newElem.Elem()))
-	}
-	var indexInfo redis.FTInfoResult
-	indexInfo, err = c.redisClient.FTInfo(ctx, indexName).Result()
-	if err != nil {
-		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting index info failed"))
-		return 0, err
-	}
-	return indexInfo.NumDocs, nil
+	return c.search(ctx, indexName, queryString, options, modelArrayPtr)
 }
 func (c *redisCache) DeleteIndex(ctx context.Context, index string, deleteDocuments bool) error {
+	ctx = c.circuitContext(ctx)
 	if c.config == nil {
-		log.Error().Ctx(ctx).Err(c.fmtErr(ErrConfigNotSet)).Send()
-		return ErrConfigNotSet
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
 	}
 	if c.config.IsDisabled {
 		return ErrCachingDisabled
@@ -708,38 +921,50 @@ func (c *redisCache) DeleteIndex(ctx context.Context, index string, deleteDocume
 		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
 		return ErrNoClientSet
 	}
-	return c.redisClient.FTDropIndexWithArgs(ctx, index, &redis.FTDropIndexOptions{DeleteDocs: deleteDocuments}).Err()
+	return c.redisClient.FTDropIndexWithArgs(ctx, c.indexName(index), &redis.FTDropIndexOptions{DeleteDocs: deleteDocuments}).Err()
 }
 
 // Key handling
 
 func (c *redisCache) KeyForAll() string {
-	return fmt.Sprintf("%s:ALL", c.name)
+	return fmt.Sprintf("%s:ALL", c.keyPrefix)
 }
 func (c *redisCache) KeyForOne(id uuid.UUID) string {
-	return fmt.Sprintf("%s:ONE:%s", c.name, c.GuidToString(id))
+	return fmt.Sprintf("%s:ONE:%s", c.keyPrefix, c.GuidToString(id))
 }
 func (c *redisCache) KeyForPage(page pagination.PaginatedQuery) string {
-	return fmt.Sprintf("%s:PAGE:%d|%d|%s|%s", c.name, page.PageSize, page.Page, page.Direction, page.Sort)
+	return fmt.Sprintf("%s:PAGE:%d|%d|%s|%s", c.keyPrefix, page.PageSize, page.Page, page.Direction, page.Sort)
 }
 func (c *redisCache) KeyForCustomPage(page pagination.PaginatedQuery, customKey string) string {
 	return fmt.Sprintf("%s:%s", c.KeyForPage(page), customKey)
 }
 func (c *redisCache) KeyForCustom(customKey string) string {
-	return fmt.Sprintf("%s:%s", c.name, customKey)
+	return fmt.Sprintf("%s:%s", c.keyPrefix, customKey)
 }
 func (c *redisCache) KeyForValuedCustom(name string, values ...string) string {
-	return fmt.Sprintf("%s:%s:", c.name, name) + strings.Join(values, "|")
+	return fmt.Sprintf("%s:%s:", c.keyPrefix, name) + strings.Join(values, "|")
 }
 func (c *redisCache) KeyForNotFound() string {
-	return fmt.Sprintf("%s:NOT_FOUND", c.name)
+	return fmt.Sprintf("%s:NOT_FOUND", c.keyPrefix)
 }
 func (c *redisCache) keyForSystem(key string) string {
-	return fmt.Sprintf("%s:SYS:%s", c.name, key)
+	return fmt.Sprintf("%s:SYS:%s", c.keyPrefix, key)
 }
 
 // Helper functions
 
+// marshalContent marshals the content to JSON, strings and byte slices are expected to be JSON already
+func marshalContent(content interface{}) ([]byte, error) {
+	switch v := content.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
 func (c *redisCache) GuidToString(id uuid.UUID) string {
 	return strings.ReplaceAll(id.String(), "-", "_")
 }
@@ -0,0 +1,347 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// KeyCategory is the logical category of a key, given by the KeyFor function which built it
+type KeyCategory string
+
+const (
+	KeyCategoryAll         KeyCategory = "ALL"
+	KeyCategoryOne         KeyCategory = "ONE"
+	KeyCategoryPage        KeyCategory = "PAGE"
+	KeyCategoryNotFound    KeyCategory = "NOT_FOUND"
+	KeyCategoryTag         KeyCategory = "TAG"
+	KeyCategoryJobs        KeyCategory = "JOBS"
+	KeyCategoryCounter     KeyCategory = "COUNTER"
+	KeyCategorySequence    KeyCategory = "SEQUENCE"
+	KeyCategoryIdempotency KeyCategory = "IDEMPOTENCY"
+	KeyCategoryRateLimit   KeyCategory = "RATE"
+	KeyCategorySystem      KeyCategory = "SYS"
+	// KeyCategoryCustom are the keys built by KeyForCustom and KeyForValuedCustom
+	KeyCategoryCustom KeyCategory = "CUSTOM"
+)
+
+// keyCategoriesWithSegment are the categories whose keys start with the category and a colon after the prefix
+var keyCategoriesWithSegment = []KeyCategory{
+	KeyCategoryOne, KeyCategoryPage, KeyCategoryTag, KeyCategoryJobs, KeyCategoryCounter, KeyCategorySequence,
+	KeyCategoryIdempotency, KeyCategoryRateLimit, KeyCategorySystem,
+}
+
+// nonContentKeyCategories are the categories of keys which are not cached content, they are kept when the cache is
+// cleared and not counted by Status
+var nonContentKeyCategories = []KeyCategory{
+	KeyCategorySystem, KeyCategoryJobs, KeyCategoryCounter, KeyCategorySequence, KeyCategoryIdempotency, KeyCategoryRateLimit,
+}
+
+var (
+	ErrInvalidKeyCategory = errors.New("unknown key category")
+	ErrForeignKey         = errors.New("key does not belong to the cache")
+)
+
+// DecodedKey is a key split into the arguments of the KeyFor function which built it
+type DecodedKey struct {
+	Key      string
+	Category KeyCategory
+	// Id is set for ONE keys
+	Id *uuid.UUID
+	// Page is set for PAGE keys, Name is the custom key of KeyForCustomPage
+	Page *pagination.PaginatedQuery
+	// Name is the custom key, the name of KeyForValuedCustom, or the tag, queue, counter, sequence or flag
+	Name string
+	// Values are the values of KeyForValuedCustom, or the day of a sequence
+	Values []string
+}
+
+// KeyInfo describes a key in redis, TTL is nil if the key does not expire and Size is the memory usage in bytes
+type KeyInfo struct {
+	DecodedKey
+	Type string
+	TTL  *time.Duration
+	Size int64
+}
+
+// KeyPage is a page of ListKeys, Cursor is passed to the next call and is 0 after the last page
+type KeyPage struct {
+	Keys   []KeyInfo
+	Cursor uint64
+}
+
+// Key handling
+
+// DecodeKey splits a key of the cache into its category and components. Keys built by KeyForCustom and
+// KeyForValuedCustom can't be distinguished, custom keys containing a colon are decoded as valued keys.
+func (c *redisCache) DecodeKey(key string) (DecodedKey, error) {
+	rest, ok := strings.CutPrefix(key, c.keyPrefix+":")
+	if !ok {
+		return DecodedKey{}, ErrForeignKey
+	}
+	decoded := DecodedKey{Key: key}
+	switch rest {
+	case string(KeyCategoryAll):
+		decoded.Category = KeyCategoryAll
+		return decoded, nil
+	case string(KeyCategoryNotFound):
+		decoded.Category = KeyCategoryNotFound
+		return decoded, nil
+	}
+	segment, name, _ := strings.Cut(rest, ":")
+	decoded.Category = KeyCategoryCustom
+	if slices.Contains(keyCategoriesWithSegment, KeyCategory(segment)) {
+		decoded.Category = KeyCategory(segment)
+		decoded.Name = name
+	}
+	switch decoded.Category {
+	case KeyCategoryOne:
+		if id, err := uuid.Parse(strings.ReplaceAll(name, "_", "-")); err == nil {
+			decoded.Id = &id
+			decoded.Name = ""
+		}
+	case KeyCategoryPage:
+		decoded.Page, decoded.Name = decodePageKey(name)
+	case KeyCategorySequence:
+		if separator := strings.LastIndex(name, ":"); separator >= 0 {
+			decoded.Name, decoded.Values = name[:separator], []string{name[separator+1:]}
+		}
+	case KeyCategoryCustom:
+		decoded.Name = segment
+		if name != "" {
+			decoded.Values = strings.Split(name, "|")
+		}
+	}
+	return decoded, nil
+}
+
+// isContentKey is true for the keys of the cache holding cached content
+func (c *redisCache) isContentKey(key string) bool {
+	decoded, err := c.DecodeKey(key)
+	return err == nil && !slices.Contains(nonContentKeyCategories, decoded.Category)
+}
+
+// Administration
+
+// ListKeys scans up to about count keys of the category, or of all categories if it is empty, starting at the
+// cursor (0 for the first page). Like SCAN, a page may contain fewer keys, or keys listed on an earlier page.
+func (c *redisCache) ListKeys(ctx context.Context, category KeyCategory, cursor uint64, count int64) (page KeyPage, err error) {
+	ctx, span := c.startSpan(ctx, "ListKeys", attribute.String("cache.category", string(category)))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, category, true); err != nil {
+		return KeyPage{}, err
+	}
+	node, err := c.scanNode(ctx)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting node of the cache failed"))
+		return KeyPage{}, err
+	}
+	keys, nextCursor, err := node.Scan(ctx, cursor, c.patternForCategory(category), count).Result()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("scanning keys failed"))
+		return KeyPage{}, err
+	}
+	keys = c.filterCategory(keys, category)
+	infos, err := c.keyInfos(ctx, node, keys)
+	if err != nil {
+		return KeyPage{}, err
+	}
+	return KeyPage{Keys: infos, Cursor: nextCursor}, nil
+}
+
+// InspectKey returns the components, type, TTL and size of a key of the cache, or ErrItemNotFound
+func (c *redisCache) InspectKey(ctx context.Context, key string) (info KeyInfo, err error) {
+	ctx, span := c.startSpan(ctx, "InspectKey", attribute.String("cache.key", key))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, "", true); err != nil {
+		return KeyInfo{}, err
+	}
+	if _, err = c.DecodeKey(key); err != nil {
+		return KeyInfo{}, err
+	}
+	infos, err := c.keyInfos(ctx, c.redisClient, []string{key})
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if len(infos) == 0 {
+		return KeyInfo{}, ErrItemNotFound
+	}
+	return infos[0], nil
+}
+
+// CountKeys counts the keys of the cache by category
+func (c *redisCache) CountKeys(ctx context.Context) (counts map[KeyCategory]int64, err error) {
+	ctx, span := c.startSpan(ctx, "CountKeys")
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, "", true); err != nil {
+		return nil, err
+	}
+	mutex := &sync.Mutex{}
+	counts = make(map[KeyCategory]int64)
+	err = c.scanKeys(ctx, c.patternForCategory(""), 500, func(keys []string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, key := range keys {
+			if decoded, err := c.DecodeKey(key); err == nil {
+				counts[decoded.Category]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("counting keys by category failed"))
+		return nil, err
+	}
+	return counts, nil
+}
+
+// DeleteKeysByCategory deletes all keys of the category and returns the number of deleted keys. Deleting the
+// SYS category invalidates the cache and releases its locks.
+func (c *redisCache) DeleteKeysByCategory(ctx context.Context, category KeyCategory) (deletedCount int64, err error) {
+	ctx, span := c.startSpan(ctx, "DeleteKeysByCategory", attribute.String("cache.category", string(category)))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, category, false); err != nil {
+		return 0, err
+	}
+	mutex := &sync.Mutex{}
+	err = c.scanKeys(ctx, c.patternForCategory(category), 500, func(keys []string) error {
+		keys = c.filterCategory(keys, category)
+		if len(keys) == 0 {
+			return nil
+		}
+		deleted, err := c.deleteKeys(ctx, keys)
+		mutex.Lock()
+		defer mutex.Unlock()
+		deletedCount += deleted
+		return err
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("category", string(category)).Msg(c.fmtMsg("deleting keys by category failed"))
+		return deletedCount, err
+	}
+	return deletedCount, nil
+}
+
+func (c *redisCache) checkAdmin(ctx context.Context, category KeyCategory, allowAllCategories bool) error {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if category == "" && allowAllCategories {
+		return nil
+	}
+	if !isKeyCategory(category) {
+		return ErrInvalidKeyCategory
+	}
+	return nil
+}
+
+// scanNode returns the client to scan the keys with a cursor, in case of a cluster client the master holding
+// the hash slot of the cache
+func (c *redisCache) scanNode(ctx context.Context) (redis.Cmdable, error) {
+	if clusterClient, isCluster := c.redisClient.(*redis.ClusterClient); isCluster {
+		return clusterClient.MasterForKey(ctx, c.KeyForAll())
+	}
+	return c.redisClient, nil
+}
+
+// patternForCategory returns the SCAN pattern of the category, all keys of the cache if it is empty
+func (c *redisCache) patternForCategory(category KeyCategory) string {
+	switch category {
+	case KeyCategoryAll, KeyCategoryNotFound:
+		return fmt.Sprintf("%s:%s", c.keyPrefix, category)
+	case "", KeyCategoryCustom:
+		return fmt.Sprintf("%s:*", c.keyPrefix)
+	default:
+		return fmt.Sprintf("%s:%s:*", c.keyPrefix, category)
+	}
+}
+
+// filterCategory keeps the keys of the category, all keys if it is empty
+func (c *redisCache) filterCategory(keys []string, category KeyCategory) []string {
+	if category == "" {
+		return keys
+	}
+	return slices.DeleteFunc(keys, func(key string) bool {
+		decoded, err := c.DecodeKey(key)
+		return err != nil || decoded.Category != category
+	})
+}
+
+// keyInfos decodes the keys and reads their types, TTLs and sizes, keys which don't exist anymore are skipped
+func (c *redisCache) keyInfos(ctx context.Context, client redis.Cmdable, keys []string) ([]KeyInfo, error) {
+	pipeline := client.Pipeline()
+	typeCmds := make([]*redis.StatusCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	sizeCmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		typeCmds[i] = pipeline.Type(ctx, key)
+		ttlCmds[i] = pipeline.PTTL(ctx, key)
+		sizeCmds[i] = pipeline.MemoryUsage(ctx, key)
+	}
+	if len(keys) > 0 {
+		// Note: errors of MEMORY USAGE, e.g. for keys deleted meanwhile, are ignored and reported as size 0
+		_, _ = pipeline.Exec(ctx)
+	}
+	infos := make([]KeyInfo, 0, len(keys))
+	for i, key := range keys {
+		keyType, err := typeCmds[i].Result()
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(c.fmtMsg("reading key type failed"))
+			return nil, err
+		}
+		if keyType == "none" {
+			continue
+		}
+		ttl, err := ttlCmds[i].Result()
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(c.fmtMsg("reading key TTL failed"))
+			return nil, err
+		}
+		decoded, _ := c.DecodeKey(key)
+		info := KeyInfo{DecodedKey: decoded, Type: keyType, Size: sizeCmds[i].Val()}
+		if ttl >= 0 {
+			info.TTL = &ttl
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func isKeyCategory(category KeyCategory) bool {
+	return category == KeyCategoryAll || category == KeyCategoryNotFound || category == KeyCategoryCustom ||
+		slices.Contains(keyCategoriesWithSegment, category)
+}
+
+// decodePageKey parses the page of KeyForPage and the custom key of KeyForCustomPage
+func decodePageKey(value string) (*pagination.PaginatedQuery, string) {
+	pageValue, customKey, _ := strings.Cut(value, ":")
+	parts := strings.SplitN(pageValue, "|", 4)
+	if len(parts) != 4 {
+		return nil, value
+	}
+	pageSize, pageSizeErr := strconv.Atoi(parts[0])
+	pageNumber, pageErr := strconv.Atoi(parts[1])
+	if pageSizeErr != nil || pageErr != nil {
+		return nil, value
+	}
+	return &pagination.PaginatedQuery{PageSize: pageSize, Page: pageNumber, Direction: parts[2], Sort: parts[3]}, customKey
+}
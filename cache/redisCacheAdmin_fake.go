@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// The fake lists the entries, counters, sequences and job queues in the order of the keys, the cursor is the
+// offset in this order. Types are reported like redis with the default codec, sizes are approximated by the
+// length of the stored values.
+
+func (f *fakeRedisCache) DecodeKey(key string) (DecodedKey, error) {
+	return f.helper.DecodeKey(key)
+}
+
+func (f *fakeRedisCache) ListKeys(ctx context.Context, category KeyCategory, cursor uint64, count int64) (KeyPage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ListKeys", f.helper.patternForCategory(category))
+	if err := f.checkAdmin(category, true); err != nil {
+		return KeyPage{}, err
+	}
+	infos := f.keyInfos(category)
+	if cursor >= uint64(len(infos)) {
+		return KeyPage{Keys: []KeyInfo{}}, nil
+	}
+	if count <= 0 {
+		count = 10
+	}
+	end := min(cursor+uint64(count), uint64(len(infos)))
+	page := KeyPage{Keys: infos[cursor:end]}
+	if end < uint64(len(infos)) {
+		page.Cursor = end
+	}
+	return page, nil
+}
+
+func (f *fakeRedisCache) InspectKey(ctx context.Context, key string) (KeyInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("InspectKey", key)
+	if err := f.checkAdmin("", true); err != nil {
+		return KeyInfo{}, err
+	}
+	if _, err := f.helper.DecodeKey(key); err != nil {
+		return KeyInfo{}, err
+	}
+	for _, info := range f.keyInfos("") {
+		if info.Key == key {
+			return info, nil
+		}
+	}
+	return KeyInfo{}, ErrItemNotFound
+}
+
+func (f *fakeRedisCache) CountKeys(ctx context.Context) (map[KeyCategory]int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("CountKeys")
+	if err := f.checkAdmin("", true); err != nil {
+		return nil, err
+	}
+	counts := make(map[KeyCategory]int64)
+	for _, info := range f.keyInfos("") {
+		counts[info.Category]++
+	}
+	return counts, nil
+}
+
+func (f *fakeRedisCache) DeleteKeysByCategory(ctx context.Context, category KeyCategory) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("DeleteKeysByCategory", f.helper.patternForCategory(category))
+	if err := f.checkAdmin(category, false); err != nil {
+		return 0, err
+	}
+	var deletedCount int64
+	for _, info := range f.keyInfos(category) {
+		deletedCount++
+		delete(f.entries, info.Key)
+		delete(f.counters, info.Key)
+		if info.Category == KeyCategoryJobs {
+			delete(f.jobs, info.Name)
+		}
+	}
+	return deletedCount, nil
+}
+
+// checkAdmin mirrors the checks of the real cache, the mutex must be held
+func (f *fakeRedisCache) checkAdmin(category KeyCategory, allowAllCategories bool) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	if category == "" && allowAllCategories {
+		return nil
+	}
+	if !isKeyCategory(category) {
+		return ErrInvalidKeyCategory
+	}
+	return nil
+}
+
+// keyInfos returns the existing keys of the category ordered by key, all keys if it is empty, the mutex must
+// be held
+func (f *fakeRedisCache) keyInfos(category KeyCategory) []KeyInfo {
+	now := f.now()
+	infos := make([]KeyInfo, 0)
+	add := func(key string, keyType string, size int64, expiresAt *time.Time) {
+		decoded, err := f.helper.DecodeKey(key)
+		if err != nil || (category != "" && decoded.Category != category) {
+			return
+		}
+		info := KeyInfo{DecodedKey: decoded, Type: keyType, Size: size}
+		if expiresAt != nil {
+			ttl := expiresAt.Sub(now)
+			info.TTL = &ttl
+		}
+		infos = append(infos, info)
+	}
+	for key := range f.entries {
+		entry := f.entry(key)
+		if entry == nil {
+			continue
+		}
+		switch entry.kind {
+		case fakeEntrySet:
+			var size int64
+			for member := range entry.members {
+				size += int64(len(member))
+			}
+			add(key, "set", size, entry.expiresAt)
+		case fakeEntryFlag:
+			add(key, "string", 1, entry.expiresAt)
+		default:
+			add(key, "ReJSON-RL", int64(len(entry.value)), entry.expiresAt)
+		}
+	}
+	for key := range f.counters {
+		if f.counter(key) != nil {
+			add(key, "string", 8, f.counters[key].expiresAt)
+		}
+	}
+	for queue, jobs := range f.jobs {
+		if len(jobs) > 0 {
+			add(f.helper.keyForJobQueue(queue), "zset", int64(len(jobs)), nil)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
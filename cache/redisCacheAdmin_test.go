@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeKey(t *testing.T) {
+	cache := NewRedisCache(nil, "test")
+	id := uuid.New()
+	page := pagination.PaginatedQuery{PageSize: 25, Page: 2, Direction: "ascending", Sort: "code"}
+
+	decoded, err := cache.DecodeKey(cache.KeyForAll())
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedKey{Key: "test:ALL", Category: KeyCategoryAll}, decoded)
+	decoded, err = cache.DecodeKey(cache.KeyForOne(id))
+	assert.Nil(t, err)
+	assert.Equal(t, KeyCategoryOne, decoded.Category)
+	assert.Equal(t, id, *decoded.Id)
+	decoded, err = cache.DecodeKey(cache.KeyForPage(page))
+	assert.Nil(t, err)
+	assert.Equal(t, KeyCategoryPage, decoded.Category)
+	assert.Equal(t, page, *decoded.Page)
+	assert.Equal(t, "", decoded.Name)
+	decoded, err = cache.DecodeKey(cache.KeyForCustomPage(page, "instrument"))
+	assert.Nil(t, err)
+	assert.Equal(t, page, *decoded.Page)
+	assert.Equal(t, "instrument", decoded.Name)
+	decoded, err = cache.DecodeKey(cache.KeyForValuedCustom("byCode", "PLASMA", "SERUM"))
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedKey{Key: "test:byCode:PLASMA|SERUM", Category: KeyCategoryCustom, Name: "byCode", Values: []string{"PLASMA", "SERUM"}}, decoded)
+	decoded, err = cache.DecodeKey(cache.KeyForCustom("instruments"))
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedKey{Key: "test:instruments", Category: KeyCategoryCustom, Name: "instruments"}, decoded)
+	decoded, err = cache.DecodeKey(cache.KeyForTag("instrument:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedKey{Key: "test:TAG:instrument:1", Category: KeyCategoryTag, Name: "instrument:1"}, decoded)
+	decoded, err = cache.DecodeKey("test:SEQUENCE:run:20240101")
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedKey{Key: "test:SEQUENCE:run:20240101", Category: KeyCategorySequence, Name: "run", Values: []string{"20240101"}}, decoded)
+	decoded, err = cache.DecodeKey("test:SYS:CACHE_VALID")
+	assert.Nil(t, err)
+	assert.Equal(t, KeyCategorySystem, decoded.Category)
+	assert.Equal(t, "CACHE_VALID", decoded.Name)
+
+	_, err = cache.DecodeKey("test2:ALL")
+	assert.ErrorIs(t, err, ErrForeignKey)
+}
+
+func TestIsContentKey(t *testing.T) {
+	cache := NewRedisCache(nil, "test").(*redisCache)
+	for _, key := range []string{cache.KeyForAll(), cache.KeyForOne(uuid.New()), cache.KeyForTag("plasma"), cache.KeyForCustom("instruments")} {
+		assert.True(t, cache.isContentKey(key), key)
+	}
+	for _, key := range []string{
+		cache.keyForSystem(cacheValidFlagKey), cache.keyForJobQueue("retransmit"), cache.keyForCounter("results"),
+		cache.keyForSequence("run", time.Now()), "test:IDEMPOTENCY:message", "test:RATE:TOKEN_BUCKET:instrument", "test2:ALL",
+	} {
+		assert.False(t, cache.isContentKey(key), key)
+	}
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrInvalidAggregation = errors.New("invalid aggregation")
+
+// Aggregation builds a FT.AGGREGATE query. The steps are executed in the order load, apply, group by,
+// sort, limit and filter, field names can be given with or without @.
+type Aggregation struct {
+	query  string
+	params map[string]interface{}
+	load   []string
+	apply  []redis.FTAggregateApply
+	groups []aggregationGroup
+	sortBy []redis.FTAggregateSortBy
+	offset int
+	limit  int
+	filter string
+	err    error
+}
+
+type aggregationGroup struct {
+	fields   []string
+	reducers []AggregateReducer
+}
+
+// AggregateReducer reduces the rows of a group to one value, named by the alias
+type AggregateReducer struct {
+	reducer redis.SearchAggregator
+	field   string
+	as      string
+}
+
+func ReduceCount(as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchCount, as: as}
+}
+func ReduceCountDistinct(field string, as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchCountDistinct, field: aggregateField(field), as: as}
+}
+func ReduceSum(field string, as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchSum, field: aggregateField(field), as: as}
+}
+func ReduceAvg(field string, as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchAvg, field: aggregateField(field), as: as}
+}
+func ReduceMin(field string, as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchMin, field: aggregateField(field), as: as}
+}
+func ReduceMax(field string, as string) AggregateReducer {
+	return AggregateReducer{reducer: redis.SearchMax, field: aggregateField(field), as: as}
+}
+
+// NewAggregation starts an aggregation of the documents matching the query, e.g. "*" for all documents
+func NewAggregation(query string) *Aggregation {
+	return &Aggregation{query: query}
+}
+
+// Param sets a parameter used as $name in the query
+func (a *Aggregation) Param(name string, value interface{}) *Aggregation {
+	if a.params == nil {
+		a.params = make(map[string]interface{})
+	}
+	a.params[name] = value
+	return a
+}
+
+// Load loads document fields which are not sortable, required to use them in apply expressions
+func (a *Aggregation) Load(fields ...string) *Aggregation {
+	for _, field := range fields {
+		a.load = append(a.load, aggregateField(field))
+	}
+	return a
+}
+
+// Apply adds a field calculated by the expression, e.g. Apply("upper(@name)", "name")
+func (a *Aggregation) Apply(expression string, as string) *Aggregation {
+	if len(a.groups) > 0 {
+		a.setErr("apply must be added before group by")
+	}
+	a.apply = append(a.apply, redis.FTAggregateApply{Field: expression, As: as})
+	return a
+}
+
+// GroupBy groups the rows by the fields, the reducers are added with Reduce
+func (a *Aggregation) GroupBy(fields ...string) *Aggregation {
+	if len(a.sortBy) > 0 || a.limit > 0 {
+		a.setErr("group by must be added before sort by and limit")
+	}
+	group := aggregationGroup{fields: make([]string, 0, len(fields))}
+	for _, field := range fields {
+		group.fields = append(group.fields, aggregateField(field))
+	}
+	a.groups = append(a.groups, group)
+	return a
+}
+
+// Reduce adds reducers to the last group
+func (a *Aggregation) Reduce(reducers ...AggregateReducer) *Aggregation {
+	if len(a.groups) == 0 {
+		a.setErr("reduce requires group by")
+		return a
+	}
+	for _, reducer := range reducers {
+		if reducer.as == "" {
+			a.setErr("reducer alias not set")
+		}
+	}
+	group := &a.groups[len(a.groups)-1]
+	group.reducers = append(group.reducers, reducers...)
+	return a
+}
+
+// SortBy sorts the rows, multiple calls sort by multiple fields
+func (a *Aggregation) SortBy(field string, descending bool) *Aggregation {
+	a.sortBy = append(a.sortBy, redis.FTAggregateSortBy{FieldName: aggregateField(field), Asc: !descending, Desc: descending})
+	return a
+}
+
+// Limit returns count rows starting at offset
+func (a *Aggregation) Limit(offset int, count int) *Aggregation {
+	if offset < 0 || count <= 0 {
+		a.setErr("limit requires a positive count")
+	}
+	a.offset, a.limit = offset, count
+	return a
+}
+
+// Filter removes rows not matching the expression, e.g. Filter("@count > 10")
+func (a *Aggregation) Filter(expression string) *Aggregation {
+	a.filter = expression
+	return a
+}
+
+// Options returns the aggregate options, or the first error of building the aggregation
+func (a *Aggregation) Options() (*redis.FTAggregateOptions, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	options := &redis.FTAggregateOptions{
+		Apply:       a.apply,
+		SortBy:      a.sortBy,
+		LimitOffset: a.offset,
+		Limit:       a.limit,
+		Filter:      a.filter,
+		Params:      a.params,
+	}
+	for _, field := range a.load {
+		options.Load = append(options.Load, redis.FTAggregateLoad{Field: field})
+	}
+	for _, group := range a.groups {
+		groupBy := redis.FTAggregateGroupBy{}
+		for _, field := range group.fields {
+			groupBy.Fields = append(groupBy.Fields, field)
+		}
+		for _, reducer := range group.reducers {
+			aggregateReducer := redis.FTAggregateReducer{Reducer: reducer.reducer, As: reducer.as}
+			if reducer.field != "" {
+				aggregateReducer.Args = []interface{}{reducer.field}
+			}
+			groupBy.Reduce = append(groupBy.Reduce, aggregateReducer)
+		}
+		options.GroupBy = append(options.GroupBy, groupBy)
+	}
+	return options, nil
+}
+
+func (a *Aggregation) setErr(message string) {
+	if a.err == nil {
+		a.err = fmt.Errorf("%w: %s", ErrInvalidAggregation, message)
+	}
+}
+
+// Aggregate executes the aggregation and decodes the rows into modelArrayPtr, a slice of structs with json tags
+// matching the field names and aliases, or of map[string]string
+func (c *redisCache) Aggregate(ctx context.Context, indexName string, aggregation *Aggregation, modelArrayPtr interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "Aggregate", attribute.String("cache.index", indexName))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if err = c.checkSearchable(); err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return err
+	}
+	if aggregation == nil {
+		return fmt.Errorf("%w: aggregation not set", ErrInvalidAggregation)
+	}
+	options, err := aggregation.Options()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return err
+	}
+	redisResult, err := c.redisClient.FTAggregateWithArgs(ctx, c.indexName(indexName), aggregation.query, options).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "No such index") {
+			log.Error().Ctx(ctx).Err(err).Interface("index", indexName).Msg(c.fmtErr(ErrNoSuchIndexFound).Error())
+			return ErrNoSuchIndexFound
+		}
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("aggregating in index failed"))
+		return err
+	}
+	rows := make([]map[string]interface{}, len(redisResult.Rows))
+	for i := range redisResult.Rows {
+		rows[i] = redisResult.Rows[i].Fields
+	}
+	if err = decodeAggregateRows(rows, modelArrayPtr); err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("decoding aggregate rows failed"))
+		return err
+	}
+	return nil
+}
+
+func aggregateField(field string) string {
+	if strings.HasPrefix(field, "@") {
+		return field
+	}
+	return "@" + field
+}
+
+// decodeAggregateRows converts the rows, which contain the values as strings, into the fields with the matching json name
+func decodeAggregateRows(rows []map[string]interface{}, modelArrayPtr interface{}) error {
+	v := reflect.ValueOf(modelArrayPtr)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("modelArrayPtr must be a pointer")
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("modelArrayPtr must be a slice")
+	}
+	elemType := v.Type().Elem()
+	for _, row := range rows {
+		newElem := reflect.New(elemType).Elem()
+		switch {
+		case elemType.Kind() == reflect.Map && elemType.Key().Kind() == reflect.String && elemType.Elem().Kind() == reflect.String:
+			newElem.Set(reflect.MakeMap(elemType))
+			for name, value := range row {
+				newElem.SetMapIndex(reflect.ValueOf(name), reflect.ValueOf(fmt.Sprint(value)).Convert(elemType.Elem()))
+			}
+		case elemType.Kind() == reflect.Struct:
+			for i := 0; i < elemType.NumField(); i++ {
+				field := elemType.Field(i)
+				name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if !field.IsExported() || name == "-" {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+				value, exists := row[name]
+				if !exists || value == nil {
+					continue
+				}
+				if err := setAggregateValue(newElem.Field(i), fmt.Sprint(value)); err != nil {
+					return fmt.Errorf("field %s: %w", field.Name, err)
+				}
+			}
+		default:
+			return fmt.Errorf("modelArrayPtr must be a slice of structs or map[string]string")
+		}
+		v.Set(reflect.Append(v, newElem))
+	}
+	return nil
+}
+
+func setAggregateValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Note: reducers like AVG return floats, which are truncated for integer fields
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(number))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(uint64(number))
+	case reflect.Float32, reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolean)
+	case reflect.Ptr:
+		newValue := reflect.New(field.Type().Elem())
+		if err := setAggregateValue(newValue.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(newValue)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
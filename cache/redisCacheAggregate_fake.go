@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The fake supports aggregations with the query syntax of SearchInIndex, group by with all reducers, sort by and
+// limit. Apply and filter expressions are not supported. Rows without group by contain all fields of the index,
+// fields with multiple values are represented by their first value.
+
+func (f *fakeRedisCache) Aggregate(ctx context.Context, indexName string, aggregation *Aggregation, modelArrayPtr interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Aggregate", indexName)
+	if err := f.check(); err != nil {
+		return err
+	}
+	if err := f.helper.checkSearchable(); err != nil {
+		return err
+	}
+	if aggregation == nil {
+		return fmt.Errorf("%w: aggregation not set", ErrInvalidAggregation)
+	}
+	if _, err := aggregation.Options(); err != nil {
+		return err
+	}
+	if len(aggregation.apply) > 0 || aggregation.filter != "" {
+		return fmt.Errorf("%w: apply and filter", ErrFakeQueryNotSupported)
+	}
+	index, exists := f.indexes[f.resolveIndex(indexName)]
+	if !exists {
+		return ErrNoSuchIndexFound
+	}
+	documents, _, err := f.searchDocuments(index, aggregation.query, &redis.FTSearchOptions{Params: aggregation.params, Limit: math.MaxInt})
+	if err != nil {
+		return err
+	}
+	rows := make([]map[string]interface{}, 0, len(documents))
+	for _, document := range documents {
+		row := make(map[string]interface{})
+		for _, field := range index.fields {
+			name := field.As
+			if name == "" {
+				name = field.FieldName
+			}
+			if values := fieldValues(document.data, field); len(values) > 0 {
+				row[name] = formatFakeAggregateValue(values[0])
+			}
+		}
+		rows = append(rows, row)
+	}
+	for _, group := range aggregation.groups {
+		rows = groupFakeRows(rows, group)
+	}
+	for i := len(aggregation.sortBy) - 1; i >= 0; i-- {
+		sortBy := aggregation.sortBy[i]
+		name := strings.TrimPrefix(sortBy.FieldName, "@")
+		sort.SliceStable(rows, func(a, b int) bool {
+			comparison := compareFakeAggregateValues(rows[a][name], rows[b][name])
+			if sortBy.Desc {
+				return comparison > 0
+			}
+			return comparison < 0
+		})
+	}
+	if aggregation.limit > 0 {
+		rows = rows[min(aggregation.offset, len(rows)):min(aggregation.offset+aggregation.limit, len(rows))]
+	}
+	return decodeAggregateRows(rows, modelArrayPtr)
+}
+
+// groupFakeRows groups the rows by the values of the fields in the order of their first occurrence
+func groupFakeRows(rows []map[string]interface{}, group aggregationGroup) []map[string]interface{} {
+	names := make([]string, len(group.fields))
+	for i := range group.fields {
+		names[i] = strings.TrimPrefix(group.fields[i], "@")
+	}
+	groupKeys := make([]string, 0)
+	groupRows := make(map[string][]map[string]interface{})
+	for _, row := range rows {
+		values := make([]string, len(names))
+		for i := range names {
+			values[i] = fmt.Sprint(row[names[i]])
+		}
+		groupKey := strings.Join(values, "\x00")
+		if _, exists := groupRows[groupKey]; !exists {
+			groupKeys = append(groupKeys, groupKey)
+		}
+		groupRows[groupKey] = append(groupRows[groupKey], row)
+	}
+	result := make([]map[string]interface{}, 0, len(groupKeys))
+	for _, groupKey := range groupKeys {
+		members := groupRows[groupKey]
+		row := make(map[string]interface{})
+		for _, name := range names {
+			row[name] = members[0][name]
+		}
+		for _, reducer := range group.reducers {
+			row[reducer.as] = reduceFakeRows(members, reducer)
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+func reduceFakeRows(rows []map[string]interface{}, reducer AggregateReducer) interface{} {
+	name := strings.TrimPrefix(reducer.field, "@")
+	if reducer.reducer == redis.SearchCount {
+		return strconv.Itoa(len(rows))
+	}
+	if reducer.reducer == redis.SearchCountDistinct {
+		distinct := make(map[interface{}]struct{})
+		for _, row := range rows {
+			if value, exists := row[name]; exists {
+				distinct[value] = struct{}{}
+			}
+		}
+		return strconv.Itoa(len(distinct))
+	}
+	numbers := make([]float64, 0, len(rows))
+	for _, row := range rows {
+		if number, err := strconv.ParseFloat(fmt.Sprint(row[name]), 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	var result float64
+	switch reducer.reducer {
+	case redis.SearchSum, redis.SearchAvg:
+		for _, number := range numbers {
+			result += number
+		}
+		if reducer.reducer == redis.SearchAvg && len(numbers) > 0 {
+			result /= float64(len(numbers))
+		}
+	case redis.SearchMin, redis.SearchMax:
+		if len(numbers) == 0 {
+			return nil
+		}
+		result = numbers[0]
+		for _, number := range numbers[1:] {
+			if (reducer.reducer == redis.SearchMin) == (number < result) {
+				result = number
+			}
+		}
+	}
+	return formatFakeAggregateValue(result)
+}
+
+// formatFakeAggregateValue formats values like RediSearch returns them, as strings
+func formatFakeAggregateValue(value interface{}) interface{} {
+	if number, isNumber := value.(float64); isNumber {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// compareFakeAggregateValues compares numerically if both values are numbers, missing values last
+func compareFakeAggregateValues(a interface{}, b interface{}) int {
+	if a == nil || b == nil {
+		if a == b {
+			return 0
+		} else if a == nil {
+			return 1
+		}
+		return -1
+	}
+	numberA, errA := strconv.ParseFloat(fmt.Sprint(a), 64)
+	numberB, errB := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if errA == nil && errB == nil {
+		return compareFakeValues([]interface{}{numberA}, []interface{}{numberB})
+	}
+	return compareFakeValues([]interface{}{a}, []interface{}{b})
+}
@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitOpenDuration     = 10 * time.Second
+	defaultCircuitHalfOpenProbes   = 1
+)
+
+var ErrCacheUnavailable = errors.New("redis is unavailable, circuit is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breaker. After FailureThreshold consecutive connection failures
+// the circuit opens and commands fail immediately with ErrCacheUnavailable. After OpenDuration up to
+// HalfOpenProbes commands are let through, if they all succeed the circuit closes, otherwise it opens again.
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	HalfOpenProbes   int
+	// OnStateChange is called synchronously on state changes, it should return quickly
+	OnStateChange func(from CircuitState, to CircuitState)
+}
+
+type circuitBreaker struct {
+	mutex     *sync.Mutex
+	name      string
+	config    *CircuitBreakerConfig
+	now       func() time.Time
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+func newCircuitBreaker(name string, now func() time.Time) *circuitBreaker {
+	return &circuitBreaker{
+		mutex: &sync.Mutex{},
+		name:  name,
+		now:   now,
+		state: CircuitClosed,
+	}
+}
+
+// configure replaces the configuration and closes the circuit, without configuration all commands are allowed
+func (b *circuitBreaker) configure(config *CircuitBreakerConfig) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if config != nil {
+		configCopy := *config
+		if configCopy.FailureThreshold <= 0 {
+			configCopy.FailureThreshold = defaultCircuitFailureThreshold
+		}
+		if configCopy.OpenDuration <= 0 {
+			configCopy.OpenDuration = defaultCircuitOpenDuration
+		}
+		if configCopy.HalfOpenProbes <= 0 {
+			configCopy.HalfOpenProbes = defaultCircuitHalfOpenProbes
+		}
+		config = &configCopy
+	}
+	b.config = config
+	b.state, b.failures, b.probes, b.successes = CircuitClosed, 0, 0, 0
+}
+
+func (b *circuitBreaker) State() CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// isOpen is true while the circuit rejects all commands, i.e. before the open duration has passed
+func (b *circuitBreaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.config != nil && b.state == CircuitOpen && b.now().Sub(b.openedAt) < b.config.OpenDuration
+}
+
+// allow returns ErrCacheUnavailable if the command is rejected, probe is true for commands in half-open state
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mutex.Lock()
+	if b.config == nil {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	notify := func() {}
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.config.OpenDuration {
+		notify = b.setState(CircuitHalfOpen)
+	}
+	switch b.state {
+	case CircuitOpen:
+		err = ErrCacheUnavailable
+	case CircuitHalfOpen:
+		if b.probes < b.config.HalfOpenProbes-b.successes {
+			b.probes++
+			probe = true
+		} else {
+			err = ErrCacheUnavailable
+		}
+	}
+	b.mutex.Unlock()
+	notify()
+	return probe, err
+}
+
+// done records the result of an allowed command
+func (b *circuitBreaker) done(probe bool, failed bool) {
+	b.mutex.Lock()
+	if b.config == nil {
+		b.mutex.Unlock()
+		return
+	}
+	notify := func() {}
+	if probe {
+		b.probes--
+	}
+	switch {
+	case failed && (probe || b.state == CircuitHalfOpen):
+		notify = b.setState(CircuitOpen)
+	case failed && b.state == CircuitClosed:
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			notify = b.setState(CircuitOpen)
+		}
+	case !failed && probe && b.state == CircuitHalfOpen:
+		b.successes++
+		if b.successes >= b.config.HalfOpenProbes {
+			notify = b.setState(CircuitClosed)
+		}
+	case !failed && b.state == CircuitClosed:
+		b.failures = 0
+	}
+	b.mutex.Unlock()
+	notify()
+}
+
+// setState changes the state and returns the notification to call after unlocking, the mutex must be held
+func (b *circuitBreaker) setState(state CircuitState) func() {
+	from := b.state
+	b.state, b.failures, b.probes, b.successes = state, 0, 0, 0
+	if state == CircuitOpen {
+		b.openedAt = b.now()
+	}
+	onStateChange := b.config.OnStateChange
+	return func() {
+		event := log.Info()
+		if state == CircuitOpen {
+			event = log.Warn()
+		}
+		event.Str("from", from.String()).Str("to", state.String()).Msgf("redisCache / %s: circuit state changed", b.name)
+		if onStateChange != nil {
+			onStateChange(from, state)
+		}
+	}
+}
+
+type circuitContextKey struct{}
+
+// circuitBreakerHook applies its circuit breaker to the commands with the breaker in their context. Each circuit
+// breaker installs its own hook, commands of other caches and users of the client are neither counted nor rejected.
+type circuitBreakerHook struct {
+	breaker *circuitBreaker
+}
+
+func (h circuitBreakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+func (h circuitBreakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !h.applies(ctx) {
+			return next(ctx, cmd)
+		}
+		probe, err := h.breaker.allow()
+		if err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err = next(ctx, cmd)
+		h.breaker.done(probe, isConnectionError(err))
+		return err
+	}
+}
+func (h circuitBreakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !h.applies(ctx) {
+			return next(ctx, cmds)
+		}
+		probe, err := h.breaker.allow()
+		if err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err = next(ctx, cmds)
+		h.breaker.done(probe, isConnectionError(err))
+		return err
+	}
+}
+
+// applies is true if the command was issued by a cache of the circuit breaker
+func (h circuitBreakerHook) applies(ctx context.Context) bool {
+	breaker, _ := ctx.Value(circuitContextKey{}).(*circuitBreaker)
+	return breaker == h.breaker
+}
+
+// isConnectionError is true for errors not replied by redis, e.g. refused connections and timeouts
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) || errors.Is(err, ErrCacheUnavailable) {
+		return false
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, redis.ErrPoolTimeout) || errors.Is(err, redis.ErrClosed)
+}
+
+// initCircuitBreaker creates the circuit breaker of the cache and installs its hook on the client on the first
+// initialization, the hook lives as long as the client. The circuit breaker inherited by a tenant is kept as it is.
+func (c *redisCache) initCircuitBreaker() {
+	if c.redisClient == nil || c.breakerInherited || (c.breaker == nil && c.config.CircuitBreaker == nil) {
+		return
+	}
+	if c.breaker == nil {
+		c.breaker = newCircuitBreaker(c.name, time.Now)
+		c.redisClient.AddHook(circuitBreakerHook{breaker: c.breaker})
+	}
+	c.breaker.configure(c.config.CircuitBreaker)
+}
+
+// circuitContext adds the circuit breaker of the cache to the context of its commands. A context of another cache
+// is replaced, so a cache used in the refresh functions of another one applies its own circuit breaker.
+func (c *redisCache) circuitContext(ctx context.Context) context.Context {
+	if current, _ := ctx.Value(circuitContextKey{}).(*circuitBreaker); current == c.breaker {
+		return ctx
+	}
+	return context.WithValue(ctx, circuitContextKey{}, c.breaker)
+}
+
+// checkCircuit returns ErrCacheUnavailable while the circuit is open, without calling redis
+func (c *redisCache) checkCircuit() error {
+	if c.breaker != nil && c.breaker.isOpen() {
+		return ErrCacheUnavailable
+	}
+	return nil
+}
+
+// checkValidForRead returns ErrCacheInvalid if the cache is invalid, or ErrCacheUnavailable if it is invalid
+// because the circuit is open
+func (c *redisCache) checkValidForRead(ctx context.Context) error {
+	if c.IsValid(ctx) {
+		return nil
+	}
+	if err := c.checkCircuit(); err != nil {
+		return err
+	}
+	c.recordInvalidRead(ctx)
+	return ErrCacheInvalid
+}
+
+func (c *redisCache) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.State()
+}
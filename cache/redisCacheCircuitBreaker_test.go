@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerWithUnreachableRedis(t *testing.T) {
+	ctx := context.Background()
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1, DialTimeout: time.Second})
+	defer redisClient.Close()
+	cache := NewRedisCache(redisClient, "test")
+	var transitions []CircuitState
+	cache.Init(RedisCacheConfig{CircuitBreaker: &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenDuration:     time.Hour,
+		OnStateChange: func(from CircuitState, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	}}, nil, nil)
+
+	assert.NotErrorIs(t, cache.Store(ctx, cache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+	assert.NotErrorIs(t, cache.Store(ctx, cache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+	assert.Equal(t, CircuitOpen, cache.CircuitState())
+	assert.ErrorIs(t, cache.Store(ctx, cache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+	var value int
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrCacheUnavailable)
+	assert.False(t, cache.IsValid(ctx))
+	assert.Equal(t, []CircuitState{CircuitOpen}, transitions)
+
+	// Tenants share the circuit breaker, initializing them keeps its configuration and state
+	tenantCache, err := cache.WithTenant("berlin")
+	assert.Nil(t, err)
+	tenantCache.Init(RedisCacheConfig{}, nil, nil)
+	assert.Equal(t, CircuitOpen, cache.CircuitState())
+	assert.ErrorIs(t, cache.Store(ctx, cache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+	assert.ErrorIs(t, tenantCache.Store(ctx, tenantCache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+
+	// Other users of the client are neither rejected nor counted, also if they have a circuit breaker
+	otherCache := NewRedisCache(redisClient, "other")
+	otherCache.Init(RedisCacheConfig{CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 10}}, nil, nil)
+	assert.NotErrorIs(t, otherCache.Store(ctx, otherCache.KeyForCustom("key"), "1"), ErrCacheUnavailable)
+	assert.NotErrorIs(t, redisClient.Ping(ctx).Err(), ErrCacheUnavailable)
+	assert.Equal(t, CircuitClosed, otherCache.CircuitState())
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.False(t, isConnectionError(nil))
+	assert.False(t, isConnectionError(redis.Nil))
+	assert.False(t, isConnectionError(context.Canceled))
+	assert.False(t, isConnectionError(ErrCacheUnavailable))
+	assert.False(t, isConnectionError(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")))
+	assert.True(t, isConnectionError(fmt.Errorf("reading reply: %w", context.DeadlineExceeded)))
+	assert.True(t, isConnectionError(redis.ErrPoolTimeout))
+}
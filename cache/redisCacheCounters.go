@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/timezone"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	counterKeySegment  = "COUNTER:"
+	sequenceKeySegment = "SEQUENCE:"
+	sequenceDayFormat  = "20060102"
+)
+
+var (
+	ErrInvalidCounter   = errors.New("counter name must not be empty")
+	ErrInvalidSequence  = errors.New("sequence name must not be empty")
+	ErrInvalidWallClock = errors.New("wall-clock time must be within 00:00:00 and 23:59:59")
+)
+
+// WallClock is a time of day in the CounterTimeZone of the cache, e.g. WallClock{} is midnight
+type WallClock struct {
+	Hour   int
+	Minute int
+	Second int
+}
+
+// SequenceSeedFunc returns the highest number issued for the sequence on the day, e.g. from the identifiers
+// stored in Postgres, or 0 if none was issued
+type SequenceSeedFunc func(ctx context.Context, sequence string, day time.Time) (int64, error)
+
+// SequenceNumber is a number of a per-day sequence, Day is midnight in the CounterTimeZone of the cache
+type SequenceNumber struct {
+	Day    time.Time
+	Number int64
+}
+
+// incrementCounterScript increments the counter by ARGV[1] and sets the expiration at the unix milliseconds
+// ARGV[2], unless it is empty or the counter already expires
+var incrementCounterScript = redis.NewScript(`
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if ARGV[2] ~= '' and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// nextSequenceScript increments the sequence, it returns false if the sequence does not exist and no seed
+// (ARGV[1]) is given. The seed only raises the sequence, so it never goes backwards if seeded concurrently.
+var nextSequenceScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	if ARGV[1] == '' then
+		return false
+	end
+	current = 0
+end
+if ARGV[1] ~= '' and tonumber(ARGV[1]) > tonumber(current) then
+	redis.call('SET', KEYS[1], ARGV[1], 'KEEPTTL')
+end
+local value = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// Counter handling
+
+// IncrementCounter atomically increments the counter by value and returns the new value. If resetAt is set, a
+// new counter expires at the next occurrence of the wall-clock time, e.g. at midnight for daily counters.
+func (c *redisCache) IncrementCounter(ctx context.Context, counter string, value int64, resetAt *WallClock) (result int64, err error) {
+	ctx, span := c.startSpan(ctx, "IncrementCounter", attribute.String("cache.counter", counter))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkCounter(ctx, counter, ErrInvalidCounter); err != nil {
+		return 0, err
+	}
+	expireAt := ""
+	if resetAt != nil {
+		var location *time.Location
+		if location, err = counterLocation(c.config); err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("timeZone", string(c.config.CounterTimeZone)).Msg(c.fmtMsg("loading counter time zone failed"))
+			return 0, err
+		}
+		var next time.Time
+		if next, err = nextWallClock(time.Now(), location, *resetAt); err != nil {
+			return 0, err
+		}
+		expireAt = strconv.FormatInt(next.UnixMilli(), 10)
+	}
+	result, err = incrementCounterScript.Run(ctx, c.redisClient, []string{c.keyForCounter(counter)}, value, expireAt).Int64()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("counter", counter).Msg(c.fmtMsg("incrementing counter failed"))
+		return 0, err
+	}
+	return result, nil
+}
+
+// GetCounter returns the value of the counter, 0 if it does not exist or expired
+func (c *redisCache) GetCounter(ctx context.Context, counter string) (result int64, err error) {
+	ctx, span := c.startSpan(ctx, "GetCounter", attribute.String("cache.counter", counter))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkCounter(ctx, counter, ErrInvalidCounter); err != nil {
+		return 0, err
+	}
+	result, err = c.redisClient.Get(ctx, c.keyForCounter(counter)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("counter", counter).Msg(c.fmtMsg("reading counter failed"))
+		return 0, err
+	}
+	return result, nil
+}
+
+// ResetCounter deletes the counter, so it starts again from 0
+func (c *redisCache) ResetCounter(ctx context.Context, counter string) (err error) {
+	ctx, span := c.startSpan(ctx, "ResetCounter", attribute.String("cache.counter", counter))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkCounter(ctx, counter, ErrInvalidCounter); err != nil {
+		return err
+	}
+	if err = c.redisClient.Del(ctx, c.keyForCounter(counter)).Err(); err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("counter", counter).Msg(c.fmtMsg("resetting counter failed"))
+		return err
+	}
+	return nil
+}
+
+// NextSequenceNumber returns the next number of the sequence for the current day in the CounterTimeZone,
+// starting at 1 each day. If the sequence of the day does not exist in redis, e.g. on first use or after a
+// flush, it is seeded with the result of seed, so the numbers never go backwards. Without seed it starts at 1.
+func (c *redisCache) NextSequenceNumber(ctx context.Context, sequence string, seed SequenceSeedFunc) (number SequenceNumber, err error) {
+	ctx, span := c.startSpan(ctx, "NextSequenceNumber", attribute.String("cache.sequence", sequence))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkCounter(ctx, sequence, ErrInvalidSequence); err != nil {
+		return SequenceNumber{}, err
+	}
+	location, err := counterLocation(c.config)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("timeZone", string(c.config.CounterTimeZone)).Msg(c.fmtMsg("loading counter time zone failed"))
+		return SequenceNumber{}, err
+	}
+	day, expireAt := sequenceDay(time.Now(), location)
+	keys := []string{c.keyForSequence(sequence, day)}
+	value, err := nextSequenceScript.Run(ctx, c.redisClient, keys, "", expireAt.UnixMilli()).Int64()
+	if errors.Is(err, redis.Nil) {
+		var seeded int64
+		if seed != nil {
+			if seeded, err = seed(ctx, sequence, day); err != nil {
+				log.Error().Ctx(ctx).Err(err).Str("sequence", sequence).Msg(c.fmtMsg("seeding sequence failed"))
+				return SequenceNumber{}, err
+			}
+		}
+		value, err = nextSequenceScript.Run(ctx, c.redisClient, keys, seeded, expireAt.UnixMilli()).Int64()
+	}
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("sequence", sequence).Msg(c.fmtMsg("incrementing sequence failed"))
+		return SequenceNumber{}, err
+	}
+	return SequenceNumber{Day: day, Number: value}, nil
+}
+
+func (c *redisCache) checkCounter(ctx context.Context, name string, errInvalidName error) error {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if name == "" {
+		return errInvalidName
+	}
+	return nil
+}
+
+// keyForCounter is the key of the counter, keys of counters and sequences are kept when clearing the cache
+func (c *redisCache) keyForCounter(counter string) string {
+	return fmt.Sprintf("%s:%s%s", c.keyPrefix, counterKeySegment, counter)
+}
+
+func (c *redisCache) keyForSequence(sequence string, day time.Time) string {
+	return fmt.Sprintf("%s:%s%s:%s", c.keyPrefix, sequenceKeySegment, sequence, day.Format(sequenceDayFormat))
+}
+
+// counterLocation returns the location of the CounterTimeZone, defaulting to UTC
+func counterLocation(config *RedisCacheConfig) (*time.Location, error) {
+	if config.CounterTimeZone == "" {
+		return timezone.UTC.GetLocation()
+	}
+	return config.CounterTimeZone.GetLocation()
+}
+
+// nextWallClock returns the next occurrence of the wall-clock time after now in the location. Times skipped by a
+// daylight saving transition are normalized by time.Date.
+func nextWallClock(now time.Time, location *time.Location, clock WallClock) (time.Time, error) {
+	if clock.Hour < 0 || clock.Hour > 23 || clock.Minute < 0 || clock.Minute > 59 || clock.Second < 0 || clock.Second > 59 {
+		return time.Time{}, ErrInvalidWallClock
+	}
+	local := now.In(location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour, clock.Minute, clock.Second, 0, location)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, clock.Hour, clock.Minute, clock.Second, 0, location)
+	}
+	return next, nil
+}
+
+// sequenceDay returns the start of the day of now in the location, and the expiration of its sequence. The
+// sequence is kept for a day longer, so replicas with a late clock don't need to seed it again.
+func sequenceDay(now time.Time, location *time.Location) (time.Time, time.Time) {
+	local := now.In(location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	return day, time.Date(local.Year(), local.Month(), local.Day()+2, 0, 0, 0, 0, location)
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// The fake keeps counters and sequences apart from the other entries, so like in the real cache they are not
+// removed by refreshes. Expirations and days are computed with the clock set by SetClock.
+
+type fakeCounter struct {
+	value     int64
+	expiresAt *time.Time
+}
+
+func (f *fakeRedisCache) IncrementCounter(ctx context.Context, counter string, value int64, resetAt *WallClock) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := f.helper.keyForCounter(counter)
+	f.record("IncrementCounter", key)
+	if err := f.checkCounter(counter, ErrInvalidCounter); err != nil {
+		return 0, err
+	}
+	var expiresAt *time.Time
+	if resetAt != nil {
+		location, err := counterLocation(f.config)
+		if err != nil {
+			return 0, err
+		}
+		next, err := nextWallClock(f.now(), location, *resetAt)
+		if err != nil {
+			return 0, err
+		}
+		expiresAt = &next
+	}
+	return f.incrementCounter(key, value, expiresAt), nil
+}
+
+func (f *fakeRedisCache) GetCounter(ctx context.Context, counter string) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := f.helper.keyForCounter(counter)
+	f.record("GetCounter", key)
+	if err := f.checkCounter(counter, ErrInvalidCounter); err != nil {
+		return 0, err
+	}
+	if current := f.counter(key); current != nil {
+		return current.value, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeRedisCache) ResetCounter(ctx context.Context, counter string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := f.helper.keyForCounter(counter)
+	f.record("ResetCounter", key)
+	if err := f.checkCounter(counter, ErrInvalidCounter); err != nil {
+		return err
+	}
+	delete(f.counters, key)
+	return nil
+}
+
+func (f *fakeRedisCache) NextSequenceNumber(ctx context.Context, sequence string, seed SequenceSeedFunc) (SequenceNumber, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.checkCounter(sequence, ErrInvalidSequence); err != nil {
+		f.record("NextSequenceNumber")
+		return SequenceNumber{}, err
+	}
+	location, err := counterLocation(f.config)
+	if err != nil {
+		f.record("NextSequenceNumber")
+		return SequenceNumber{}, err
+	}
+	day, expiresAt := sequenceDay(f.now(), location)
+	key := f.helper.keyForSequence(sequence, day)
+	f.record("NextSequenceNumber", key)
+	if f.counter(key) == nil && seed != nil {
+		// Note: the real cache calls seed without holding a lock as well
+		f.mutex.Unlock()
+		seeded, err := seed(ctx, sequence, day)
+		f.mutex.Lock()
+		if err != nil {
+			return SequenceNumber{}, err
+		}
+		if current := f.counter(key); current == nil {
+			f.counters[key] = &fakeCounter{value: seeded, expiresAt: &expiresAt}
+		} else {
+			current.value = max(current.value, seeded)
+		}
+	}
+	return SequenceNumber{Day: day, Number: f.incrementCounter(key, 1, &expiresAt)}, nil
+}
+
+// checkCounter mirrors the checks of the real cache, the mutex must be held
+func (f *fakeRedisCache) checkCounter(name string, errInvalidName error) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	if name == "" {
+		return errInvalidName
+	}
+	return nil
+}
+
+// counter returns the counter of the key, removing it if expired, the mutex must be held
+func (f *fakeRedisCache) counter(key string) *fakeCounter {
+	current, ok := f.counters[key]
+	if ok && current.expiresAt != nil && !current.expiresAt.After(f.now()) {
+		delete(f.counters, key)
+		return nil
+	}
+	return current
+}
+
+// incrementCounter increments the counter of the key and sets the expiration of a counter without one, the mutex
+// must be held
+func (f *fakeRedisCache) incrementCounter(key string, value int64, expiresAt *time.Time) int64 {
+	current := f.counter(key)
+	if current == nil {
+		current = &fakeCounter{}
+		f.counters[key] = current
+	}
+	current.value += value
+	if current.expiresAt == nil && expiresAt != nil {
+		expiration := time.UnixMilli(expiresAt.UnixMilli())
+		current.expiresAt = &expiration
+	}
+	return current.value
+}
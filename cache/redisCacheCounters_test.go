@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/timezone"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextWallClock(t *testing.T) {
+	location, err := timezone.EuropeBerlin.GetLocation()
+	assert.Nil(t, err)
+	now := time.Date(2024, 3, 30, 12, 0, 0, 0, location)
+	next, err := nextWallClock(now, location, WallClock{Hour: 18})
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-03-30T18:00:00+01:00", next.Format(time.RFC3339))
+	next, err = nextWallClock(now, location, WallClock{})
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-03-31T00:00:00+01:00", next.Format(time.RFC3339))
+
+	// Across the daylight saving transition
+	next, err = nextWallClock(now, location, WallClock{Hour: 6, Minute: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-03-31T06:30:00+02:00", next.Format(time.RFC3339))
+	assert.Equal(t, 17*time.Hour+30*time.Minute, next.Sub(now))
+
+	_, err = nextWallClock(now, location, WallClock{Minute: 60})
+	assert.ErrorIs(t, err, ErrInvalidWallClock)
+}
+
+func TestSequenceDay(t *testing.T) {
+	location, err := timezone.EuropeBerlin.GetLocation()
+	assert.Nil(t, err)
+	day, expireAt := sequenceDay(time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC), location)
+	assert.Equal(t, "2024-01-02T00:00:00+01:00", day.Format(time.RFC3339))
+	assert.Equal(t, "2024-01-04T00:00:00+01:00", expireAt.Format(time.RFC3339))
+}
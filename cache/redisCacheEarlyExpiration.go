@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultEarlyExpirationBeta = 1.0
+
+// earlyExpirationEnvelope wraps values stored by ReadOrCompute with the metadata of XFetch
+type earlyExpirationEnvelope struct {
+	Value json.RawMessage `json:"value"`
+	// Delta is the duration of the computation in milliseconds
+	Delta int64 `json:"delta"`
+	// Expiry is the expiration as unix milliseconds
+	Expiry int64 `json:"expiry"`
+}
+
+// ReadOrCompute reads the value computed by compute, which is stored with its compute time and expiry. Before
+// the value expires it is recomputed early with a probability rising with the compute time and the closeness
+// of the expiry (XFetch), so only few replicas hit the database for popular keys. Without expiration the
+// DefaultExpiration is used. With an invalid cache the value is computed, but not stored.
+func (c *redisCache) ReadOrCompute(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration, compute func(ctx context.Context) (interface{}, error)) (err error) {
+	ctx, span := c.startSpan(ctx, "ReadOrCompute", attribute.String("cache.key", key))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	expiration := c.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	if expiration == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrExpirationNotSet)).Send()
+		return ErrExpirationNotSet
+	}
+	if !c.IsValid(ctx) {
+		c.recordInvalidRead(ctx)
+		envelope, err := computeEnvelope(ctx, compute, time.Now, *expiration)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(envelope.Value, modelPtr)
+	}
+	payload, err := c.getValue(ctx, c.redisClient, key)()
+	if err == nil {
+		var envelope earlyExpirationEnvelope
+		if err = c.codec().Unmarshal(payload, &envelope); err != nil {
+			log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("unmarshal failed"))
+			return err
+		}
+		if !shouldRecomputeEarly(time.Now(), envelope, earlyExpirationBeta(c.config), rand.Float64()) {
+			c.recordRead(ctx, 1, 0)
+			c.recordPayloadSize(ctx, "Read", len(payload))
+			return json.Unmarshal(envelope.Value, modelPtr)
+		}
+		span.SetAttributes(attribute.Bool("cache.early_recompute", true))
+	} else if !errors.Is(err, redis.Nil) {
+		log.Warn().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("getting value failed"))
+		return err
+	}
+	// Note: early recomputations are counted as misses, as they load from the database as well
+	c.recordRead(ctx, 0, 1)
+	envelope, err := computeEnvelope(ctx, compute, time.Now, expirationWithJitter(*expiration, c.config.ExpirationJitter))
+	if err != nil {
+		return err
+	}
+	payload, err = c.codec().Marshal(envelope)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
+	pipeline := c.redisClient.Pipeline()
+	c.setValue(ctx, pipeline, key, payload)
+	pipeline.PExpireAt(ctx, key, time.UnixMilli(envelope.Expiry))
+	if _, err := pipeline.Exec(ctx); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("storing computed value failed"))
+	}
+	return json.Unmarshal(envelope.Value, modelPtr)
+}
+
+// computeEnvelope calls compute and measures its duration with the clock
+func computeEnvelope(ctx context.Context, compute func(ctx context.Context) (interface{}, error), now func() time.Time, expiration time.Duration) (earlyExpirationEnvelope, error) {
+	start := now()
+	content, err := compute(ctx)
+	if err != nil {
+		return earlyExpirationEnvelope{}, err
+	}
+	value, err := marshalContent(content)
+	if err != nil {
+		return earlyExpirationEnvelope{}, err
+	}
+	end := now()
+	return earlyExpirationEnvelope{
+		Value:  value,
+		Delta:  end.Sub(start).Milliseconds(),
+		Expiry: end.Add(expiration).UnixMilli(),
+	}, nil
+}
+
+// shouldRecomputeEarly implements XFetch: now - delta * beta * ln(random) >= expiry, random in [0, 1)
+func shouldRecomputeEarly(now time.Time, envelope earlyExpirationEnvelope, beta float64, random float64) bool {
+	gap := -float64(envelope.Delta) * beta * math.Log(random)
+	return float64(now.UnixMilli())+gap >= float64(envelope.Expiry)
+}
+
+func earlyExpirationBeta(config *RedisCacheConfig) float64 {
+	if config.EarlyExpirationBeta > 0 {
+		return config.EarlyExpirationBeta
+	}
+	return defaultEarlyExpirationBeta
+}
+
+// expirationWithJitter extends the expiration by a random duration up to the jitter
+func expirationWithJitter(expiration time.Duration, jitter *time.Duration) time.Duration {
+	if jitter != nil && *jitter > 0 {
+		expiration += time.Duration(rand.Int63n(int64(*jitter)))
+	}
+	return expiration
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// The fake measures the compute time with the clock set by SetClock, so early recomputation can be tested by
+// advancing the clock inside compute
+
+func (f *fakeRedisCache) ReadOrCompute(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration, compute func(ctx context.Context) (interface{}, error)) error {
+	f.mutex.Lock()
+	f.record("ReadOrCompute", key)
+	// Note: like the real cache, the value is computed but not stored while the circuit is open
+	checkErr := f.check()
+	if checkErr != nil && !errors.Is(checkErr, ErrCacheUnavailable) {
+		f.mutex.Unlock()
+		return checkErr
+	}
+	expiration, err := f.expiration(expirationTime)
+	if err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+	valid, now, jitter := checkErr == nil && f.cacheValid, f.now, f.config.ExpirationJitter
+	if entry := f.entry(key); valid && entry != nil {
+		var envelope earlyExpirationEnvelope
+		if entry.kind != fakeEntryValue {
+			err = ErrFakeWrongType
+		} else {
+			err = json.Unmarshal(entry.value, &envelope)
+		}
+		if err != nil {
+			f.mutex.Unlock()
+			return err
+		}
+		if !shouldRecomputeEarly(now(), envelope, earlyExpirationBeta(f.config), rand.Float64()) {
+			f.mutex.Unlock()
+			return json.Unmarshal(envelope.Value, modelPtr)
+		}
+	}
+	// Note: the mutex is released while computing, so compute can use the cache
+	f.mutex.Unlock()
+	envelope, err := computeEnvelope(ctx, compute, now, expirationWithJitter(*expiration, jitter))
+	if err != nil {
+		return err
+	}
+	if valid {
+		f.mutex.Lock()
+		payload, err := json.Marshal(envelope)
+		if err == nil {
+			expiresAt := time.UnixMilli(envelope.Expiry)
+			f.entries[key] = &fakeEntry{kind: fakeEntryValue, value: payload, expiresAt: &expiresAt}
+		}
+		f.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(envelope.Value, modelPtr)
+}
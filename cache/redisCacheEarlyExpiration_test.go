@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRecomputeEarly(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	envelope := earlyExpirationEnvelope{Delta: time.Second.Milliseconds(), Expiry: now.Add(10 * time.Second).UnixMilli()}
+
+	// -ln(0.5) is about 0.69, so the gap is 0.69s with beta 1 and 13.9s with beta 20
+	assert.False(t, shouldRecomputeEarly(now, envelope, 1, 0.5))
+	assert.True(t, shouldRecomputeEarly(now, envelope, 20, 0.5))
+	assert.True(t, shouldRecomputeEarly(now.Add(9500*time.Millisecond), envelope, 1, 0.5))
+	assert.True(t, shouldRecomputeEarly(now.Add(10*time.Second), envelope, 1, 0.999))
+	assert.False(t, shouldRecomputeEarly(now, earlyExpirationEnvelope{Expiry: envelope.Expiry}, 20, 0.001))
+}
+
+func TestExpirationWithJitter(t *testing.T) {
+	jitter := time.Minute
+	for i := 0; i < 100; i++ {
+		expiration := expirationWithJitter(time.Hour, &jitter)
+		assert.GreaterOrEqual(t, expiration, time.Hour)
+		assert.Less(t, expiration, time.Hour+time.Minute)
+	}
+	assert.Equal(t, time.Hour, expirationWithJitter(time.Hour, nil))
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultGroupChunkSize = 1000
+
+// GroupError is returned by group operations if some of the keys failed, the other keys are processed
+type GroupError struct {
+	Failures map[string]error
+}
+
+func (e *GroupError) Error() string {
+	return fmt.Sprintf("%d keys failed in group operation", len(e.Failures))
+}
+func (e *GroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, err := range e.Failures {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Group handling
+
+// ReadGroupAsMap reads the keys in chunks into the map pointed to by modelMapPtr, keyed by the cache key, and
+// returns the keys not found in the cache in the order of the input
+func (c *redisCache) ReadGroupAsMap(ctx context.Context, keys []string, modelMapPtr interface{}) (missingKeys []string, err error) {
+	ctx, span := c.startSpan(ctx, "ReadGroupAsMap", attribute.Int("cache.key_count", len(keys)))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return nil, c.configErr()
+	}
+	if c.config.IsDisabled {
+		return nil, ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return nil, ErrNoClientSet
+	}
+	if err := c.checkValidForRead(ctx); err != nil {
+		return nil, err
+	}
+	v, err := modelMapValue(modelMapPtr)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return nil, err
+	}
+	values, err := c.getValues(ctx, keys)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting values failed"))
+		return nil, err
+	}
+	missingKeys = make([]string, 0)
+	elemType := v.Type().Elem()
+	for i := range values {
+		if values[i] == nil {
+			missingKeys = append(missingKeys, keys[i])
+			continue
+		}
+		c.recordPayloadSize(ctx, "Read", len(values[i]))
+		newElem := reflect.New(elemType)
+		if err = c.codec().Unmarshal(values[i], newElem.Interface()); err != nil {
+			log.Error().Ctx(ctx).Err(err).Interface("key", keys[i]).Msg(c.fmtMsg("unmarshal failed"))
+			return nil, err
+		}
+		v.SetMapIndex(reflect.ValueOf(keys[i]), newElem.Elem())
+	}
+	c.recordRead(ctx, len(values)-len(missingKeys), len(missingKeys))
+	return missingKeys, nil
+}
+
+// StoreGroup stores all contents pipelined in chunks, without expiration the DefaultExpiration is used if set
+func (c *redisCache) StoreGroup(ctx context.Context, contents map[string]interface{}, expirationTime *time.Duration) (err error) {
+	ctx, span := c.startSpan(ctx, "StoreGroup", attribute.Int("cache.key_count", len(contents)))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	expiration := c.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	keys := sortedKeys(contents)
+	failures := make(map[string]error)
+	err = utils.Partition(len(keys), c.groupChunkSize(), func(low int, high int) error {
+		pipeline := c.redisClient.Pipeline()
+		commands := make(map[string][]redis.Cmder)
+		for _, key := range keys[low:high] {
+			payload, err := c.codec().Marshal(contents[key])
+			if err != nil {
+				failures[key] = err
+				continue
+			}
+			c.recordPayloadSize(ctx, "Store", len(payload))
+			commands[key] = append(commands[key], c.setValue(ctx, pipeline, key, payload))
+			if expiration != nil {
+				commands[key] = append(commands[key], pipeline.Expire(ctx, key, expirationWithJitter(*expiration, c.config.ExpirationJitter)))
+			}
+		}
+		if len(commands) == 0 {
+			return nil
+		}
+		_, err := pipeline.Exec(ctx)
+		if err != nil && !hasCommandErrors(commands) {
+			return err
+		}
+		collectCommandErrors(commands, failures)
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("storing group failed"))
+		return err
+	}
+	if len(failures) > 0 {
+		log.Warn().Ctx(ctx).Int("failed", len(failures)).Int("total", len(keys)).Msg(c.fmtMsg("storing group partially failed"))
+		return &GroupError{Failures: failures}
+	}
+	return nil
+}
+
+// DeleteGroup deletes all keys pipelined in chunks
+func (c *redisCache) DeleteGroup(ctx context.Context, keys []string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteGroup", attribute.Int("cache.key_count", len(keys)))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	failures := make(map[string]error)
+	err = utils.Partition(len(keys), c.groupChunkSize(), func(low int, high int) error {
+		pipeline := c.redisClient.Pipeline()
+		commands := make(map[string][]redis.Cmder)
+		for _, key := range keys[low:high] {
+			commands[key] = append(commands[key], pipeline.Del(ctx, key))
+		}
+		_, err := pipeline.Exec(ctx)
+		if err != nil && !hasCommandErrors(commands) {
+			return err
+		}
+		collectCommandErrors(commands, failures)
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("deleting group failed"))
+		return err
+	}
+	if len(failures) > 0 {
+		log.Warn().Ctx(ctx).Int("failed", len(failures)).Int("total", len(keys)).Msg(c.fmtMsg("deleting group partially failed"))
+		return &GroupError{Failures: failures}
+	}
+	return nil
+}
+
+// modelMapValue checks that modelMapPtr points to a map with string keys, and initializes the map if nil
+func modelMapValue(modelMapPtr interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(modelMapPtr)
+	if v.Kind() != reflect.Ptr {
+		return v, fmt.Errorf("modelMapPtr must be a pointer")
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return v, fmt.Errorf("modelMapPtr must be a map with string keys")
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	return v, nil
+}
+
+func (c *redisCache) groupChunkSize() int {
+	if c.config != nil && c.config.GroupChunkSize > 0 {
+		return c.config.GroupChunkSize
+	}
+	return defaultGroupChunkSize
+}
+
+// hasCommandErrors checks if a pipeline error can be attributed to the single commands
+func hasCommandErrors(commands map[string][]redis.Cmder) bool {
+	for _, keyCommands := range commands {
+		for _, command := range keyCommands {
+			if command.Err() != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectCommandErrors adds the first error of each key, a missing key on delete is not an error
+func collectCommandErrors(commands map[string][]redis.Cmder, failures map[string]error) {
+	for key, keyCommands := range commands {
+		for _, command := range keyCommands {
+			if err := command.Err(); err != nil && !errors.Is(err, redis.Nil) {
+				failures[key] = err
+				break
+			}
+		}
+	}
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type refreshMode int
+
+const (
+	refreshNone refreshMode = iota
+	refreshFull
+	refreshIncremental
+)
+
+// RefreshCheckpoint is the state of the last successful refresh, after a full refresh the token is empty
+type RefreshCheckpoint struct {
+	Token string `json:"token"`
+	// RefreshedAt is the start of the last successful refresh, changes after it must be included
+	RefreshedAt time.Time `json:"refreshedAt"`
+}
+
+// IncrementalChanges are applied by an incremental refresh, Token is passed to the next incremental refresh
+type IncrementalChanges struct {
+	Upserts map[string]interface{}
+	Deletes []string
+	Token   string
+}
+
+// IncrementalRefreshFunc returns the changes since the checkpoint of the last successful refresh
+type IncrementalRefreshFunc func(ctx context.Context, checkpoint RefreshCheckpoint) (IncrementalChanges, error)
+
+// canRefreshIncrementally is true if an incremental refresh function is set, the cache is valid and a checkpoint exists
+func (c *redisCache) canRefreshIncrementally(ctx context.Context) bool {
+	if c.config.IncrementalRefreshFunc == nil || !c.IsValid(ctx) {
+		return false
+	}
+	exists, err := c.redisClient.Exists(ctx, c.keyForSystem(refreshCheckpointKey)).Result()
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("checking refresh checkpoint failed"))
+		return false
+	}
+	return exists > 0
+}
+
+// refreshIncrementally applies the changes since the last checkpoint, upserts are stored with the DefaultExpiration
+func (c *redisCache) refreshIncrementally(ctx context.Context, startedAt time.Time) error {
+	checkpoint, err := c.readRefreshCheckpoint(ctx)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("reading refresh checkpoint failed"))
+		return err
+	}
+	changes, err := c.config.IncrementalRefreshFunc(ctx, checkpoint)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("incremental refresh function failed"))
+		return err
+	}
+	if len(changes.Upserts) > 0 {
+		if err = c.StoreGroup(ctx, changes.Upserts, nil); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("storing incremental upserts failed"))
+			return err
+		}
+	}
+	if len(changes.Deletes) > 0 {
+		if err = c.DeleteGroup(ctx, changes.Deletes); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("applying incremental deletes failed"))
+			return err
+		}
+	}
+	log.Debug().Ctx(ctx).Int("upserts", len(changes.Upserts)).Int("deletes", len(changes.Deletes)).Msg(c.fmtMsg("incremental refresh applied"))
+	err = c.storeRefreshCheckpoint(ctx, RefreshCheckpoint{Token: changes.Token, RefreshedAt: startedAt})
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg(c.fmtMsg("storing refresh checkpoint failed"))
+		return err
+	}
+	return nil
+}
+
+func (c *redisCache) readRefreshCheckpoint(ctx context.Context) (RefreshCheckpoint, error) {
+	var checkpoint RefreshCheckpoint
+	payload, err := c.redisClient.Get(ctx, c.keyForSystem(refreshCheckpointKey)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return checkpoint, ErrItemNotFound
+		}
+		return checkpoint, err
+	}
+	err = json.Unmarshal(payload, &checkpoint)
+	return checkpoint, err
+}
+
+func (c *redisCache) storeRefreshCheckpoint(ctx context.Context, checkpoint RefreshCheckpoint) error {
+	payload, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return c.redisClient.Set(ctx, c.keyForSystem(refreshCheckpointKey), payload, 0).Err()
+}
@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	indexTagName          = "redisearch"
+	indexPollInterval     = 100 * time.Millisecond
+	indexSchemaHashLength = 12
+)
+
+var ErrInvalidIndexModel = errors.New("invalid index model")
+
+// IndexMigration defines how EnsureIndex replaces an index with an outdated schema
+type IndexMigration int
+
+const (
+	// IndexMigrationAliasSwap builds the new index next to the old one and switches the alias after indexing finished,
+	// searching stays available but both indexes are kept in memory during the migration
+	IndexMigrationAliasSwap IndexMigration = iota
+	// IndexMigrationRecreate drops the old index before creating the new one, searches return incomplete results
+	// until the new index finished indexing
+	IndexMigrationRecreate
+)
+
+// IndexDefinition describes a RediSearch index on the JSON documents with one of the prefixes, the fields are
+// derived from the redisearch struct tags of Model
+type IndexDefinition struct {
+	// Name is the alias used for searching, the index itself is named by the name and the schema hash
+	Name      string
+	Prefixes  []string
+	Model     interface{}
+	Migration IndexMigration
+}
+
+// Schema returns the create options and field schemas of the index
+func (d IndexDefinition) Schema() (*redis.FTCreateOptions, []*redis.FieldSchema, error) {
+	if d.Name == "" {
+		return nil, nil, fmt.Errorf("%w: index name not set", ErrInvalidIndexModel)
+	}
+	fieldSchemas, err := IndexSchemaFromModel(d.Model)
+	if err != nil {
+		return nil, nil, err
+	}
+	options := &redis.FTCreateOptions{OnJSON: true}
+	for _, prefix := range d.Prefixes {
+		options.Prefix = append(options.Prefix, prefix)
+	}
+	return options, fieldSchemas, nil
+}
+
+// IndexName returns the name of the index for the current schema, which is the name followed by the schema hash
+func (d IndexDefinition) IndexName() (string, error) {
+	options, fieldSchemas, err := d.Schema()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s_%s", d.Name, indexSchemaHash(options, fieldSchemas)), nil
+}
+
+// IndexSchemaFromModel derives the field schemas from the redisearch struct tags of a struct, e.g.
+// `redisearch:"TAG,SORTABLE,AS=type"`. The JSON paths follow the json tags, nested structs without
+// redisearch tag are included and slices are indexed with [*].
+func IndexSchemaFromModel(model interface{}) ([]*redis.FieldSchema, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: model must be a struct", ErrInvalidIndexModel)
+	}
+	fieldSchemas := make([]*redis.FieldSchema, 0)
+	if err := appendIndexFields(&fieldSchemas, t, "$", nil, make(map[reflect.Type]bool)); err != nil {
+		return nil, err
+	}
+	if len(fieldSchemas) == 0 {
+		return nil, fmt.Errorf("%w: no fields with %s tag in %s", ErrInvalidIndexModel, indexTagName, t.Name())
+	}
+	return fieldSchemas, nil
+}
+
+func appendIndexFields(fieldSchemas *[]*redis.FieldSchema, t reflect.Type, path string, names []string, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		tag, tagged := field.Tag.Lookup(indexTagName)
+		// Note: like encoding/json, the fields of embedded structs are promoted, even if the struct is not exported
+		if field.Anonymous && jsonName == "" && !tagged && fieldType.Kind() == reflect.Struct {
+			if err := appendIndexFields(fieldSchemas, fieldType, path, names, visited); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		fieldPath := path + "." + jsonName
+		fieldNames := append(append([]string{}, names...), jsonName)
+		isSlice := fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array
+		if isSlice {
+			fieldPath += "[*]"
+			fieldType = fieldType.Elem()
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+		}
+		if !tagged {
+			if fieldType.Kind() == reflect.Struct {
+				if err := appendIndexFields(fieldSchemas, fieldType, fieldPath, fieldNames, visited); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		fieldSchema, err := parseIndexTag(tag, fieldType)
+		if err != nil {
+			return fmt.Errorf("%w: field %s: %w", ErrInvalidIndexModel, field.Name, err)
+		}
+		fieldSchema.FieldName = fieldPath
+		if fieldSchema.As == "" {
+			fieldSchema.As = strings.Join(fieldNames, "_")
+		}
+		*fieldSchemas = append(*fieldSchemas, fieldSchema)
+	}
+	return nil
+}
+
+// parseIndexTag parses the field type followed by the options SORTABLE, UNF, NOSTEM, CASESENSITIVE,
+// AS=name, WEIGHT=number and SEPARATOR=character
+func parseIndexTag(tag string, fieldType reflect.Type) (*redis.FieldSchema, error) {
+	parts := strings.Split(tag, ",")
+	fieldSchema := &redis.FieldSchema{}
+	switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+	case "TEXT":
+		fieldSchema.FieldType = redis.SearchFieldTypeText
+	case "TAG":
+		fieldSchema.FieldType = redis.SearchFieldTypeTag
+	case "NUMERIC":
+		switch fieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return nil, fmt.Errorf("NUMERIC requires a number, got %s", fieldType.Kind())
+		}
+		fieldSchema.FieldType = redis.SearchFieldTypeNumeric
+	default:
+		return nil, fmt.Errorf("unsupported field type %q", parts[0])
+	}
+	for _, part := range parts[1:] {
+		option, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToUpper(option) {
+		case "SORTABLE":
+			fieldSchema.Sortable = true
+		case "UNF":
+			fieldSchema.UNF = true
+		case "NOSTEM":
+			fieldSchema.NoStem = true
+		case "CASESENSITIVE":
+			fieldSchema.CaseSensitive = true
+		case "AS":
+			fieldSchema.As = value
+		case "WEIGHT":
+			weight, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight %q", value)
+			}
+			fieldSchema.Weight = weight
+		case "SEPARATOR":
+			if len(value) != 1 {
+				return nil, fmt.Errorf("separator must be a single character, got %q", value)
+			}
+			fieldSchema.Separator = value
+		default:
+			return nil, fmt.Errorf("unsupported option %q", part)
+		}
+	}
+	return fieldSchema, nil
+}
+
+// indexSchemaHash hashes everything that requires recreating the index when changed
+func indexSchemaHash(options *redis.FTCreateOptions, fieldSchemas []*redis.FieldSchema) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "ON JSON PREFIX %d", len(options.Prefix))
+	for _, prefix := range options.Prefix {
+		fmt.Fprintf(&builder, " %q", prefix)
+	}
+	builder.WriteString(" SCHEMA")
+	for _, fieldSchema := range fieldSchemas {
+		fmt.Fprintf(&builder, " %q AS %q %s SORTABLE=%t UNF=%t NOSTEM=%t CASESENSITIVE=%t WEIGHT=%g SEPARATOR=%q",
+			fieldSchema.FieldName, fieldSchema.As, fieldSchema.FieldType, fieldSchema.Sortable, fieldSchema.UNF,
+			fieldSchema.NoStem, fieldSchema.CaseSensitive, fieldSchema.Weight, fieldSchema.Separator)
+	}
+	hash := sha256.Sum256([]byte(builder.String()))
+	return hex.EncodeToString(hash[:])[:indexSchemaHashLength]
+}
+
+// EnsureIndex creates the index of the definition if it does not exist yet, or migrates it if the schema changed,
+// and points the alias of the definition name to it. Indexes created with CreateIndex using the definition name
+// are replaced, searching is not available shortly during this first migration.
+func (c *redisCache) EnsureIndex(ctx context.Context, definition IndexDefinition) (indexName string, err error) {
+	ctx, span := c.startSpan(ctx, "EnsureIndex", attribute.String("cache.index", definition.Name))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return "", c.configErr()
+	}
+	if c.config.IsDisabled {
+		return "", ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return "", ErrNoClientSet
+	}
+	if err = c.checkSearchable(); err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return "", err
+	}
+	options, fieldSchemas, err := definition.Schema()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return "", err
+	}
+	alias := c.indexName(definition.Name)
+	indexName = fmt.Sprintf("%s_%s", alias, indexSchemaHash(options, fieldSchemas))
+	currentIndex, err := c.resolveIndex(ctx, alias)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("index", alias).Msg(c.fmtMsg("getting index info failed"))
+		return "", err
+	}
+	if currentIndex == indexName {
+		return indexName, nil
+	}
+	log.Info().Ctx(ctx).Str("index", indexName).Str("previous", currentIndex).Msg(c.fmtMsg("migrating index"))
+	if currentIndex != "" && definition.Migration == IndexMigrationRecreate {
+		if err = c.dropIndex(ctx, currentIndex); err != nil {
+			return "", err
+		}
+		currentIndex = ""
+	}
+	err = c.redisClient.FTCreate(ctx, indexName, options, fieldSchemas...).Err()
+	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "already exists") {
+		log.Error().Ctx(ctx).Err(err).Str("index", indexName).Msg(c.fmtMsg("creating index failed"))
+		return "", err
+	}
+	if currentIndex != "" {
+		if err = c.waitForIndexing(ctx, indexName); err != nil {
+			return "", err
+		}
+		// Note: an index created without alias blocks the alias name, so it has to be dropped before adding the alias
+		if currentIndex == alias {
+			if err = c.dropIndex(ctx, currentIndex); err != nil {
+				return "", err
+			}
+			currentIndex = ""
+		}
+	}
+	if err = c.redisClient.FTAliasUpdate(ctx, indexName, alias).Err(); err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("index", indexName).Msg(c.fmtMsg("updating index alias failed"))
+		return "", err
+	}
+	if currentIndex != "" {
+		if err = c.dropIndex(ctx, currentIndex); err != nil {
+			return "", err
+		}
+	}
+	return indexName, nil
+}
+
+// resolveIndex returns the name of the index the name refers to, empty if it does not exist
+func (c *redisCache) resolveIndex(ctx context.Context, name string) (string, error) {
+	indexInfo, err := c.redisClient.FTInfo(ctx, name).Result()
+	if err != nil {
+		if isUnknownIndexError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return indexInfo.IndexName, nil
+}
+
+// waitForIndexing waits until the initial scan of a new index finished
+func (c *redisCache) waitForIndexing(ctx context.Context, index string) error {
+	for {
+		indexInfo, err := c.redisClient.FTInfo(ctx, index).Result()
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("index", index).Msg(c.fmtMsg("getting index info failed"))
+			return err
+		}
+		if indexInfo.Indexing == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
+// dropIndex drops the index but keeps the documents, an index already dropped by another instance is ignored
+func (c *redisCache) dropIndex(ctx context.Context, index string) error {
+	err := c.redisClient.FTDropIndex(ctx, index).Err()
+	if err != nil && !isUnknownIndexError(err) {
+		log.Error().Ctx(ctx).Err(err).Str("index", index).Msg(c.fmtMsg("dropping index failed"))
+		return err
+	}
+	return nil
+}
+
+func isUnknownIndexError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "no such index") || strings.Contains(message, "unknown index")
+}
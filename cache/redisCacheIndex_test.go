@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type indexTestConnection struct {
+	Status string `json:"status" redisearch:"TAG"`
+}
+
+type indexTestBase struct {
+	ID string `json:"id" redisearch:"TAG,CASESENSITIVE"`
+}
+
+type indexTestInstrument struct {
+	indexTestBase
+	Name       string              `json:"name" redisearch:"TEXT,SORTABLE,WEIGHT=2"`
+	Count      int                 `json:"count" redisearch:"NUMERIC,SORTABLE,AS=cnt"`
+	Labels     []string            `json:"labels" redisearch:"TAG,SEPARATOR=;"`
+	Connection indexTestConnection `json:"connection"`
+	Channels   []struct {
+		Name string `json:"name" redisearch:"TEXT,NOSTEM"`
+	} `json:"channels"`
+	Ignored string `json:"-" redisearch:"TEXT"`
+	Plain   string `json:"plain"`
+}
+
+func TestIndexSchemaFromModel(t *testing.T) {
+	fieldSchemas, err := IndexSchemaFromModel(&indexTestInstrument{})
+	assert.Nil(t, err)
+	assert.Equal(t, []*redis.FieldSchema{
+		{FieldName: "$.id", As: "id", FieldType: redis.SearchFieldTypeTag, CaseSensitive: true},
+		{FieldName: "$.name", As: "name", FieldType: redis.SearchFieldTypeText, Sortable: true, Weight: 2},
+		{FieldName: "$.count", As: "cnt", FieldType: redis.SearchFieldTypeNumeric, Sortable: true},
+		{FieldName: "$.labels[*]", As: "labels", FieldType: redis.SearchFieldTypeTag, Separator: ";"},
+		{FieldName: "$.connection.status", As: "connection_status", FieldType: redis.SearchFieldTypeTag},
+		{FieldName: "$.channels[*].name", As: "channels_name", FieldType: redis.SearchFieldTypeText, NoStem: true},
+	}, fieldSchemas)
+
+	_, err = IndexSchemaFromModel(struct {
+		Name string `json:"name" redisearch:"NUMERIC"`
+	}{})
+	assert.ErrorIs(t, err, ErrInvalidIndexModel)
+	_, err = IndexSchemaFromModel(struct {
+		Name string `json:"name" redisearch:"TAG,UNKNOWN"`
+	}{})
+	assert.ErrorIs(t, err, ErrInvalidIndexModel)
+	_, err = IndexSchemaFromModel("not a struct")
+	assert.ErrorIs(t, err, ErrInvalidIndexModel)
+}
+
+func TestIndexNameBySchema(t *testing.T) {
+	definition := IndexDefinition{Name: "idx", Prefixes: []string{"cache:INSTRUMENT"}, Model: indexTestInstrument{}}
+	indexName, err := definition.IndexName()
+	assert.Nil(t, err)
+	assert.Regexp(t, `^idx_[0-9a-f]{12}$`, indexName)
+	sameIndexName, err := definition.IndexName()
+	assert.Nil(t, err)
+	assert.Equal(t, indexName, sameIndexName)
+
+	definition.Prefixes = []string{"cache:DEVICE"}
+	changedIndexName, err := definition.IndexName()
+	assert.Nil(t, err)
+	assert.NotEqual(t, indexName, changedIndexName)
+	definition.Model = indexTestConnection{}
+	changedModelIndexName, err := definition.IndexName()
+	assert.Nil(t, err)
+	assert.NotEqual(t, changedIndexName, changedModelIndexName)
+}
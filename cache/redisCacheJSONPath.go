@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrPathNotFound     = errors.New("path not found in cached document")
+	ErrInvalidJSONPath  = errors.New("invalid JSON path, must start with $")
+	ErrCodecNoJSONPaths = errors.New("codec does not store RedisJSON documents, JSON path operations not supported")
+)
+
+// JSON path handling
+
+// ReadPath reads the first value matching the JSON path (e.g. $.status) of the document into modelPtr
+func (c *redisCache) ReadPath(ctx context.Context, key string, path string, modelPtr interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "ReadPath", attribute.String("cache.key", key), attribute.String("cache.path", path))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJSONPath(ctx, path); err != nil {
+		return err
+	}
+	if err := c.checkValidForRead(ctx); err != nil {
+		return err
+	}
+	redisResult, err := c.redisClient.JSONGet(ctx, key, path).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.recordRead(ctx, 0, 1)
+			return ErrItemNotFound
+		}
+		log.Warn().Ctx(ctx).Err(err).Interface("key", key).Str("path", path).Msg(c.fmtMsg("getting value failed"))
+		return err
+	}
+	c.recordRead(ctx, 1, 0)
+	match, err := firstPathMatch(redisResult)
+	if err != nil {
+		return err
+	}
+	c.recordPayloadSize(ctx, "Read", len(match))
+	err = json.Unmarshal(match, modelPtr)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Str("path", path).Msg(c.fmtMsg("unmarshal failed"))
+		return err
+	}
+	return nil
+}
+
+// StorePath replaces the values matching the JSON path, a missing last field of an existing object is created
+func (c *redisCache) StorePath(ctx context.Context, key string, path string, content interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "StorePath", attribute.String("cache.key", key), attribute.String("cache.path", path))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJSONPath(ctx, path); err != nil {
+		return err
+	}
+	payload, err := marshalContent(content)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
+	return c.pathError(ctx, key, path, c.redisClient.JSONSet(ctx, key, path, payload).Err())
+}
+
+// MergePath merges the content into the values matching the JSON path following RFC 7396, null values delete fields
+func (c *redisCache) MergePath(ctx context.Context, key string, path string, content interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "MergePath", attribute.String("cache.key", key), attribute.String("cache.path", path))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJSONPath(ctx, path); err != nil {
+		return err
+	}
+	payload, err := marshalContent(content)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
+	return c.pathError(ctx, key, path, c.redisClient.JSONMerge(ctx, key, path, string(payload)).Err())
+}
+
+// AppendToArray appends the contents to the arrays matching the JSON path
+func (c *redisCache) AppendToArray(ctx context.Context, key string, path string, contents ...interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, "AppendToArray", attribute.String("cache.key", key), attribute.String("cache.path", path))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJSONPath(ctx, path); err != nil {
+		return err
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(contents))
+	for i := range contents {
+		payload, err := marshalContent(contents[i])
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+			return err
+		}
+		values[i] = string(payload)
+	}
+	lengths, err := c.redisClient.JSONArrAppend(ctx, key, path, values...).Result()
+	if err != nil {
+		return c.pathError(ctx, key, path, err)
+	}
+	if len(lengths) == 0 {
+		return ErrPathNotFound
+	}
+	return nil
+}
+
+// IncrementNumber atomically increments the number matching the JSON path and returns the new value
+func (c *redisCache) IncrementNumber(ctx context.Context, key string, path string, value float64) (result float64, err error) {
+	ctx, span := c.startSpan(ctx, "IncrementNumber", attribute.String("cache.key", key), attribute.String("cache.path", path))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJSONPath(ctx, path); err != nil {
+		return 0, err
+	}
+	redisResult, err := c.redisClient.JSONNumIncrBy(ctx, key, path, value).Result()
+	if err != nil {
+		return 0, c.pathError(ctx, key, path, err)
+	}
+	match, err := firstPathMatch(redisResult)
+	if err != nil {
+		return 0, err
+	}
+	// Note: values not being numbers result in null
+	var number *float64
+	if err = json.Unmarshal(match, &number); err != nil || number == nil {
+		return 0, fmt.Errorf("%w: value at %s is not a number", ErrPathNotFound, path)
+	}
+	return *number, nil
+}
+
+func (c *redisCache) checkJSONPath(ctx context.Context, path string) error {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if !c.codec().UsesRedisJSON() {
+		return fmt.Errorf("%w: %s", ErrCodecNoJSONPaths, c.codec().Name())
+	}
+	if !strings.HasPrefix(path, "$") {
+		return ErrInvalidJSONPath
+	}
+	return nil
+}
+
+// pathError maps the errors of the JSON path commands, RedisJSON only reports missing keys in the error message
+func (c *redisCache) pathError(ctx context.Context, key string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, redis.Nil) {
+		return ErrPathNotFound
+	}
+	if strings.Contains(err.Error(), "doesn't exist") || strings.Contains(err.Error(), "must be created at the root") {
+		return ErrItemNotFound
+	}
+	log.Error().Ctx(ctx).Err(err).Interface("key", key).Str("path", path).Msg(c.fmtMsg("JSON path operation failed"))
+	return err
+}
+
+// firstPathMatch returns the first match of a JSONPath result, which is always an array of the matches
+func firstPathMatch(redisResult string) (json.RawMessage, error) {
+	var matches []json.RawMessage
+	if err := json.Unmarshal([]byte(redisResult), &matches); err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrPathNotFound
+	}
+	return matches[0], nil
+}
@@ -0,0 +1,318 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The fake supports the JSONPath subset of dotted field names, bracket notation with quoted names,
+// array indexes (negative from the end) and the * wildcard, recursive descent and filters are not supported
+
+var ErrFakeJSONPathNotSupported = errors.New("JSON path syntax not supported by fake cache")
+
+type fakePathSegment struct {
+	name     string
+	index    *int
+	wildcard bool
+}
+
+func (f *fakeRedisCache) ReadPath(ctx context.Context, key string, path string, modelPtr interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ReadPath", key)
+	segments, err := f.checkPath(path)
+	if err != nil {
+		return err
+	}
+	if !f.cacheValid {
+		return ErrCacheInvalid
+	}
+	document, err := f.document(key)
+	if err != nil {
+		return err
+	}
+	matches := make([]interface{}, 0)
+	_, _, err = updateFakeJSONPath(document, segments, false, func(value interface{}) (interface{}, error) {
+		matches = append(matches, value)
+		return value, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return ErrPathNotFound
+	}
+	payload, err := json.Marshal(matches[0])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, modelPtr)
+}
+func (f *fakeRedisCache) StorePath(ctx context.Context, key string, path string, content interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("StorePath", key)
+	segments, err := f.checkPath(path)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return f.storeValue(key, content, nil)
+	}
+	newValue, err := fakeJSONContent(content)
+	if err != nil {
+		return err
+	}
+	return f.updatePath(key, segments, true, func(value interface{}) (interface{}, error) {
+		return newValue, nil
+	})
+}
+func (f *fakeRedisCache) MergePath(ctx context.Context, key string, path string, content interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("MergePath", key)
+	segments, err := f.checkPath(path)
+	if err != nil {
+		return err
+	}
+	patch, err := fakeJSONContent(content)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 && f.entry(key) == nil {
+		return f.storeValue(key, mergeFakeJSON(nil, patch), nil)
+	}
+	return f.updatePath(key, segments, true, func(value interface{}) (interface{}, error) {
+		return mergeFakeJSON(value, patch), nil
+	})
+}
+func (f *fakeRedisCache) AppendToArray(ctx context.Context, key string, path string, contents ...interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("AppendToArray", key)
+	segments, err := f.checkPath(path)
+	if err != nil {
+		return err
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+	newValues := make([]interface{}, len(contents))
+	for i := range contents {
+		if newValues[i], err = fakeJSONContent(contents[i]); err != nil {
+			return err
+		}
+	}
+	return f.updatePath(key, segments, false, func(value interface{}) (interface{}, error) {
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return nil, ErrFakeWrongType
+		}
+		return append(array, newValues...), nil
+	})
+}
+func (f *fakeRedisCache) IncrementNumber(ctx context.Context, key string, path string, value float64) (float64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("IncrementNumber", key)
+	segments, err := f.checkPath(path)
+	if err != nil {
+		return 0, err
+	}
+	var results []float64
+	err = f.updatePath(key, segments, false, func(current interface{}) (interface{}, error) {
+		number, isNumber := current.(float64)
+		if !isNumber {
+			return nil, fmt.Errorf("%w: value at %s is not a number", ErrPathNotFound, path)
+		}
+		results = append(results, number+value)
+		return number + value, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return results[0], nil
+}
+
+// checkPath mirrors the checks of the real cache and parses the path, the mutex must be held
+func (f *fakeRedisCache) checkPath(path string) ([]fakePathSegment, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	if !f.helper.codec().UsesRedisJSON() {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNoJSONPaths, f.helper.codec().Name())
+	}
+	return parseFakeJSONPath(path)
+}
+
+// document returns the decoded JSON document of the key, the mutex must be held
+func (f *fakeRedisCache) document(key string) (interface{}, error) {
+	entry := f.entry(key)
+	if entry == nil {
+		return nil, ErrItemNotFound
+	}
+	if entry.kind != fakeEntryValue {
+		return nil, ErrFakeWrongType
+	}
+	var document interface{}
+	if err := json.Unmarshal(entry.value, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// updatePath replaces all values matching the path by the result of update, keeping the expiration, the mutex must be held
+func (f *fakeRedisCache) updatePath(key string, segments []fakePathSegment, create bool, update func(value interface{}) (interface{}, error)) error {
+	document, err := f.document(key)
+	if err != nil {
+		return err
+	}
+	document, matched, err := updateFakeJSONPath(document, segments, create, update)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return ErrPathNotFound
+	}
+	payload, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	f.entries[key].value = payload
+	return nil
+}
+
+// updateFakeJSONPath walks the path and replaces the matched values, missing object fields are only
+// created as last segment and with create set
+func updateFakeJSONPath(value interface{}, segments []fakePathSegment, create bool, update func(value interface{}) (interface{}, error)) (interface{}, int, error) {
+	if len(segments) == 0 {
+		newValue, err := update(value)
+		return newValue, 1, err
+	}
+	segment, rest := segments[0], segments[1:]
+	matched := 0
+	apply := func(child interface{}) (interface{}, error) {
+		newChild, childMatched, err := updateFakeJSONPath(child, rest, create, update)
+		matched += childMatched
+		return newChild, err
+	}
+	var err error
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if segment.wildcard {
+			for _, name := range sortedKeys(typed) {
+				if typed[name], err = apply(typed[name]); err != nil {
+					return nil, 0, err
+				}
+			}
+		} else if segment.index == nil {
+			child, exists := typed[segment.name]
+			if exists || (create && len(rest) == 0) {
+				if typed[segment.name], err = apply(child); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	case []interface{}:
+		if segment.wildcard {
+			for i := range typed {
+				if typed[i], err = apply(typed[i]); err != nil {
+					return nil, 0, err
+				}
+			}
+		} else if segment.index != nil {
+			index := *segment.index
+			if index < 0 {
+				index += len(typed)
+			}
+			if index >= 0 && index < len(typed) {
+				if typed[index], err = apply(typed[index]); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+	return value, matched, nil
+}
+
+func parseFakeJSONPath(path string) ([]fakePathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, ErrInvalidJSONPath
+	}
+	segments := make([]fakePathSegment, 0)
+	rest := path[1:]
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return nil, fmt.Errorf("%w: %s", ErrFakeJSONPathNotSupported, path)
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrFakeJSONPathNotSupported, path)
+			}
+			segments = append(segments, fakePathSegment{name: rest[:end], wildcard: rest[:end] == "*"})
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrFakeJSONPathNotSupported, path)
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if selector == "*" {
+				segments = append(segments, fakePathSegment{wildcard: true})
+			} else if name, err := strconv.Unquote(strings.ReplaceAll(selector, "'", `"`)); err == nil {
+				segments = append(segments, fakePathSegment{name: name})
+			} else if index, err := strconv.Atoi(selector); err == nil {
+				segments = append(segments, fakePathSegment{index: &index})
+			} else {
+				return nil, fmt.Errorf("%w: %s", ErrFakeJSONPathNotSupported, path)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrFakeJSONPathNotSupported, path)
+		}
+	}
+	return segments, nil
+}
+
+// fakeJSONContent converts the content to its generic JSON representation
+func fakeJSONContent(content interface{}) (interface{}, error) {
+	payload, err := marshalContent(content)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(payload) {
+		return nil, ErrFakeInvalidJSONDocument
+	}
+	var value interface{}
+	err = json.Unmarshal(payload, &value)
+	return value, err
+}
+
+// mergeFakeJSON applies the patch following RFC 7396
+func mergeFakeJSON(target interface{}, patch interface{}) interface{} {
+	patchObject, isObject := patch.(map[string]interface{})
+	if !isObject {
+		return patch
+	}
+	targetObject, isObject := target.(map[string]interface{})
+	if !isObject {
+		targetObject = make(map[string]interface{})
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergeFakeJSON(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultJobVisibilityTimeout = 30 * time.Second
+	defaultJobRetryWaitStart    = time.Second
+	defaultJobRetryWaitExponent = 2
+	defaultJobMaxRetryWait      = time.Hour
+	jobQueueKeySegment          = "JOBS:"
+)
+
+var (
+	ErrInvalidQueue  = errors.New("queue name must not be empty")
+	ErrJobClaimLost  = errors.New("job was claimed again or completed after the visibility timeout")
+	ErrInvalidJobRef = errors.New("job has no id or attempt, it must be returned by ClaimJobs")
+)
+
+// JobQueueConfig configures the job queues of the cache
+type JobQueueConfig struct {
+	// VisibilityTimeout after which a claimed job which was neither completed nor retried is claimed again, defaults to 30s
+	VisibilityTimeout time.Duration
+	// RetryWaitStart, RetryWaitExponent and MaxRetryWait define the backoff of RetryJob, default to 1s, 2 and 1h
+	RetryWaitStart    time.Duration
+	RetryWaitExponent float64
+	MaxRetryWait      time.Duration
+}
+
+// Job is a claimed job, it must be passed to CompleteJob or RetryJob
+type Job struct {
+	ID      string
+	Queue   string
+	Payload json.RawMessage
+	// DueAt is the time the job was due when it was claimed
+	DueAt time.Time
+	// Attempt is the number of claims including the current one
+	Attempt int
+}
+
+// Decode unmarshals the payload into modelPtr
+func (j Job) Decode(modelPtr interface{}) error {
+	return json.Unmarshal(j.Payload, modelPtr)
+}
+
+// A queue consists of a sorted set of the job ids scored by the due time in unix milliseconds, and hashes of
+// the payloads and the attempts by job id. Claimed jobs are rescheduled to the end of the visibility timeout.
+
+// enqueueJobScript adds the job (ARGV[1]) due at ARGV[2] with the payload ARGV[3] if no job with the id exists
+var enqueueJobScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('HSET', KEYS[3], ARGV[1], 0)
+return 1
+`)
+
+// claimJobsScript claims up to ARGV[2] jobs due at ARGV[1], increments their attempts and reschedules them by
+// the visibility timeout ARGV[3], returning the id, payload, attempt and due time of each job
+var claimJobsScript = redis.NewScript(`
+local due = redis.call('ZRANGE', KEYS[1], '-inf', ARGV[1], 'BYSCORE', 'LIMIT', 0, ARGV[2], 'WITHSCORES')
+local claimed = {}
+for i = 1, #due, 2 do
+	local id = due[i]
+	local payload = redis.call('HGET', KEYS[2], id)
+	if payload then
+		local attempt = redis.call('HINCRBY', KEYS[3], id, 1)
+		redis.call('ZADD', KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[3]), id)
+		table.insert(claimed, id)
+		table.insert(claimed, payload)
+		table.insert(claimed, attempt)
+		table.insert(claimed, due[i + 1])
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return claimed
+`)
+
+// completeJobScript removes the job ARGV[1] if it is still at attempt ARGV[2]
+var completeJobScript = redis.NewScript(`
+if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// retryJobScript reschedules the job ARGV[1] to ARGV[3] if it is still at attempt ARGV[2]
+var retryJobScript = redis.NewScript(`
+if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', ARGV[3], ARGV[1])
+return 1
+`)
+
+// Job handling
+
+// EnqueueJob adds a job due at dueAt to the queue. If dedupKey is set, it is used as job id and the job is only
+// enqueued if no job with the id is waiting or running, otherwise a random id is used.
+func (c *redisCache) EnqueueJob(ctx context.Context, queue string, payload interface{}, dueAt time.Time, dedupKey string) (jobId string, enqueued bool, err error) {
+	ctx, span := c.startSpan(ctx, "EnqueueJob", attribute.String("cache.queue", queue))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkQueue(ctx, queue); err != nil {
+		return "", false, err
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("queue", queue).Msg(c.fmtMsg("marshal job payload failed"))
+		return "", false, err
+	}
+	jobId = dedupKey
+	if jobId == "" {
+		jobId = uuid.NewString()
+	}
+	added, err := enqueueJobScript.Run(ctx, c.redisClient, c.jobQueueKeys(queue), jobId, dueAt.UnixMilli(), string(encoded)).Int()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("queue", queue).Str("jobId", jobId).Msg(c.fmtMsg("enqueueing job failed"))
+		return "", false, err
+	}
+	return jobId, added == 1, nil
+}
+
+// ClaimJobs atomically claims up to count due jobs, oldest due first. A claimed job is claimed again after the
+// VisibilityTimeout, unless it is completed or retried before.
+func (c *redisCache) ClaimJobs(ctx context.Context, queue string, count int) (jobs []Job, err error) {
+	ctx, span := c.startSpan(ctx, "ClaimJobs", attribute.String("cache.queue", queue))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkQueue(ctx, queue); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return []Job{}, nil
+	}
+	visibilityTimeout := jobQueueConfig(c.config).VisibilityTimeout
+	result, err := claimJobsScript.Run(ctx, c.redisClient, c.jobQueueKeys(queue), time.Now().UnixMilli(), count, visibilityTimeout.Milliseconds()).Slice()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("queue", queue).Msg(c.fmtMsg("claiming jobs failed"))
+		return nil, err
+	}
+	jobs = make([]Job, 0, len(result)/4)
+	for i := 0; i+3 < len(result); i += 4 {
+		id, _ := result[i].(string)
+		payload, _ := result[i+1].(string)
+		attempt, _ := result[i+2].(int64)
+		dueAtMs, _ := strconv.ParseFloat(fmt.Sprint(result[i+3]), 64)
+		jobs = append(jobs, Job{
+			ID:      id,
+			Queue:   queue,
+			Payload: json.RawMessage(payload),
+			DueAt:   time.UnixMilli(int64(dueAtMs)),
+			Attempt: int(attempt),
+		})
+	}
+	return jobs, nil
+}
+
+// CompleteJob removes a claimed job, ErrJobClaimLost is returned if it was claimed again meanwhile
+func (c *redisCache) CompleteJob(ctx context.Context, job Job) (err error) {
+	ctx, span := c.startSpan(ctx, "CompleteJob", attribute.String("cache.queue", job.Queue))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJob(ctx, job); err != nil {
+		return err
+	}
+	completed, err := completeJobScript.Run(ctx, c.redisClient, c.jobQueueKeys(job.Queue), job.ID, job.Attempt).Int()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("queue", job.Queue).Str("jobId", job.ID).Msg(c.fmtMsg("completing job failed"))
+		return err
+	}
+	if completed == 0 {
+		return ErrJobClaimLost
+	}
+	return nil
+}
+
+// RetryJob reschedules a claimed job after the delay, or after the backoff of its attempt if delay is nil.
+// ErrJobClaimLost is returned if it was claimed again meanwhile.
+func (c *redisCache) RetryJob(ctx context.Context, job Job, delay *time.Duration) (err error) {
+	ctx, span := c.startSpan(ctx, "RetryJob", attribute.String("cache.queue", job.Queue))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkJob(ctx, job); err != nil {
+		return err
+	}
+	wait := jobRetryWait(jobQueueConfig(c.config), job.Attempt, delay, rand.Float64())
+	retried, err := retryJobScript.Run(ctx, c.redisClient, c.jobQueueKeys(job.Queue), job.ID, job.Attempt, time.Now().Add(wait).UnixMilli()).Int()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("queue", job.Queue).Str("jobId", job.ID).Msg(c.fmtMsg("retrying job failed"))
+		return err
+	}
+	if retried == 0 {
+		return ErrJobClaimLost
+	}
+	return nil
+}
+
+func (c *redisCache) checkQueue(ctx context.Context, queue string) error {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if queue == "" {
+		return ErrInvalidQueue
+	}
+	return nil
+}
+
+func (c *redisCache) checkJob(ctx context.Context, job Job) error {
+	if err := c.checkQueue(ctx, job.Queue); err != nil {
+		return err
+	}
+	if job.ID == "" || job.Attempt <= 0 {
+		return ErrInvalidJobRef
+	}
+	return nil
+}
+
+// jobQueueKeys returns the keys of the schedule, the payloads and the attempts of the queue
+func (c *redisCache) jobQueueKeys(queue string) []string {
+	key := c.keyForJobQueue(queue)
+	return []string{key, key + ":DATA", key + ":ATTEMPTS"}
+}
+
+// keyForJobQueue is the key of the schedule of the queue, keys of job queues are kept when clearing the cache
+func (c *redisCache) keyForJobQueue(queue string) string {
+	return fmt.Sprintf("%s:%s%s", c.keyPrefix, jobQueueKeySegment, queue)
+}
+
+func jobQueueConfig(config *RedisCacheConfig) JobQueueConfig {
+	queueConfig := config.JobQueue
+	if queueConfig.VisibilityTimeout <= 0 {
+		queueConfig.VisibilityTimeout = defaultJobVisibilityTimeout
+	}
+	if queueConfig.RetryWaitStart <= 0 {
+		queueConfig.RetryWaitStart = defaultJobRetryWaitStart
+	}
+	if queueConfig.RetryWaitExponent <= 0 {
+		queueConfig.RetryWaitExponent = defaultJobRetryWaitExponent
+	}
+	if queueConfig.MaxRetryWait <= 0 {
+		queueConfig.MaxRetryWait = defaultJobMaxRetryWait
+	}
+	return queueConfig
+}
+
+// jobRetryWait returns the delay if set, or the progressive backoff of the attempt limited to MaxRetryWait
+func jobRetryWait(config JobQueueConfig, attempt int, delay *time.Duration, random float64) time.Duration {
+	if delay != nil {
+		return *delay
+	}
+	return min(progressiveBackoff(attempt-1, config.RetryWaitStart, config.RetryWaitExponent, random), config.MaxRetryWait)
+}
+
+// progressiveBackoff returns the wait after the failed attempt (counted from 0), growing by the exponent, with a
+// jitter of up to start scaled by random in [0, 1)
+func progressiveBackoff(attempt int, start time.Duration, exponent float64, random float64) time.Duration {
+	backoff := math.Pow(exponent, float64(attempt)) * float64(start)
+	if backoff > math.MaxInt64/2 {
+		backoff = math.MaxInt64 / 2
+	}
+	return time.Duration(backoff) + time.Duration(random*float64(start))
+}
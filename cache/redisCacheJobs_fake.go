@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The fake keeps the job queues apart from the other entries, so like in the real cache they are not
+// removed by refreshes. Due times are compared with the clock set by SetClock.
+
+type fakeJob struct {
+	payload json.RawMessage
+	dueAt   time.Time
+	attempt int
+}
+
+func (f *fakeRedisCache) EnqueueJob(ctx context.Context, queue string, payload interface{}, dueAt time.Time, dedupKey string) (string, bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("EnqueueJob", f.helper.keyForJobQueue(queue))
+	jobs, err := f.checkQueue(queue)
+	if err != nil {
+		return "", false, err
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return "", false, err
+	}
+	jobId := dedupKey
+	if jobId == "" {
+		jobId = uuid.NewString()
+	}
+	if _, exists := jobs[jobId]; exists {
+		return jobId, false, nil
+	}
+	jobs[jobId] = &fakeJob{payload: encoded, dueAt: time.UnixMilli(dueAt.UnixMilli())}
+	return jobId, true, nil
+}
+
+func (f *fakeRedisCache) ClaimJobs(ctx context.Context, queue string, count int) ([]Job, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ClaimJobs", f.helper.keyForJobQueue(queue))
+	jobs, err := f.checkQueue(queue)
+	if err != nil {
+		return nil, err
+	}
+	now := f.now()
+	dueIds := make([]string, 0)
+	for jobId, job := range jobs {
+		if !job.dueAt.After(now) {
+			dueIds = append(dueIds, jobId)
+		}
+	}
+	sort.Slice(dueIds, func(i, j int) bool {
+		if !jobs[dueIds[i]].dueAt.Equal(jobs[dueIds[j]].dueAt) {
+			return jobs[dueIds[i]].dueAt.Before(jobs[dueIds[j]].dueAt)
+		}
+		return dueIds[i] < dueIds[j]
+	})
+	claimed := make([]Job, 0)
+	visibilityTimeout := jobQueueConfig(f.config).VisibilityTimeout
+	for _, jobId := range dueIds {
+		if len(claimed) >= count {
+			break
+		}
+		job := jobs[jobId]
+		job.attempt++
+		claimed = append(claimed, Job{ID: jobId, Queue: queue, Payload: job.payload, DueAt: job.dueAt, Attempt: job.attempt})
+		job.dueAt = time.UnixMilli(now.Add(visibilityTimeout).UnixMilli())
+	}
+	return claimed, nil
+}
+
+func (f *fakeRedisCache) CompleteJob(ctx context.Context, job Job) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("CompleteJob", f.helper.keyForJobQueue(job.Queue))
+	jobs, err := f.checkJob(job)
+	if err != nil {
+		return err
+	}
+	delete(jobs, job.ID)
+	return nil
+}
+
+func (f *fakeRedisCache) RetryJob(ctx context.Context, job Job, delay *time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("RetryJob", f.helper.keyForJobQueue(job.Queue))
+	jobs, err := f.checkJob(job)
+	if err != nil {
+		return err
+	}
+	wait := jobRetryWait(jobQueueConfig(f.config), job.Attempt, delay, rand.Float64())
+	jobs[job.ID].dueAt = time.UnixMilli(f.now().Add(wait).UnixMilli())
+	return nil
+}
+
+// checkQueue mirrors the checks of the real cache and returns the jobs of the queue, the mutex must be held
+func (f *fakeRedisCache) checkQueue(queue string) (map[string]*fakeJob, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	if queue == "" {
+		return nil, ErrInvalidQueue
+	}
+	jobs, ok := f.jobs[queue]
+	if !ok {
+		jobs = make(map[string]*fakeJob)
+		f.jobs[queue] = jobs
+	}
+	return jobs, nil
+}
+
+// checkJob additionally checks that the job is still at the claimed attempt, the mutex must be held
+func (f *fakeRedisCache) checkJob(job Job) (map[string]*fakeJob, error) {
+	jobs, err := f.checkQueue(job.Queue)
+	if err != nil {
+		return nil, err
+	}
+	if job.ID == "" || job.Attempt <= 0 {
+		return nil, ErrInvalidJobRef
+	}
+	if current, ok := jobs[job.ID]; !ok || current.attempt != job.Attempt {
+		return nil, ErrJobClaimLost
+	}
+	return jobs, nil
+}
@@ -0,0 +1,18 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobRetryWait(t *testing.T) {
+	config := jobQueueConfig(&RedisCacheConfig{})
+	assert.Equal(t, time.Second, jobRetryWait(config, 1, nil, 0))
+	assert.Equal(t, 4*time.Second+500*time.Millisecond, jobRetryWait(config, 3, nil, 0.5))
+	assert.Equal(t, time.Hour, jobRetryWait(config, 100, nil, 0.5))
+	delay := 5 * time.Minute
+	assert.Equal(t, delay, jobRetryWait(config, 3, &delay, 0.5))
+	assert.Equal(t, 9*time.Second, progressiveBackoff(2, time.Second, 3, 0))
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type refreshScheduler struct {
+	cancel    context.CancelFunc
+	waitGroup *sync.WaitGroup
+}
+
+// Scheduling
+
+func (c *redisCache) Start(ctx context.Context) error {
+	ctx = c.circuitContext(ctx)
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	if c.config.RefreshInterval == nil || *c.config.RefreshInterval <= 0 {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrRefreshIntervalNotSet)).Send()
+		return ErrRefreshIntervalNotSet
+	}
+	if c.config.MultiserverMode && c.config.MutexExpiration == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrMutexExpirationNotSet)).Send()
+		return ErrMutexExpirationNotSet
+	}
+	c.refreshStatusMutex.Lock()
+	defer c.refreshStatusMutex.Unlock()
+	if c.scheduler != nil {
+		return ErrSchedulerRunning
+	}
+	schedulerCtx, cancel := context.WithCancel(ctx)
+	c.scheduler = &refreshScheduler{
+		cancel:    cancel,
+		waitGroup: &sync.WaitGroup{},
+	}
+	c.scheduler.waitGroup.Add(1)
+	go c.runScheduler(schedulerCtx, c.scheduler.waitGroup)
+	c.refreshStatus.SchedulerRunning = true
+	log.Info().Ctx(ctx).Msg(c.fmtMsg("refresh scheduler started"))
+	return nil
+}
+
+// Stop cancels the scheduler and waits until a currently running scheduled refresh is finished
+func (c *redisCache) Stop() {
+	c.refreshStatusMutex.Lock()
+	scheduler := c.scheduler
+	c.scheduler = nil
+	c.refreshStatus.SchedulerRunning = false
+	c.refreshStatusMutex.Unlock()
+	if scheduler == nil {
+		return
+	}
+	scheduler.cancel()
+	scheduler.waitGroup.Wait()
+	log.Info().Msg(c.fmtMsg("refresh scheduler stopped"))
+}
+
+func (c *redisCache) runScheduler(ctx context.Context, waitGroup *sync.WaitGroup) {
+	defer waitGroup.Done()
+	if !c.IsValid(ctx) {
+		c.scheduledRefresh(ctx)
+	}
+	for {
+		timer := time.NewTimer(c.nextRefreshWait())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			c.scheduledRefresh(ctx)
+		}
+	}
+}
+
+// scheduledRefresh runs a refresh synchronously, in multiserver mode only the instance claiming the current interval refreshes
+func (c *redisCache) scheduledRefresh(ctx context.Context) {
+	if c.config.MultiserverMode {
+		claimed, err := c.redisClient.SetNX(ctx, c.keyForSystem(schedulerFlagKey), c.instanceId, *c.config.RefreshInterval).Result()
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("claiming scheduled refresh failed"))
+			return
+		}
+		if !claimed {
+			log.Trace().Ctx(ctx).Msg(c.fmtMsg("scheduled refresh claimed by another instance, skipping"))
+			return
+		}
+	}
+	mode := c.startRefresh(ctx, false)
+	if mode == refreshNone {
+		return
+	}
+	c.runRefresh(ctx, mode)
+}
+
+// nextRefreshWait returns the refresh interval extended by a random jitter
+func (c *redisCache) nextRefreshWait() time.Duration {
+	wait := *c.config.RefreshInterval
+	if c.config.RefreshIntervalJitter != nil && *c.config.RefreshIntervalJitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(*c.config.RefreshIntervalJitter)))
+	}
+	return wait
+}
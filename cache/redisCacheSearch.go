@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// unpagedSearchLimit is the number of results returned by unpaged searches, the default MAXSEARCHRESULTS of RediSearch
+const unpagedSearchLimit = 10000
+
+var ErrSortFieldNotAllowed = errors.New("sort field not allowed")
+
+// SearchInIndexPaginated searches with the paging and sorting of the query, the sort field must be one of the
+// allowed sort fields. The search term is added as prefix search on the TEXT fields.
+func (c *redisCache) SearchInIndexPaginated(ctx context.Context, indexName string, queryString string, page pagination.FilteredPaginatedQuery, allowedSortFields []string, modelArrayPtr interface{}) (response pagination.PaginatedResponse, err error) {
+	ctx, span := c.startSpan(ctx, "SearchInIndex", attribute.String("cache.index", indexName))
+	defer func() { c.endSpan(span, err) }()
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return response, c.configErr()
+	}
+	if c.config.IsDisabled {
+		return response, ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return response, ErrNoClientSet
+	}
+	if err = c.checkSearchable(); err != nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return response, err
+	}
+	options, err := paginatedSearchOptions(page.PaginatedQuery, allowedSortFields)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("sort", page.Sort).Msg(c.fmtMsg("invalid paginated query"))
+		return response, err
+	}
+	totalCount, err := c.search(ctx, indexName, paginatedSearchQuery(queryString, page.SearchTerm), options, modelArrayPtr)
+	if err != nil {
+		return response, err
+	}
+	return pagination.NewPaginatedResponse(page.PageSize, page.Page, totalCount), nil
+}
+
+// search executes the search and appends the documents to modelArrayPtr, returning the number of matches
+func (c *redisCache) search(ctx context.Context, indexName string, queryString string, options *redis.FTSearchOptions, modelArrayPtr interface{}) (int, error) {
+	redisResult, err := c.redisClient.FTSearchWithArgs(ctx, c.indexName(indexName), queryString, options).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "No such index") {
+			log.Error().Ctx(ctx).Err(err).Interface("index", indexName).Msg(c.fmtErr(ErrNoSuchIndexFound).Error())
+			return 0, ErrNoSuchIndexFound
+		}
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("searching in index failed"))
+		return 0, err
+	}
+	v := reflect.ValueOf(modelArrayPtr)
+	if v.Kind() != reflect.Ptr {
+		err = fmt.Errorf("modelArrayPtr must be a pointer")
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return 0, err
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Slice {
+		err = fmt.Errorf("modelArrayPtr must be a slice")
+		log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+		return 0, err
+	}
+	elemType := v.Type().Elem()
+	for i := range redisResult.Docs {
+		newElem := reflect.New(elemType)
+		if err = json.Unmarshal([]byte(redisResult.Docs[i].Fields["$"]), newElem.Interface()); err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("unmarshal failed"))
+			return 0, err
+		}
+		v.Set(reflect.Append(v, newElem.Elem()))
+	}
+	return redisResult.Total, nil
+}
+
+// paginatedSearchOptions translates the paging and sorting to search options, unpaged queries return up to unpagedSearchLimit results
+func paginatedSearchOptions(page pagination.PaginatedQuery, allowedSortFields []string) (*redis.FTSearchOptions, error) {
+	options := &redis.FTSearchOptions{Limit: unpagedSearchLimit}
+	if page.IsPaged() {
+		options.Limit = min(page.PageSize, unpagedSearchLimit)
+		options.LimitOffset = max(page.Page, 0) * options.Limit
+	}
+	if page.Sort != "" {
+		if !slices.Contains(allowedSortFields, page.Sort) {
+			return nil, fmt.Errorf("%w: %s", ErrSortFieldNotAllowed, page.Sort)
+		}
+		descending := strings.HasPrefix(strings.ToLower(page.Direction), "desc")
+		options.SortBy = []redis.FTSearchSortBy{{FieldName: page.Sort, Asc: !descending, Desc: descending}}
+	}
+	return options, nil
+}
+
+// paginatedSearchQuery adds every word of the search term as escaped prefix search to the query
+func paginatedSearchQuery(queryString string, searchTerm *string) string {
+	queryString = strings.TrimSpace(queryString)
+	if queryString == "" {
+		queryString = "*"
+	}
+	if searchTerm == nil {
+		return queryString
+	}
+	terms := make([]string, 0)
+	for _, word := range strings.Fields(*searchTerm) {
+		terms = append(terms, escapeSearchTerm(word)+"*")
+	}
+	if len(terms) == 0 {
+		return queryString
+	}
+	if queryString == "*" {
+		return strings.Join(terms, " ")
+	}
+	return fmt.Sprintf("(%s) %s", queryString, strings.Join(terms, " "))
+}
+
+// escapeSearchTerm escapes the punctuation characters of the RediSearch query syntax
+func escapeSearchTerm(term string) string {
+	var builder strings.Builder
+	for _, r := range term {
+		if strings.ContainsRune(",.<>{}[]\"':;!@#$%^&*()-+=~|/\\", r) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,506 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The fake cache supports the following subset of the RediSearch query syntax in SearchInIndex:
+//   - `*` matches all documents of the index
+//   - clauses separated by whitespace, all of them must match
+//   - `-` prefix negates a clause
+//   - `@field:{a|b}` matches TAG fields equal to one of the values, `a*` matches by prefix
+//   - `@field:[min max]` matches NUMERIC fields in the range, `(` marks exclusive bounds, `-inf` and `+inf` are allowed
+//   - `@field:term`, `@field:(a|b)` and `@field:"a phrase"` match TEXT fields containing the word, one of the words or the phrase
+//   - `term`, `term*` and `"a phrase"` without field match any TEXT field of the index
+//   - `$name` parameters are substituted from FTSearchOptions.Params
+//   - `(a b)` groups clauses, if it contains no `|`
+//
+// Sorting by SortBy and paging by LimitOffset and Limit (default 10) are supported, scoring, stemming, fuzzy
+// matching, top level `|` and every other option are not. Only documents stored with JSON are indexed.
+
+type fakeDocument struct {
+	key   string
+	value []byte
+	data  interface{}
+}
+
+type fakeClauseKind int
+
+const (
+	fakeClauseText fakeClauseKind = iota
+	fakeClauseTag
+	fakeClauseNumeric
+)
+
+type fakeQueryClause struct {
+	negate       bool
+	field        string
+	kind         fakeClauseKind
+	values       []string
+	min          float64
+	max          float64
+	minExclusive bool
+	maxExclusive bool
+}
+
+// indexedDocuments returns the JSON documents matching the prefixes of the index ordered by key, the mutex must be held
+func (f *fakeRedisCache) indexedDocuments(index *fakeIndex) []fakeDocument {
+	documents := make([]fakeDocument, 0)
+	if !index.onJSON {
+		return documents
+	}
+	for key := range f.entries {
+		entry := f.entry(key)
+		if entry == nil || entry.kind != fakeEntryValue || !hasAnyPrefix(key, index.prefixes) {
+			continue
+		}
+		var data interface{}
+		if err := json.Unmarshal(entry.value, &data); err != nil {
+			continue
+		}
+		documents = append(documents, fakeDocument{key: key, value: entry.value, data: data})
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].key < documents[j].key
+	})
+	return documents
+}
+
+// searchDocuments filters, sorts and pages the documents of the index and returns the number of matches, the mutex must be held
+func (f *fakeRedisCache) searchDocuments(index *fakeIndex, queryString string, options *redis.FTSearchOptions) ([]fakeDocument, int, error) {
+	var params map[string]interface{}
+	if options != nil {
+		params = options.Params
+	}
+	clauses, err := parseFakeQuery(queryString, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range clauses {
+		if clauses[i].field != "" && findFakeField(index, clauses[i].field) == nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrFakeUnknownSearchField, clauses[i].field)
+		}
+	}
+	matches := make([]fakeDocument, 0)
+	for _, document := range f.indexedDocuments(index) {
+		if matchesAllClauses(index, document, clauses) {
+			matches = append(matches, document)
+		}
+	}
+	if options != nil && len(options.SortBy) > 0 {
+		for i := range options.SortBy {
+			if findFakeField(index, options.SortBy[i].FieldName) == nil {
+				return nil, 0, fmt.Errorf("%w: %s", ErrFakeUnknownSearchField, options.SortBy[i].FieldName)
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, sortBy := range options.SortBy {
+				field := findFakeField(index, sortBy.FieldName)
+				comparison := compareFakeValues(fieldValues(matches[i].data, field), fieldValues(matches[j].data, field))
+				if comparison != 0 {
+					return (comparison < 0) != sortBy.Desc
+				}
+			}
+			return false
+		})
+	}
+	offset, limit := 0, 10
+	if options != nil {
+		if options.CountOnly {
+			return []fakeDocument{}, len(matches), nil
+		}
+		if options.LimitOffset >= 0 && options.Limit > 0 || options.LimitOffset > 0 && options.Limit == 0 {
+			offset, limit = options.LimitOffset, options.Limit
+		}
+	}
+	if offset >= len(matches) {
+		return []fakeDocument{}, len(matches), nil
+	}
+	return matches[offset:min(offset+limit, len(matches))], len(matches), nil
+}
+
+// Query parsing
+
+func parseFakeQuery(queryString string, params map[string]interface{}) ([]fakeQueryClause, error) {
+	queryString = substituteFakeParams(queryString, params)
+	tokens, err := tokenizeFakeQuery(strings.TrimSpace(queryString))
+	if err != nil {
+		return nil, err
+	}
+	clauses := make([]fakeQueryClause, 0, len(tokens))
+	for _, token := range tokens {
+		if token == "*" {
+			continue
+		}
+		clause := fakeQueryClause{kind: fakeClauseText}
+		if strings.HasPrefix(token, "-") {
+			clause.negate = true
+			token = token[1:]
+		}
+		if strings.HasPrefix(token, "@") {
+			separator := strings.Index(token, ":")
+			if separator < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrFakeQueryNotSupported, token)
+			}
+			clause.field = token[1:separator]
+			token = token[separator+1:]
+		}
+		// Note: groups without alternatives are intersections like the top level
+		if clause.field == "" && !clause.negate && strings.HasPrefix(token, "(") && strings.HasSuffix(token, ")") && !hasTopLevelAlternative(token[1:len(token)-1]) {
+			groupClauses, err := parseFakeQuery(token[1:len(token)-1], nil)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, groupClauses...)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}") && clause.field != "":
+			clause.kind = fakeClauseTag
+			clause.values = splitFakeAlternatives(token[1 : len(token)-1])
+		case strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]") && clause.field != "":
+			clause.kind = fakeClauseNumeric
+			if err = parseFakeRange(token[1:len(token)-1], &clause); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "(") && strings.HasSuffix(token, ")"):
+			clause.values = splitFakeAlternatives(token[1 : len(token)-1])
+		case strings.HasPrefix(token, `"`) && strings.HasSuffix(token, `"`) && len(token) > 1:
+			clause.values = []string{token[1 : len(token)-1]}
+		case token == "" || strings.ContainsAny(token, "|~%{}[]()=>"):
+			return nil, fmt.Errorf("%w: %s", ErrFakeQueryNotSupported, token)
+		default:
+			clause.values = []string{unescapeFakeQuery(token)}
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// substituteFakeParams replaces $name parameters, longer names first to avoid replacing prefixes of other names
+func substituteFakeParams(queryString string, params map[string]interface{}) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	for _, name := range names {
+		queryString = strings.ReplaceAll(queryString, "$"+name, fmt.Sprint(params[name]))
+	}
+	return queryString
+}
+
+// tokenizeFakeQuery splits the query at whitespace outside of brackets and quotes
+func tokenizeFakeQuery(queryString string) ([]string, error) {
+	tokens := make([]string, 0)
+	var current strings.Builder
+	closers := make([]rune, 0)
+	inQuotes := false
+	escaped := false
+	for _, r := range queryString {
+		inRange := len(closers) > 0 && closers[len(closers)-1] == ']'
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case len(closers) > 0 && r == closers[len(closers)-1]:
+			closers = closers[:len(closers)-1]
+		case inRange:
+			// Note: inside numeric ranges "(" marks exclusive bounds
+		case r == '{':
+			closers = append(closers, '}')
+		case r == '[':
+			closers = append(closers, ']')
+		case r == '(':
+			closers = append(closers, ')')
+		case unicode.IsSpace(r) && len(closers) == 0:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if len(closers) != 0 || inQuotes {
+		return nil, fmt.Errorf("%w: unbalanced brackets or quotes", ErrFakeQueryNotSupported)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// hasTopLevelAlternative checks for | outside of brackets and quotes
+func hasTopLevelAlternative(value string) bool {
+	depth := 0
+	inQuotes := false
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case r == '{' || r == '[' || r == '(':
+			depth++
+		case r == '}' || r == ']' || r == ')':
+			depth--
+		case r == '|' && depth == 0:
+			return true
+		}
+	}
+	return false
+}
+
+func splitFakeAlternatives(value string) []string {
+	alternatives := make([]string, 0)
+	var current strings.Builder
+	escaped := false
+	for _, r := range value {
+		if !escaped && r == '|' {
+			alternatives = append(alternatives, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		escaped = !escaped && r == '\\'
+		if !escaped {
+			current.WriteRune(r)
+		}
+	}
+	return append(alternatives, strings.TrimSpace(current.String()))
+}
+
+func unescapeFakeQuery(value string) string {
+	return strings.ReplaceAll(value, "\\", "")
+}
+
+func parseFakeRange(value string, clause *fakeQueryClause) error {
+	bounds := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	if len(bounds) != 2 {
+		return fmt.Errorf("%w: numeric range %s", ErrFakeQueryNotSupported, value)
+	}
+	var err error
+	clause.min, clause.minExclusive, err = parseFakeBound(bounds[0])
+	if err != nil {
+		return err
+	}
+	clause.max, clause.maxExclusive, err = parseFakeBound(bounds[1])
+	return err
+}
+
+func parseFakeBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+	switch strings.ToLower(bound) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "inf", "+inf":
+		return math.Inf(1), exclusive, nil
+	}
+	number, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: numeric bound %s", ErrFakeQueryNotSupported, bound)
+	}
+	return number, exclusive, nil
+}
+
+// Matching
+
+func matchesAllClauses(index *fakeIndex, document fakeDocument, clauses []fakeQueryClause) bool {
+	for _, clause := range clauses {
+		if matchesClause(index, document, clause) == clause.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesClause(index *fakeIndex, document fakeDocument, clause fakeQueryClause) bool {
+	fields := make([]*redis.FieldSchema, 0)
+	if clause.field != "" {
+		fields = append(fields, findFakeField(index, clause.field))
+	} else {
+		for _, field := range index.fields {
+			if field.FieldType == redis.SearchFieldTypeText {
+				fields = append(fields, field)
+			}
+		}
+	}
+	for _, field := range fields {
+		values := fieldValues(document.data, field)
+		switch clause.kind {
+		case fakeClauseTag:
+			if matchesTag(field, values, clause.values) {
+				return true
+			}
+		case fakeClauseNumeric:
+			if matchesRange(values, clause) {
+				return true
+			}
+		default:
+			if matchesText(values, clause.values) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesTag(field *redis.FieldSchema, values []interface{}, expected []string) bool {
+	separator := ","
+	if field.Separator != "" {
+		separator = field.Separator
+	}
+	for _, value := range values {
+		for _, tag := range strings.Split(fmt.Sprint(value), separator) {
+			tag = strings.TrimSpace(tag)
+			for _, expectedTag := range expected {
+				if !field.CaseSensitive {
+					tag, expectedTag = strings.ToLower(tag), strings.ToLower(expectedTag)
+				}
+				if prefix, isPrefix := strings.CutSuffix(expectedTag, "*"); isPrefix && strings.HasPrefix(tag, prefix) || tag == expectedTag {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchesRange(values []interface{}, clause fakeQueryClause) bool {
+	for _, value := range values {
+		number, isNumber := value.(float64)
+		if !isNumber {
+			continue
+		}
+		aboveMin := number > clause.min || !clause.minExclusive && number == clause.min
+		belowMax := number < clause.max || !clause.maxExclusive && number == clause.max
+		if aboveMin && belowMax {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesText(values []interface{}, terms []string) bool {
+	for _, value := range values {
+		text := strings.ToLower(fmt.Sprint(value))
+		words := strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, term := range terms {
+			term = strings.ToLower(term)
+			if strings.ContainsFunc(term, unicode.IsSpace) {
+				if strings.Contains(text, term) {
+					return true
+				}
+				continue
+			}
+			prefix, isPrefix := strings.CutSuffix(term, "*")
+			for _, word := range words {
+				if isPrefix && strings.HasPrefix(word, prefix) || word == term {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Field handling
+
+func findFakeField(index *fakeIndex, name string) *redis.FieldSchema {
+	name = strings.TrimPrefix(name, "@")
+	for _, field := range index.fields {
+		if field.As == name || field.FieldName == name || field.FieldName == "$."+name {
+			return field
+		}
+	}
+	return nil
+}
+
+// fieldValues resolves a simple JSON path like $.a.b or $.a[*].b, arrays at the end of the path are flattened
+func fieldValues(data interface{}, field *redis.FieldSchema) []interface{} {
+	path := strings.TrimPrefix(strings.TrimPrefix(field.FieldName, "$"), ".")
+	current := []interface{}{data}
+	if path != "" {
+		for _, segment := range strings.Split(path, ".") {
+			segment, flatten := strings.CutSuffix(segment, "[*]")
+			next := make([]interface{}, 0)
+			for _, value := range current {
+				object, isObject := value.(map[string]interface{})
+				if !isObject {
+					continue
+				}
+				child, exists := object[segment]
+				if !exists {
+					continue
+				}
+				if array, isArray := child.([]interface{}); isArray && flatten {
+					next = append(next, array...)
+				} else {
+					next = append(next, child)
+				}
+			}
+			current = next
+		}
+	}
+	values := make([]interface{}, 0, len(current))
+	for _, value := range current {
+		if array, isArray := value.([]interface{}); isArray {
+			values = append(values, array...)
+		} else if value != nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// compareFakeValues compares the first values, numbers numerically, everything else as lower case string, missing values last
+func compareFakeValues(a []interface{}, b []interface{}) int {
+	if len(a) == 0 || len(b) == 0 {
+		return len(b) - len(a)
+	}
+	numberA, isNumberA := a[0].(float64)
+	numberB, isNumberB := b[0].(float64)
+	if isNumberA && isNumberB {
+		switch {
+		case numberA < numberB:
+			return -1
+		case numberA > numberB:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(fmt.Sprint(a[0])), strings.ToLower(fmt.Sprint(b[0])))
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
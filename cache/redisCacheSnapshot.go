@@ -0,0 +1,502 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const snapshotFormatVersion = 1
+
+var ErrInvalidSnapshot = errors.New("invalid cache snapshot")
+
+// SnapshotStats counts the keys and indexes of an export or import. Skipped are keys of unsupported types, keys
+// expired during the export and indexes with vector or geoshape fields.
+type SnapshotStats struct {
+	Keys    int
+	Indexes int
+	Skipped int
+}
+
+// A snapshot is a stream of JSON lines, a header followed by the indexes and the keys. Keys, index prefixes and the
+// members of tag sets are stored without the key prefix of the cache, so a snapshot can be imported into a cache
+// with another name.
+
+type snapshotHeader struct {
+	Version    int       `json:"version"`
+	Cache      string    `json:"cache"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// snapshotRecord is a line of a snapshot after the header, holding either a key or an index
+type snapshotRecord struct {
+	Key   *snapshotKey   `json:"key,omitempty"`
+	Index *snapshotIndex `json:"index,omitempty"`
+}
+
+// snapshotKey holds the content of a key in the field of its redis type, TTLMs is the remaining time to live at
+// the time of the export
+type snapshotKey struct {
+	Key     string             `json:"key"`
+	Type    string             `json:"type"`
+	TTLMs   int64              `json:"ttlMs,omitempty"`
+	JSON    json.RawMessage    `json:"json,omitempty"`
+	Value   []byte             `json:"value,omitempty"`
+	Members []string           `json:"members,omitempty"`
+	Scores  map[string]float64 `json:"scores,omitempty"`
+	Fields  map[string]string  `json:"fields,omitempty"`
+	Items   []string           `json:"items,omitempty"`
+}
+
+type snapshotIndex struct {
+	Name     string               `json:"name"`
+	Aliases  []string             `json:"aliases,omitempty"`
+	OnJSON   bool                 `json:"onJson"`
+	Prefixes []string             `json:"prefixes"`
+	Fields   []snapshotIndexField `json:"fields"`
+}
+
+type snapshotIndexField struct {
+	Path            string  `json:"path"`
+	As              string  `json:"as,omitempty"`
+	Type            string  `json:"type"`
+	Sortable        bool    `json:"sortable,omitempty"`
+	UNF             bool    `json:"unf,omitempty"`
+	NoStem          bool    `json:"noStem,omitempty"`
+	NoIndex         bool    `json:"noIndex,omitempty"`
+	PhoneticMatcher string  `json:"phoneticMatcher,omitempty"`
+	Weight          float64 `json:"weight,omitempty"`
+	CaseSensitive   bool    `json:"caseSensitive,omitempty"`
+	WithSuffixtrie  bool    `json:"withSuffixtrie,omitempty"`
+}
+
+const (
+	snapshotTypeJSON   = "ReJSON-RL"
+	snapshotTypeString = "string"
+	snapshotTypeSet    = "set"
+	snapshotTypeZSet   = "zset"
+	snapshotTypeHash   = "hash"
+	snapshotTypeList   = "list"
+)
+
+// Snapshots
+
+// ExportSnapshot writes the keys of the cache with their TTLs, and the indexes on its keys with their aliases,
+// to w. The export is not atomic, keys changed during the export are written in any state. The locks of
+// multiserver mode and of the scheduler are not exported.
+func (c *redisCache) ExportSnapshot(ctx context.Context, w io.Writer) (stats SnapshotStats, err error) {
+	ctx, span := c.startSpan(ctx, "ExportSnapshot")
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, "", true); err != nil {
+		return stats, err
+	}
+	encoder := json.NewEncoder(w)
+	if err = encoder.Encode(snapshotHeader{Version: snapshotFormatVersion, Cache: c.name, ExportedAt: time.Now().UTC()}); err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("writing snapshot header failed"))
+		return stats, err
+	}
+	if c.checkSearchable() == nil {
+		indexes, skipped, err := c.snapshotIndexes(ctx)
+		if err != nil {
+			return stats, err
+		}
+		stats.Skipped += skipped
+		for i := range indexes {
+			if err = encoder.Encode(snapshotRecord{Index: &indexes[i]}); err != nil {
+				log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("writing snapshot index failed"))
+				return stats, err
+			}
+			stats.Indexes++
+		}
+	}
+	excludedKeys := []string{c.keyForSystem(mutexLockFlagKey), c.keyForSystem(schedulerFlagKey)}
+	mutex := &sync.Mutex{}
+	err = c.scanKeys(ctx, c.patternForCategory(""), 500, func(keys []string) error {
+		keys = slices.DeleteFunc(keys, func(key string) bool { return slices.Contains(excludedKeys, key) })
+		records, skipped, err := c.snapshotKeys(ctx, keys)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		stats.Skipped += skipped
+		for i := range records {
+			if err := encoder.Encode(snapshotRecord{Key: &records[i]}); err != nil {
+				return err
+			}
+			stats.Keys++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("exporting snapshot keys failed"))
+		return stats, err
+	}
+	return stats, nil
+}
+
+// ImportSnapshot loads a snapshot written by ExportSnapshot into the cache. Existing keys of the snapshot are
+// replaced, other keys are kept. Indexes which already exist are kept as they are, their aliases are updated.
+func (c *redisCache) ImportSnapshot(ctx context.Context, r io.Reader) (stats SnapshotStats, err error) {
+	ctx, span := c.startSpan(ctx, "ImportSnapshot")
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkAdmin(ctx, "", true); err != nil {
+		return stats, err
+	}
+	decoder := json.NewDecoder(r)
+	var header snapshotHeader
+	if err = decoder.Decode(&header); err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("reading snapshot header failed"))
+		return stats, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if header.Version != snapshotFormatVersion {
+		return stats, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, header.Version)
+	}
+	for {
+		var record snapshotRecord
+		if err = decoder.Decode(&record); errors.Is(err, io.EOF) {
+			return stats, nil
+		} else if err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("reading snapshot record failed"))
+			return stats, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+		}
+		switch {
+		case record.Index != nil:
+			if err = c.importSnapshotIndex(ctx, *record.Index); err != nil {
+				return stats, err
+			}
+			stats.Indexes++
+		case record.Key != nil:
+			var imported bool
+			if imported, err = c.importSnapshotKey(ctx, *record.Key); err != nil {
+				return stats, err
+			}
+			if imported {
+				stats.Keys++
+			} else {
+				stats.Skipped++
+			}
+		}
+	}
+}
+
+// snapshotIndexes returns the indexes whose prefixes all belong to the cache, and the number of skipped indexes
+func (c *redisCache) snapshotIndexes(ctx context.Context) ([]snapshotIndex, int, error) {
+	names, err := c.redisClient.FT_List(ctx).Result()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("listing indexes failed"))
+		return nil, 0, err
+	}
+	indexes := make([]snapshotIndex, 0)
+	skipped := 0
+	for _, name := range names {
+		info, err := c.redisClient.FTInfo(ctx, name).Result()
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("index", name).Msg(c.fmtMsg("getting index info failed"))
+			return nil, 0, err
+		}
+		prefixes, ok := c.relativePrefixes(info.IndexDefinition.Prefixes)
+		if !ok {
+			continue
+		}
+		index := snapshotIndex{Name: c.relativeIndexName(name), OnJSON: info.IndexDefinition.KeyType == "JSON", Prefixes: prefixes}
+		if index.Fields, ok = snapshotIndexFields(info.Attributes); !ok {
+			log.Warn().Ctx(ctx).Str("index", name).Msg(c.fmtMsg("skipping index with vector or geoshape fields"))
+			skipped++
+			continue
+		}
+		// Note: redis does not list aliases, the alias of EnsureIndex is the index name without the schema hash
+		if separator := len(name) - indexSchemaHashLength - 1; separator > 0 && name[separator] == '_' {
+			alias := name[:separator]
+			if current, err := c.resolveIndex(ctx, alias); err == nil && current == name {
+				index.Aliases = []string{c.relativeIndexName(alias)}
+			}
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, skipped, nil
+}
+
+// snapshotKeys reads the types, TTLs and contents of the keys, keys of unsupported types or deleted meanwhile are
+// skipped
+func (c *redisCache) snapshotKeys(ctx context.Context, keys []string) ([]snapshotKey, int, error) {
+	if len(keys) == 0 {
+		return nil, 0, nil
+	}
+	pipeline := c.redisClient.Pipeline()
+	typeCmds := make([]*redis.StatusCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		typeCmds[i] = pipeline.Type(ctx, key)
+		ttlCmds[i] = pipeline.PTTL(ctx, key)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+	pipeline = c.redisClient.Pipeline()
+	records := make([]snapshotKey, 0, len(keys))
+	contentCmds := make([]redis.Cmder, 0, len(keys))
+	skipped := 0
+	for i, key := range keys {
+		record := snapshotKey{Key: strings.TrimPrefix(key, c.keyPrefix+":"), Type: typeCmds[i].Val()}
+		if ttl := ttlCmds[i].Val(); ttl > 0 {
+			record.TTLMs = max(ttl.Milliseconds(), 1)
+		}
+		var cmd redis.Cmder
+		switch record.Type {
+		case snapshotTypeJSON:
+			cmd = pipeline.JSONGet(ctx, key)
+		case snapshotTypeString:
+			cmd = pipeline.Get(ctx, key)
+		case snapshotTypeSet:
+			cmd = pipeline.SMembers(ctx, key)
+		case snapshotTypeZSet:
+			cmd = pipeline.ZRangeWithScores(ctx, key, 0, -1)
+		case snapshotTypeHash:
+			cmd = pipeline.HGetAll(ctx, key)
+		case snapshotTypeList:
+			cmd = pipeline.LRange(ctx, key, 0, -1)
+		default:
+			skipped++
+			continue
+		}
+		records = append(records, record)
+		contentCmds = append(contentCmds, cmd)
+	}
+	if len(contentCmds) == 0 {
+		return records, skipped, nil
+	}
+	_, _ = pipeline.Exec(ctx)
+	contents := make([]snapshotKey, 0, len(records))
+	for i, record := range records {
+		err := contentCmds[i].Err()
+		if errors.Is(err, redis.Nil) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch cmd := contentCmds[i].(type) {
+		case *redis.JSONCmd:
+			record.JSON = json.RawMessage(cmd.Val())
+		case *redis.StringCmd:
+			record.Value, _ = cmd.Bytes()
+		case *redis.StringSliceCmd:
+			if record.isTagSet() {
+				record.Members = c.relativeTagMembers(cmd.Val())
+			} else if record.Type == snapshotTypeSet {
+				record.Members = cmd.Val()
+			} else {
+				record.Items = cmd.Val()
+			}
+		case *redis.ZSliceCmd:
+			record.Scores = make(map[string]float64, len(cmd.Val()))
+			for _, member := range cmd.Val() {
+				record.Scores[fmt.Sprint(member.Member)] = member.Score
+			}
+		case *redis.MapStringStringCmd:
+			record.Fields = cmd.Val()
+		}
+		contents = append(contents, record)
+	}
+	return contents, skipped, nil
+}
+
+// importSnapshotKey replaces the key with the content of the record, it returns false for unsupported types
+func (c *redisCache) importSnapshotKey(ctx context.Context, record snapshotKey) (bool, error) {
+	if record.Key == "" {
+		return false, fmt.Errorf("%w: key without name", ErrInvalidSnapshot)
+	}
+	key := fmt.Sprintf("%s:%s", c.keyPrefix, record.Key)
+	pipeline := c.redisClient.TxPipeline()
+	pipeline.Del(ctx, key)
+	switch record.Type {
+	case snapshotTypeJSON:
+		pipeline.JSONSet(ctx, key, "$", []byte(record.JSON))
+	case snapshotTypeString:
+		pipeline.Set(ctx, key, record.Value, 0)
+	case snapshotTypeSet:
+		members := make([]interface{}, len(record.Members))
+		for i, member := range c.absoluteTagMembers(record) {
+			members[i] = member
+		}
+		pipeline.SAdd(ctx, key, members...)
+	case snapshotTypeZSet:
+		members := make([]redis.Z, 0, len(record.Scores))
+		for member, score := range record.Scores {
+			members = append(members, redis.Z{Member: member, Score: score})
+		}
+		pipeline.ZAdd(ctx, key, members...)
+	case snapshotTypeHash:
+		pipeline.HSet(ctx, key, record.Fields)
+	case snapshotTypeList:
+		items := make([]interface{}, len(record.Items))
+		for i := range record.Items {
+			items[i] = record.Items[i]
+		}
+		pipeline.RPush(ctx, key, items...)
+	default:
+		return false, nil
+	}
+	if record.TTLMs > 0 {
+		pipeline.PExpire(ctx, key, time.Duration(record.TTLMs)*time.Millisecond)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(c.fmtMsg("importing snapshot key failed"))
+		return false, err
+	}
+	return true, nil
+}
+
+// importSnapshotIndex creates the index unless it exists and points its aliases to it
+func (c *redisCache) importSnapshotIndex(ctx context.Context, index snapshotIndex) error {
+	if index.OnJSON {
+		if err := c.checkSearchable(); err != nil {
+			log.Error().Ctx(ctx).Err(c.fmtErr(err)).Send()
+			return err
+		}
+	}
+	options, fieldSchemas, err := index.schema(c.keyPrefix)
+	if err != nil {
+		return err
+	}
+	indexName := c.indexName(index.Name)
+	err = c.redisClient.FTCreate(ctx, indexName, options, fieldSchemas...).Err()
+	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "already exists") {
+		log.Error().Ctx(ctx).Err(err).Str("index", indexName).Msg(c.fmtMsg("creating index failed"))
+		return err
+	}
+	for _, alias := range index.Aliases {
+		if err = c.redisClient.FTAliasUpdate(ctx, indexName, c.indexName(alias)).Err(); err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("index", indexName).Msg(c.fmtMsg("updating index alias failed"))
+			return err
+		}
+	}
+	return nil
+}
+
+// relativePrefixes strips the key prefix of the cache from the prefixes, it returns false if a prefix does not
+// belong to the cache
+func (c *redisCache) relativePrefixes(prefixes []string) ([]string, bool) {
+	if len(prefixes) == 0 {
+		return nil, false
+	}
+	relative := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		var ok bool
+		if relative[i], ok = strings.CutPrefix(prefix, c.keyPrefix+":"); !ok {
+			return nil, false
+		}
+	}
+	return relative, true
+}
+
+// schema returns the create options and field schemas of the index for the key prefix
+func (index snapshotIndex) schema(keyPrefix string) (*redis.FTCreateOptions, []*redis.FieldSchema, error) {
+	if index.Name == "" || len(index.Fields) == 0 {
+		return nil, nil, fmt.Errorf("%w: index without name or fields", ErrInvalidSnapshot)
+	}
+	options := &redis.FTCreateOptions{OnJSON: index.OnJSON, OnHash: !index.OnJSON}
+	for _, prefix := range index.Prefixes {
+		options.Prefix = append(options.Prefix, fmt.Sprintf("%s:%s", keyPrefix, prefix))
+	}
+	fieldSchemas := make([]*redis.FieldSchema, len(index.Fields))
+	for i, field := range index.Fields {
+		fieldType := searchFieldType(field.Type)
+		if fieldType == redis.SearchFieldTypeInvalid {
+			return nil, nil, fmt.Errorf("%w: unsupported field type %s", ErrInvalidSnapshot, field.Type)
+		}
+		fieldSchemas[i] = &redis.FieldSchema{
+			FieldName:       field.Path,
+			As:              field.As,
+			FieldType:       fieldType,
+			Sortable:        field.Sortable,
+			UNF:             field.UNF,
+			NoStem:          field.NoStem,
+			NoIndex:         field.NoIndex,
+			PhoneticMatcher: field.PhoneticMatcher,
+			Weight:          field.Weight,
+			CaseSensitive:   field.CaseSensitive,
+			WithSuffixtrie:  field.WithSuffixtrie,
+		}
+	}
+	return options, fieldSchemas, nil
+}
+
+// snapshotIndexFields converts the attributes of FT.INFO, it returns false for vector and geoshape fields
+func snapshotIndexFields(attributes []redis.FTAttribute) ([]snapshotIndexField, bool) {
+	fields := make([]snapshotIndexField, len(attributes))
+	for i, attribute := range attributes {
+		if fieldType := searchFieldType(attribute.Type); fieldType == redis.SearchFieldTypeInvalid {
+			return nil, false
+		}
+		fields[i] = snapshotIndexField{
+			Path:            attribute.Identifier,
+			Type:            attribute.Type,
+			Sortable:        attribute.Sortable,
+			UNF:             attribute.UNF,
+			NoStem:          attribute.NoStem,
+			NoIndex:         attribute.NoIndex,
+			PhoneticMatcher: attribute.PhoneticMatcher,
+			CaseSensitive:   attribute.CaseSensitive,
+			WithSuffixtrie:  attribute.WithSuffixtrie,
+		}
+		if attribute.Attribute != attribute.Identifier {
+			fields[i].As = attribute.Attribute
+		}
+		if attribute.Weight != 1 {
+			fields[i].Weight = attribute.Weight
+		}
+	}
+	return fields, true
+}
+
+// searchFieldType returns the supported field type of the name, or SearchFieldTypeInvalid
+func searchFieldType(name string) redis.SearchFieldType {
+	for _, fieldType := range []redis.SearchFieldType{redis.SearchFieldTypeText, redis.SearchFieldTypeTag, redis.SearchFieldTypeNumeric, redis.SearchFieldTypeGeo} {
+		if strings.EqualFold(fieldType.String(), name) {
+			return fieldType
+		}
+	}
+	return redis.SearchFieldTypeInvalid
+}
+
+// isTagSet returns true for the set of a tag, whose members are keys of the cache
+func (k snapshotKey) isTagSet() bool {
+	return k.Type == snapshotTypeSet && strings.HasPrefix(k.Key, string(KeyCategoryTag)+":")
+}
+
+// relativeTagMembers strips the key prefix of the cache from the members of a tag set, members which do not belong
+// to the cache are dropped
+func (c *redisCache) relativeTagMembers(members []string) []string {
+	relative := make([]string, 0, len(members))
+	for _, member := range members {
+		if key, ok := strings.CutPrefix(member, c.keyPrefix+":"); ok {
+			relative = append(relative, key)
+		}
+	}
+	return relative
+}
+
+// absoluteTagMembers returns the members of the set, the members of a tag set prefixed with the key prefix of the
+// cache
+func (c *redisCache) absoluteTagMembers(record snapshotKey) []string {
+	if !record.isTagSet() {
+		return record.Members
+	}
+	members := make([]string, len(record.Members))
+	for i, member := range record.Members {
+		members[i] = fmt.Sprintf("%s:%s", c.keyPrefix, member)
+	}
+	return members
+}
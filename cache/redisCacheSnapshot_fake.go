@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fake writes and reads the snapshot format of the real cache, so snapshots exported from redis can seed
+// unit tests. It supports JSON values, sets, flags, counters, sequences and indexes, job queues and keys of other
+// types or values of other codecs are skipped.
+
+func (f *fakeRedisCache) ExportSnapshot(ctx context.Context, w io.Writer) (SnapshotStats, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ExportSnapshot")
+	var stats SnapshotStats
+	if err := f.checkAdmin("", true); err != nil {
+		return stats, err
+	}
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(snapshotHeader{Version: snapshotFormatVersion, Cache: f.helper.name, ExportedAt: f.now().UTC()}); err != nil {
+		return stats, err
+	}
+	indexNames := make([]string, 0, len(f.indexes))
+	for name := range f.indexes {
+		indexNames = append(indexNames, name)
+	}
+	sort.Strings(indexNames)
+	for _, name := range indexNames {
+		index, ok := f.snapshotIndex(name)
+		if !ok {
+			continue
+		}
+		if err := encoder.Encode(snapshotRecord{Index: &index}); err != nil {
+			return stats, err
+		}
+		stats.Indexes++
+	}
+	for _, info := range f.keyInfos("") {
+		record := snapshotKey{Key: strings.TrimPrefix(info.Key, f.helper.keyPrefix+":"), Type: info.Type}
+		if info.TTL != nil {
+			record.TTLMs = max(info.TTL.Milliseconds(), 1)
+		}
+		if entry := f.entry(info.Key); entry != nil {
+			switch entry.kind {
+			case fakeEntryValue:
+				record.JSON = json.RawMessage(entry.value)
+			case fakeEntrySet:
+				for member := range entry.members {
+					record.Members = append(record.Members, member)
+				}
+				if record.isTagSet() {
+					record.Members = f.helper.relativeTagMembers(record.Members)
+				}
+				sort.Strings(record.Members)
+			case fakeEntryFlag:
+				record.Value = []byte{}
+			}
+		} else if counter := f.counter(info.Key); counter != nil {
+			record.Value = []byte(strconv.FormatInt(counter.value, 10))
+		} else {
+			stats.Skipped++
+			continue
+		}
+		if err := encoder.Encode(snapshotRecord{Key: &record}); err != nil {
+			return stats, err
+		}
+		stats.Keys++
+	}
+	return stats, nil
+}
+
+func (f *fakeRedisCache) ImportSnapshot(ctx context.Context, r io.Reader) (SnapshotStats, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ImportSnapshot")
+	var stats SnapshotStats
+	if err := f.checkAdmin("", true); err != nil {
+		return stats, err
+	}
+	decoder := json.NewDecoder(r)
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return stats, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if header.Version != snapshotFormatVersion {
+		return stats, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, header.Version)
+	}
+	for {
+		var record snapshotRecord
+		if err := decoder.Decode(&record); errors.Is(err, io.EOF) {
+			return stats, nil
+		} else if err != nil {
+			return stats, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+		}
+		switch {
+		case record.Index != nil:
+			if err := f.importSnapshotIndex(*record.Index); err != nil {
+				return stats, err
+			}
+			stats.Indexes++
+		case record.Key != nil:
+			imported, err := f.importSnapshotKey(*record.Key)
+			if err != nil {
+				return stats, err
+			}
+			if imported {
+				stats.Keys++
+			} else {
+				stats.Skipped++
+			}
+		}
+	}
+}
+
+// snapshotIndex returns the index if its prefixes belong to the cache, the mutex must be held
+func (f *fakeRedisCache) snapshotIndex(name string) (snapshotIndex, bool) {
+	index := f.indexes[name]
+	prefixes, ok := f.helper.relativePrefixes(index.prefixes)
+	if !ok {
+		return snapshotIndex{}, false
+	}
+	snapshot := snapshotIndex{Name: f.helper.relativeIndexName(name), OnJSON: index.onJSON, Prefixes: prefixes}
+	for _, fieldSchema := range index.fields {
+		snapshot.Fields = append(snapshot.Fields, snapshotIndexField{
+			Path:            fieldSchema.FieldName,
+			As:              fieldSchema.As,
+			Type:            fieldSchema.FieldType.String(),
+			Sortable:        fieldSchema.Sortable,
+			UNF:             fieldSchema.UNF,
+			NoStem:          fieldSchema.NoStem,
+			NoIndex:         fieldSchema.NoIndex,
+			PhoneticMatcher: fieldSchema.PhoneticMatcher,
+			Weight:          fieldSchema.Weight,
+			CaseSensitive:   fieldSchema.CaseSensitive,
+			WithSuffixtrie:  fieldSchema.WithSuffixtrie,
+		})
+	}
+	for alias, aliasedIndex := range f.aliases {
+		if aliasedIndex == name {
+			snapshot.Aliases = append(snapshot.Aliases, f.helper.relativeIndexName(alias))
+		}
+	}
+	sort.Strings(snapshot.Aliases)
+	return snapshot, true
+}
+
+// importSnapshotIndex creates the index unless it exists and points its aliases to it, the mutex must be held
+func (f *fakeRedisCache) importSnapshotIndex(index snapshotIndex) error {
+	options, fieldSchemas, err := index.schema(f.helper.keyPrefix)
+	if err != nil {
+		return err
+	}
+	if err = f.createIndex(index.Name, options, fieldSchemas); err != nil && !errors.Is(err, ErrFakeIndexExists) {
+		return err
+	}
+	for _, alias := range index.Aliases {
+		f.aliases[f.helper.indexName(alias)] = f.helper.indexName(index.Name)
+	}
+	return nil
+}
+
+// importSnapshotKey replaces the key with the content of the record, it returns false for unsupported types, the
+// mutex must be held
+func (f *fakeRedisCache) importSnapshotKey(record snapshotKey) (bool, error) {
+	if record.Key == "" {
+		return false, fmt.Errorf("%w: key without name", ErrInvalidSnapshot)
+	}
+	key := fmt.Sprintf("%s:%s", f.helper.keyPrefix, record.Key)
+	var expiresAt *time.Time
+	if record.TTLMs > 0 {
+		expiration := f.now().Add(time.Duration(record.TTLMs) * time.Millisecond)
+		expiresAt = &expiration
+	}
+	decoded, _ := f.helper.DecodeKey(key)
+	var entry *fakeEntry
+	switch record.Type {
+	case snapshotTypeJSON:
+		if !json.Valid(record.JSON) {
+			return false, fmt.Errorf("%w: %s", ErrFakeInvalidJSONDocument, key)
+		}
+		entry = &fakeEntry{kind: fakeEntryValue, value: record.JSON}
+	case snapshotTypeSet:
+		entry = &fakeEntry{kind: fakeEntrySet, members: make(map[string]struct{}, len(record.Members))}
+		for _, member := range f.helper.absoluteTagMembers(record) {
+			entry.members[member] = struct{}{}
+		}
+	case snapshotTypeString:
+		if decoded.Category == KeyCategoryCounter || decoded.Category == KeyCategorySequence {
+			value, err := strconv.ParseInt(string(record.Value), 10, 64)
+			if err != nil {
+				return false, nil
+			}
+			delete(f.entries, key)
+			f.counters[key] = &fakeCounter{value: value, expiresAt: expiresAt}
+			return true, nil
+		}
+		if len(record.Value) == 0 {
+			entry = &fakeEntry{kind: fakeEntryFlag}
+		} else if json.Valid(record.Value) {
+			entry = &fakeEntry{kind: fakeEntryValue, value: record.Value}
+		} else {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	entry.expiresAt = expiresAt
+	delete(f.counters, key)
+	f.entries[key] = entry
+	return true, nil
+}
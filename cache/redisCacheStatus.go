@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type RefreshStatus struct {
+	SchedulerRunning bool
+	RefreshRunning   bool
+	RefreshAttempt   int
+	LastStartedAt    *time.Time
+	LastSucceededAt  *time.Time
+	LastDuration     time.Duration
+	LastError        error
+}
+
+type CacheStatus struct {
+	RefreshStatus
+	Name              string
+	InstanceId        string
+	Valid             bool
+	RefreshInstanceId string
+	// KeyCount counts the keys of cached content, without system keys, job queues, counters, sequences,
+	// idempotency records and rate limits
+	KeyCount     int64
+	CircuitState CircuitState
+}
+
+// RefreshEvent is passed to the refresh hooks, Attempt, Duration and Err are only set on success and failure
+type RefreshEvent struct {
+	CacheName   string
+	InstanceId  string
+	StartedAt   time.Time
+	Attempt     int
+	Duration    time.Duration
+	Err         error
+	Incremental bool
+}
+
+// RefreshHooks are optional callbacks invoked synchronously from the refreshing goroutine, they should return quickly
+type RefreshHooks struct {
+	OnStart   func(ctx context.Context, event RefreshEvent)
+	OnSuccess func(ctx context.Context, event RefreshEvent)
+	OnFailure func(ctx context.Context, event RefreshEvent)
+}
+
+// Status
+
+func (c *redisCache) RefreshStatus() RefreshStatus {
+	c.refreshStatusMutex.RLock()
+	defer c.refreshStatusMutex.RUnlock()
+	return c.refreshStatus
+}
+
+// Status collects the local refresh status, and the validity, refreshing instance and key count from redis
+func (c *redisCache) Status(ctx context.Context) (CacheStatus, error) {
+	ctx = c.circuitContext(ctx)
+	status := CacheStatus{
+		RefreshStatus: c.RefreshStatus(),
+		Name:          c.name,
+		InstanceId:    c.instanceId,
+		CircuitState:  c.CircuitState(),
+	}
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return status, c.configErr()
+	}
+	if c.config.IsDisabled {
+		return status, ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return status, ErrNoClientSet
+	}
+	status.Valid = c.IsValid(ctx)
+	if c.config.MultiserverMode {
+		refreshInstanceId, err := c.redisClient.Get(ctx, c.keyForSystem(mutexLockFlagKey)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.Error().Ctx(ctx).Err(err).Msg(c.fmtMsg("getting mutex flag failed"))
+			return status, err
+		}
+		status.RefreshRunning = refreshInstanceId != ""
+		status.RefreshInstanceId = refreshInstanceId
+	} else if status.RefreshRunning {
+		status.RefreshInstanceId = c.instanceId
+	}
+	keyCount, err := c.countKeys(ctx)
+	if err != nil {
+		return status, err
+	}
+	status.KeyCount = keyCount
+	return status, nil
+}
+
+// countKeys counts the keys of cached content with the cache's prefix
+func (c *redisCache) countKeys(ctx context.Context) (int64, error) {
+	prefix := fmt.Sprintf("%s:*", c.keyPrefix)
+	var count atomic.Int64
+	err := c.scanKeys(ctx, prefix, 500, func(keys []string) error {
+		for _, key := range keys {
+			if c.isContentKey(key) {
+				count.Add(1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("prefix", prefix).Msg(c.fmtMsg("counting keys by prefix failed"))
+		return 0, err
+	}
+	return count.Load(), nil
+}
+
+func (c *redisCache) recordRefreshStart(startedAt time.Time) {
+	c.refreshStatusMutex.Lock()
+	defer c.refreshStatusMutex.Unlock()
+	c.refreshStatus.RefreshRunning = true
+	c.refreshStatus.RefreshAttempt = 0
+	c.refreshStatus.LastStartedAt = &startedAt
+}
+
+func (c *redisCache) recordRefreshAttempt(attempt int) {
+	c.refreshStatusMutex.Lock()
+	defer c.refreshStatusMutex.Unlock()
+	c.refreshStatus.RefreshAttempt = attempt
+}
+
+func (c *redisCache) recordRefreshResult(startedAt time.Time, err error) {
+	c.refreshStatusMutex.Lock()
+	defer c.refreshStatusMutex.Unlock()
+	c.refreshStatus.RefreshRunning = false
+	c.refreshStatus.LastDuration = time.Since(startedAt)
+	c.refreshStatus.LastError = err
+	if err == nil {
+		finishedAt := startedAt.Add(c.refreshStatus.LastDuration)
+		c.refreshStatus.LastSucceededAt = &finishedAt
+	}
+}
+
+// callRefreshHook calls the hook if set, a panicking hook must not break the refresh
+func (c *redisCache) callRefreshHook(ctx context.Context, hook func(ctx context.Context, event RefreshEvent), event RefreshEvent) {
+	if hook == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Ctx(ctx).Interface("panic", r).Msg(c.fmtMsg("refresh hook panicked"))
+		}
+	}()
+	event.CacheName = c.name
+	event.InstanceId = c.instanceId
+	hook(ctx, event)
+}
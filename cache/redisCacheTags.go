@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// tagCleanupSampleSize is the number of members checked for expired entries when tagging
+	tagCleanupSampleSize = 10
+	// tagDeleteBatchSize limits the number of keys of one DEL when invalidating
+	tagDeleteBatchSize = 1000
+	// tagTransactionAttempts limits the retries of a tag transaction whose watched keys were modified
+	tagTransactionAttempts = 10
+)
+
+var ErrInvalidTag = errors.New("tag must not be empty")
+
+// Tag handling
+
+// StoreWithTags stores the content and adds the key to the sets of the tags in one transaction. Without
+// expiration the DefaultExpiration is used if set. The tag sets are ordinary sets at KeyForTag, so the set
+// functions can be used to inspect them. Members of expired or deleted entries are removed lazily.
+func (c *redisCache) StoreWithTags(ctx context.Context, key string, content interface{}, expirationTime *time.Duration, tags ...string) (err error) {
+	ctx, span := c.startSpan(ctx, "StoreWithTags", attribute.String("cache.key", key), attribute.StringSlice("cache.tags", tags))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkTags(ctx, tags); err != nil {
+		return err
+	}
+	expiration := c.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	payload, err := c.codec().Marshal(content)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Msg(c.fmtMsg("marshal failed"))
+		return err
+	}
+	c.recordPayloadSize(ctx, "Store", len(payload))
+	var ttl time.Duration
+	if expiration != nil {
+		ttl = expirationWithJitter(*expiration, c.config.ExpirationJitter)
+	}
+	tagKeys := c.tagKeys(tags)
+	err = c.watchTags(ctx, append([]string{key}, tagKeys...), func(tx *redis.Tx) error {
+		tagUpdates, err := c.readTagsForUpdate(ctx, tx, tagKeys)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipeline redis.Pipeliner) error {
+			c.setValue(ctx, pipeline, key, payload)
+			if ttl > 0 {
+				pipeline.PExpire(ctx, key, ttl)
+			}
+			for _, update := range tagUpdates {
+				if len(update.missingMembers) > 0 {
+					pipeline.SRem(ctx, update.key, update.missingMembers...)
+				}
+				pipeline.SAdd(ctx, update.key, key)
+				// Note: the tag set expires with the longest living entry, and never with an entry without expiration
+				if ttl <= 0 {
+					pipeline.Persist(ctx, update.key)
+				} else if !update.exists || (update.ttl >= 0 && update.ttl < ttl) {
+					pipeline.PExpire(ctx, update.key, ttl)
+				}
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Interface("key", key).Strs("tags", tags).Msg(c.fmtMsg("storing with tags failed"))
+		return err
+	}
+	return nil
+}
+
+// InvalidateTags atomically deletes all entries with any of the tags and the tag sets, returning the number
+// of deleted entries
+func (c *redisCache) InvalidateTags(ctx context.Context, tags ...string) (deletedCount int, err error) {
+	ctx, span := c.startSpan(ctx, "InvalidateTags", attribute.StringSlice("cache.tags", tags))
+	defer func() { c.endSpan(span, err) }()
+	if err = c.checkTags(ctx, tags); err != nil {
+		return 0, err
+	}
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	tagKeys := c.tagKeys(tags)
+	err = c.watchTags(ctx, tagKeys, func(tx *redis.Tx) error {
+		memberCmds := make([]*redis.StringSliceCmd, len(tagKeys))
+		if _, err := tx.Pipelined(ctx, func(pipeline redis.Pipeliner) error {
+			for i, tagKey := range tagKeys {
+				memberCmds[i] = pipeline.SMembers(ctx, tagKey)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		var deleteCmds []*redis.IntCmd
+		_, err := tx.TxPipelined(ctx, func(pipeline redis.Pipeliner) error {
+			for _, memberCmd := range memberCmds {
+				for batch := range slices.Chunk(memberCmd.Val(), tagDeleteBatchSize) {
+					deleteCmds = append(deleteCmds, pipeline.Del(ctx, batch...))
+				}
+			}
+			pipeline.Del(ctx, tagKeys...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		deletedCount = 0
+		for _, deleteCmd := range deleteCmds {
+			deletedCount += int(deleteCmd.Val())
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Strs("tags", tags).Msg(c.fmtMsg("invalidating tags failed"))
+		return 0, err
+	}
+	return deletedCount, nil
+}
+
+// tagUpdate is the state of a tag set read in the watched transaction of StoreWithTags
+type tagUpdate struct {
+	key            string
+	exists         bool
+	ttl            time.Duration
+	missingMembers []interface{}
+}
+
+// readTagsForUpdate reads the expirations of the tag sets and a sample of their members, whose entries no longer
+// exist. Like the active expiry of redis only a sample is checked, the sampled entries are watched too, so an
+// entry stored meanwhile is not removed from the tags.
+func (c *redisCache) readTagsForUpdate(ctx context.Context, tx *redis.Tx, tagKeys []string) ([]tagUpdate, error) {
+	ttlCmds := make([]*redis.DurationCmd, len(tagKeys))
+	sampleCmds := make([]*redis.StringSliceCmd, len(tagKeys))
+	if _, err := tx.Pipelined(ctx, func(pipeline redis.Pipeliner) error {
+		for i, tagKey := range tagKeys {
+			ttlCmds[i] = pipeline.PTTL(ctx, tagKey)
+			sampleCmds[i] = pipeline.SRandMemberN(ctx, tagKey, tagCleanupSampleSize)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	members := make([]string, 0)
+	for _, sampleCmd := range sampleCmds {
+		members = append(members, sampleCmd.Val()...)
+	}
+	existsCmds := make(map[string]*redis.IntCmd, len(members))
+	if len(members) > 0 {
+		if err := tx.Watch(ctx, members...).Err(); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Pipelined(ctx, func(pipeline redis.Pipeliner) error {
+			for _, member := range members {
+				existsCmds[member] = pipeline.Exists(ctx, member)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	updates := make([]tagUpdate, len(tagKeys))
+	for i, tagKey := range tagKeys {
+		// Note: PTTL returns -2 for a missing key and -1 for a key without expiration
+		updates[i] = tagUpdate{key: tagKey, exists: ttlCmds[i].Val() != -2, ttl: ttlCmds[i].Val()}
+		for _, member := range sampleCmds[i].Val() {
+			if existsCmds[member].Val() == 0 {
+				updates[i].missingMembers = append(updates[i].missingMembers, member)
+			}
+		}
+	}
+	return updates, nil
+}
+
+// watchTags runs fn in a transaction watching the keys, it is retried if a watched key was modified
+func (c *redisCache) watchTags(ctx context.Context, tagKeys []string, fn func(tx *redis.Tx) error) error {
+	var err error
+	for range tagTransactionAttempts {
+		if err = c.redisClient.Watch(ctx, fn, tagKeys...); !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *redisCache) checkTags(ctx context.Context, tags []string) error {
+	if c.config == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(c.configErr())).Send()
+		return c.configErr()
+	}
+	if c.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	if c.redisClient == nil {
+		log.Error().Ctx(ctx).Err(c.fmtErr(ErrNoClientSet)).Send()
+		return ErrNoClientSet
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			return ErrInvalidTag
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) tagKeys(tags []string) []string {
+	keys := make([]string, len(tags))
+	for i := range tags {
+		keys[i] = c.KeyForTag(tags[i])
+	}
+	return keys
+}
+
+// KeyForTag returns the key of the set holding the keys of the entries with the tag
+func (c *redisCache) KeyForTag(tag string) string {
+	return fmt.Sprintf("%s:TAG:%s", c.keyPrefix, tag)
+}
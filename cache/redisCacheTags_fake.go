@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Unlike the real cache, which checks a sample of members, the fake removes all members of expired or
+// deleted entries when tagging
+
+func (f *fakeRedisCache) StoreWithTags(ctx context.Context, key string, content interface{}, expirationTime *time.Duration, tags ...string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("StoreWithTags", key)
+	tagKeys, err := f.checkTags(tags)
+	if err != nil {
+		return err
+	}
+	expiration := f.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	if err = f.storeValue(key, content, expiration); err != nil {
+		return err
+	}
+	expiresAt := f.entries[key].expiresAt
+	for _, tagKey := range tagKeys {
+		tag := f.entry(tagKey)
+		if tag == nil {
+			tag = &fakeEntry{kind: fakeEntrySet, members: make(map[string]struct{}), expiresAt: expiresAt}
+			f.entries[tagKey] = tag
+		}
+		tag.members[key] = struct{}{}
+		if expiresAt == nil {
+			tag.expiresAt = nil
+		} else if tag.expiresAt != nil && tag.expiresAt.Before(*expiresAt) {
+			tag.expiresAt = expiresAt
+		}
+		for member := range tag.members {
+			if f.entry(member) == nil {
+				delete(tag.members, member)
+			}
+		}
+	}
+	return nil
+}
+func (f *fakeRedisCache) InvalidateTags(ctx context.Context, tags ...string) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	tagKeys, err := f.checkTags(tags)
+	f.record("InvalidateTags", tagKeys...)
+	if err != nil {
+		return 0, err
+	}
+	deletedCount := 0
+	for _, tagKey := range tagKeys {
+		tag := f.entry(tagKey)
+		if tag == nil {
+			continue
+		}
+		for _, member := range sortedKeys(tag.members) {
+			if f.entry(member) != nil {
+				delete(f.entries, member)
+				deletedCount++
+			}
+		}
+		delete(f.entries, tagKey)
+	}
+	return deletedCount, nil
+}
+
+// checkTags mirrors the checks of the real cache and returns the keys of the tag sets, which must be sets,
+// the mutex must be held
+func (f *fakeRedisCache) checkTags(tags []string) ([]string, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	tagKeys := make([]string, len(tags))
+	for i := range tags {
+		if tags[i] == "" {
+			return nil, ErrInvalidTag
+		}
+		tagKeys[i] = f.helper.KeyForTag(tags[i])
+		if tag := f.entry(tagKeys[i]); tag != nil && tag.kind != fakeEntrySet {
+			return nil, ErrFakeWrongType
+		}
+	}
+	return tagKeys, nil
+}
+
+func (f *fakeRedisCache) KeyForTag(tag string) string {
+	return f.helper.KeyForTag(tag)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/blutspende/bloodlab-common/cache"
+
+type cacheTelemetry struct {
+	tracer          trace.Tracer
+	nameAttribute   attribute.KeyValue
+	hits            metric.Int64Counter
+	misses          metric.Int64Counter
+	invalidReads    metric.Int64Counter
+	negativeHits    metric.Int64Counter
+	refreshFailures metric.Int64Counter
+	refreshDuration metric.Float64Histogram
+	payloadSize     metric.Int64Histogram
+}
+
+// newCacheTelemetry creates the instruments from the global providers if enabled, otherwise from no-op providers
+func newCacheTelemetry(name string, enabled bool) (*cacheTelemetry, error) {
+	var tracerProvider trace.TracerProvider = tracenoop.NewTracerProvider()
+	var meterProvider metric.MeterProvider = metricnoop.NewMeterProvider()
+	if enabled {
+		tracerProvider = otel.GetTracerProvider()
+		meterProvider = otel.GetMeterProvider()
+	}
+	meter := meterProvider.Meter(instrumentationName)
+	telemetry := &cacheTelemetry{
+		tracer:        tracerProvider.Tracer(instrumentationName),
+		nameAttribute: attribute.String("cache.name", name),
+	}
+	var err error
+	if telemetry.hits, err = meter.Int64Counter("cache.hits",
+		metric.WithDescription("Number of keys read from the cache")); err != nil {
+		return nil, err
+	}
+	if telemetry.misses, err = meter.Int64Counter("cache.misses",
+		metric.WithDescription("Number of keys not found in the cache")); err != nil {
+		return nil, err
+	}
+	if telemetry.invalidReads, err = meter.Int64Counter("cache.invalid_reads",
+		metric.WithDescription("Number of reads rejected because the cache is invalid")); err != nil {
+		return nil, err
+	}
+	if telemetry.negativeHits, err = meter.Int64Counter("cache.negative_hits",
+		metric.WithDescription("Number of items found in the not found set of the cache")); err != nil {
+		return nil, err
+	}
+	if telemetry.refreshFailures, err = meter.Int64Counter("cache.refresh.failures",
+		metric.WithDescription("Number of refreshes failed after all retry attempts")); err != nil {
+		return nil, err
+	}
+	if telemetry.refreshDuration, err = meter.Float64Histogram("cache.refresh.duration",
+		metric.WithDescription("Duration of refreshes"), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if telemetry.payloadSize, err = meter.Int64Histogram("cache.payload.size",
+		metric.WithDescription("Size of values written to and read from the cache"), metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	return telemetry, nil
+}
+
+// Tracing
+
+func (c *redisCache) startSpan(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, c.telemetry.nameAttribute)
+	return c.telemetry.tracer.Start(c.circuitContext(ctx), "redisCache."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, a missing item is an expected outcome and not recorded as error
+func (c *redisCache) endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Metrics
+
+func (c *redisCache) recordRead(ctx context.Context, hits int, misses int) {
+	if hits > 0 {
+		c.telemetry.hits.Add(ctx, int64(hits), metric.WithAttributes(c.telemetry.nameAttribute))
+	}
+	if misses > 0 {
+		c.telemetry.misses.Add(ctx, int64(misses), metric.WithAttributes(c.telemetry.nameAttribute))
+	}
+}
+func (c *redisCache) recordInvalidRead(ctx context.Context) {
+	c.telemetry.invalidReads.Add(ctx, 1, metric.WithAttributes(c.telemetry.nameAttribute))
+}
+func (c *redisCache) recordNegativeHit(ctx context.Context) {
+	c.telemetry.negativeHits.Add(ctx, 1, metric.WithAttributes(c.telemetry.nameAttribute))
+}
+func (c *redisCache) recordPayloadSize(ctx context.Context, operation string, size int) {
+	c.telemetry.payloadSize.Record(ctx, int64(size), metric.WithAttributes(c.telemetry.nameAttribute, attribute.String("cache.operation", operation)))
+}
+func (c *redisCache) recordRefresh(ctx context.Context, duration time.Duration, err error) {
+	c.telemetry.refreshDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(c.telemetry.nameAttribute, attribute.Bool("cache.refresh.success", err == nil)))
+	if err != nil {
+		c.telemetry.refreshFailures.Add(ctx, 1, metric.WithAttributes(c.telemetry.nameAttribute))
+	}
+}
+
+// initTelemetry replaces the no-op telemetry if enabled in the config, on failure the no-op telemetry is kept
+func (c *redisCache) initTelemetry() {
+	if !c.config.UseOpenTelemetry {
+		return
+	}
+	telemetry, err := newCacheTelemetry(c.name, true)
+	if err != nil {
+		log.Error().Err(err).Msg(c.fmtMsg("open telemetry setup failed"))
+		return
+	}
+	c.telemetry = telemetry
+}
+
+// noopTelemetry can not fail, as the no-op instruments never return errors
+func noopTelemetry(name string) *cacheTelemetry {
+	telemetry, _ := newCacheTelemetry(name, false)
+	return telemetry
+}
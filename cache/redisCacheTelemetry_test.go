@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errTestConnectionRefused = errors.New("connection refused")
+
+// replyingHook answers JSON.GET from values without redis, all other commands fail like with a lost connection
+type replyingHook struct {
+	values map[string]string
+}
+
+func (h replyingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+func (h replyingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if jsonCmd, ok := cmd.(*redis.JSONCmd); ok && cmd.Name() == "json.get" {
+			if value, exists := h.values[cmd.Args()[1].(string)]; exists {
+				jsonCmd.SetVal(value)
+				return nil
+			}
+			cmd.SetErr(redis.Nil)
+			return redis.Nil
+		}
+		cmd.SetErr(errTestConnectionRefused)
+		return errTestConnectionRefused
+	}
+}
+func (h replyingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			cmd.SetErr(errTestConnectionRefused)
+		}
+		return errTestConnectionRefused
+	}
+}
+
+func TestRedisCacheTelemetry(t *testing.T) {
+	ctx := context.Background()
+	spanRecorder := tracetest.NewSpanRecorder()
+	metricReader := sdkmetric.NewManualReader()
+	previousTracerProvider, previousMeterProvider := otel.GetTracerProvider(), otel.GetMeterProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metricReader)))
+	defer func() {
+		otel.SetTracerProvider(previousTracerProvider)
+		otel.SetMeterProvider(previousMeterProvider)
+	}()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer redisClient.Close()
+	cache := NewRedisCache(redisClient, "telemetry")
+	redisClient.AddHook(replyingHook{values: map[string]string{cache.KeyForCustom("hit"): `{"Field1":"value1"}`}})
+	refreshFailed := make(chan struct{})
+	cache.Init(RedisCacheConfig{
+		UseOpenTelemetry:     true,
+		RefreshRetryAttempts: 1,
+		RefreshHooks: RefreshHooks{OnFailure: func(ctx context.Context, event RefreshEvent) {
+			close(refreshFailed)
+		}},
+	}, func(ctx context.Context) error { return nil }, nil)
+	cache.SetToValid(ctx)
+
+	// A hit and a miss
+	var value struct{ Field1 string }
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("hit"), &value))
+	assert.Equal(t, "value1", value.Field1)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("miss"), &value), ErrItemNotFound)
+
+	// A failed refresh
+	cache.RefreshCacheAsync(ctx, false)
+	select {
+	case <-refreshFailed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not fail")
+	}
+
+	var metrics metricdata.ResourceMetrics
+	assert.Nil(t, metricReader.Collect(ctx, &metrics))
+	nameAttribute := attribute.String("cache.name", "telemetry")
+	assertCounter(t, metrics, "cache.hits", 1, nameAttribute)
+	assertCounter(t, metrics, "cache.misses", 1, nameAttribute)
+	assertCounter(t, metrics, "cache.refresh.failures", 1, nameAttribute)
+	assertHistogramCount(t, metrics, "cache.refresh.duration", 1, nameAttribute, attribute.Bool("cache.refresh.success", false))
+	assertHistogramCount(t, metrics, "cache.payload.size", 1, nameAttribute, attribute.String("cache.operation", "Read"))
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range spanRecorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	assert.Len(t, spans["redisCache.Read"], 2)
+	for _, span := range spans["redisCache.Read"] {
+		assert.Contains(t, span.Attributes(), nameAttribute)
+		assert.Equal(t, codes.Unset, span.Status().Code, "a missing item is no error")
+	}
+	if assert.Len(t, spans["redisCache.Refresh"], 1) {
+		refreshSpan := spans["redisCache.Refresh"][0]
+		assert.Equal(t, codes.Error, refreshSpan.Status().Code)
+		assert.Contains(t, refreshSpan.Status().Description, errTestConnectionRefused.Error())
+		assert.Contains(t, refreshSpan.Attributes(), attribute.Bool("cache.refresh.incremental", false))
+	}
+}
+
+// assertCounter asserts the value of the data point of the counter with the attributes
+func assertCounter(t *testing.T, metrics metricdata.ResourceMetrics, name string, expected int64, attributes ...attribute.KeyValue) {
+	t.Helper()
+	sum, ok := findMetric(metrics, name).(metricdata.Sum[int64])
+	if !assert.True(t, ok, name) {
+		return
+	}
+	set := attribute.NewSet(attributes...)
+	for _, dataPoint := range sum.DataPoints {
+		if dataPoint.Attributes.Equals(&set) {
+			assert.Equal(t, expected, dataPoint.Value, name)
+			return
+		}
+	}
+	t.Errorf("%s has no data point with the attributes %v", name, attributes)
+}
+
+// assertHistogramCount asserts the number of recordings of the histogram with the attributes
+func assertHistogramCount(t *testing.T, metrics metricdata.ResourceMetrics, name string, expected uint64, attributes ...attribute.KeyValue) {
+	t.Helper()
+	set := attribute.NewSet(attributes...)
+	switch histogram := findMetric(metrics, name).(type) {
+	case metricdata.Histogram[int64]:
+		for _, dataPoint := range histogram.DataPoints {
+			if dataPoint.Attributes.Equals(&set) {
+				assert.Equal(t, expected, dataPoint.Count, name)
+				return
+			}
+		}
+	case metricdata.Histogram[float64]:
+		for _, dataPoint := range histogram.DataPoints {
+			if dataPoint.Attributes.Equals(&set) {
+				assert.Equal(t, expected, dataPoint.Count, name)
+				return
+			}
+		}
+	}
+	t.Errorf("%s has no data point with the attributes %v", name, attributes)
+}
+
+func findMetric(metrics metricdata.ResourceMetrics, name string) metricdata.Aggregation {
+	for _, scopeMetrics := range metrics.ScopeMetrics {
+		for _, metric := range scopeMetrics.Metrics {
+			if metric.Name == name {
+				return metric.Data
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Tenants share the redis of a cache, but their keys, validity flags, locks and indexes are scoped by a namespace
+// of the cache name and the tenant, e.g. "lab@berlin". Cache names and tenants must not contain the separators of
+// keys and namespaces, so the keys of a namespace never match the key pattern of another one.
+
+const tenantSeparator = "@"
+
+var (
+	ErrInvalidCacheName = errors.New("invalid cache name")
+	ErrInvalidTenant    = errors.New("invalid tenant")
+)
+
+type tenantContextKey struct{}
+
+// WithTenant creates a cache for the tenant on the client of the cache, it has to be initialized with the
+// configuration and refresh functions of the tenant
+func (c *redisCache) WithTenant(tenant string) (RedisCache, error) {
+	if c.tenant != "" {
+		return nil, c.fmtErr(fmt.Errorf("%w: cache is already scoped to tenant %s", ErrInvalidTenant, c.tenant))
+	}
+	if c.nameErr != nil {
+		return nil, c.fmtErr(c.nameErr)
+	}
+	if !isValidNamespacePart(tenant) {
+		return nil, c.fmtErr(fmt.Errorf("%w: %q must consist of letters, digits, '_', '-' and '.'", ErrInvalidTenant, tenant))
+	}
+	tenantCache := newRedisCache(c.redisClient, c.name, tenant)
+	// Note: the tenants share the redis, so they share the circuit breaker too, it is configured by the cache only
+	tenantCache.breaker = c.breaker
+	tenantCache.breakerInherited = c.breaker != nil
+	return tenantCache, nil
+}
+
+// TenantFromContext returns the tenant of the cache calling the refresh functions and hooks, empty for a cache
+// without tenant
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+func newRedisCache(redisClient redis.UniversalClient, name string, tenant string) *redisCache {
+	cache := NewRedisCache(redisClient, name+tenantSeparator+tenant).(*redisCache)
+	cache.tenant = tenant
+	cache.nameErr = nil
+	return cache
+}
+
+// configErr returns the error of a cache without configuration, ErrInvalidCacheName if the cache can't be
+// initialized due to its name
+func (c *redisCache) configErr() error {
+	if c.nameErr != nil {
+		return c.nameErr
+	}
+	return ErrConfigNotSet
+}
+
+// withTenantContext adds the tenant of the cache to the context of the refresh functions and hooks
+func (c *redisCache) withTenantContext(ctx context.Context) context.Context {
+	if c.tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, c.tenant)
+}
+
+// indexName scopes the name of an index or alias to the tenant, names which are already scoped are kept
+func (c *redisCache) indexName(name string) string {
+	if c.tenant == "" || strings.HasPrefix(name, c.name+":") {
+		return name
+	}
+	return fmt.Sprintf("%s:%s", c.name, name)
+}
+
+// relativeIndexName strips the tenant scope from the name of an index or alias
+func (c *redisCache) relativeIndexName(name string) string {
+	if c.tenant == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, c.name+":")
+}
+
+// validateCacheName returns ErrInvalidCacheName unless the name consists of letters, digits, '_', '-' and '.'
+func validateCacheName(name string) error {
+	if !isValidNamespacePart(name) {
+		return fmt.Errorf("%w: %q must consist of letters, digits, '_', '-' and '.'", ErrInvalidCacheName, name)
+	}
+	return nil
+}
+
+func isValidNamespacePart(part string) bool {
+	if part == "" {
+		return false
+	}
+	for _, r := range part {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' && r != '-' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"fmt"
+)
+
+// Tenants of the fake share its mutex, entries, counters, indexes and circuit breaker, like tenants of the real cache
+// share the redis, the circuit breaker is only configured by the Init of the fake. Job queues, recorded calls,
+// validity and refresh state are kept per tenant, the clock and availability are taken from the fake when the
+// tenant is created.
+
+func (f *fakeRedisCache) WithTenant(tenant string) (RedisCache, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("WithTenant")
+	if f.helper.tenant != "" {
+		return nil, f.helper.fmtErr(fmt.Errorf("%w: cache is already scoped to tenant %s", ErrInvalidTenant, f.helper.tenant))
+	}
+	if !isValidNamespacePart(tenant) {
+		return nil, f.helper.fmtErr(fmt.Errorf("%w: %q must consist of letters, digits, '_', '-' and '.'", ErrInvalidTenant, tenant))
+	}
+	helper := newRedisCache(nil, f.helper.name, tenant)
+	helper.instanceId = f.helper.instanceId
+	return &fakeRedisCache{
+		helper:             helper,
+		mutex:              f.mutex,
+		now:                f.now,
+		synchronousRefresh: f.synchronousRefresh,
+		calls:              make([]FakeCacheCall, 0),
+		entries:            f.entries,
+		indexes:            f.indexes,
+		aliases:            f.aliases,
+		jobs:               make(map[string]map[string]*fakeJob),
+		counters:           f.counters,
+		breaker:            f.breaker,
+		unavailable:        f.unavailable,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTenant(t *testing.T) {
+	singleNodeClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	cache := NewRedisCache(singleNodeClient, "test")
+	tenantCache, err := cache.WithTenant("berlin")
+	assert.Nil(t, err)
+	assert.Equal(t, "test@berlin:ALL", tenantCache.KeyForAll())
+	assert.Equal(t, "test@berlin:TAG:plasma", tenantCache.KeyForTag("plasma"))
+	_, err = cache.DecodeKey(tenantCache.KeyForAll())
+	assert.ErrorIs(t, err, ErrForeignKey)
+	assert.Equal(t, "test@berlin:instruments", tenantCache.(*redisCache).indexName("instruments"))
+	assert.Equal(t, "test@berlin:instruments", tenantCache.(*redisCache).indexName("test@berlin:instruments"))
+	assert.Equal(t, "instruments", cache.(*redisCache).indexName("instruments"))
+
+	clusterClient := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	tenantCache, err = NewRedisCache(clusterClient, "test").WithTenant("berlin")
+	assert.Nil(t, err)
+	assert.Equal(t, "{test@berlin}:ALL", tenantCache.KeyForAll())
+
+	_, err = tenantCache.WithTenant("munich")
+	assert.ErrorIs(t, err, ErrInvalidTenant)
+	for _, tenant := range []string{"", "ber:lin", "ber@lin", "{berlin}", "berlin*", "ber lin"} {
+		_, err = cache.WithTenant(tenant)
+		assert.ErrorIs(t, err, ErrInvalidTenant, tenant)
+	}
+}
+
+func TestInvalidCacheName(t *testing.T) {
+	for _, name := range []string{"", "test:2", "test@berlin", "{test}", "test*", "test?"} {
+		cache := NewRedisCache(nil, name)
+		cache.Init(RedisCacheConfig{}, nil, nil)
+		assert.ErrorIs(t, cache.Delete(context.Background(), cache.KeyForAll()), ErrInvalidCacheName, name)
+		_, err := cache.GetFlag(context.Background(), cache.KeyForCustom("flag"))
+		assert.ErrorIs(t, err, ErrInvalidCacheName, name)
+		_, err = cache.WithTenant("berlin")
+		assert.ErrorIs(t, err, ErrInvalidCacheName, name)
+	}
+	cache := NewRedisCache(nil, "lab-1.test_2")
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	assert.ErrorIs(t, cache.Delete(context.Background(), cache.KeyForAll()), ErrNoClientSet)
+}
@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/db"
+	"github.com/rs/zerolog/log"
+)
+
+type WriteMode int
+
+const (
+	// WriteThrough deletes the changed keys of a transaction synchronously in its Commit, so readers load the committed
+	// values from the database. Writing the upserts could overwrite the value of a concurrent transaction, which
+	// committed later but reached redis first.
+	WriteThrough WriteMode = iota
+	// WriteBehind queues the changes of committed transactions and applies them coalesced in the background
+	WriteBehind
+)
+
+const (
+	defaultWriteBehindFlushInterval = time.Second
+	defaultWriteBehindMaxPending    = 1000
+)
+
+var ErrNotCacheTransaction = errors.New("connection is not a transaction started by the cache writer")
+
+// CacheChanges are applied to the cache after the transaction committed, a delete wins over an upsert of the same key
+type CacheChanges struct {
+	Upserts map[string]interface{}
+	Deletes []string
+}
+
+// SaveFunc writes to the database within the transaction and returns the resulting cache changes
+type SaveFunc func(ctx context.Context, tx db.DbConnection) (CacheChanges, error)
+
+type CacheWriterConfig struct {
+	Mode WriteMode
+	// Expiration of the upserts, the DefaultExpiration of the cache is used if not set
+	Expiration *time.Duration
+	// FlushInterval of the write-behind queue and of retrying failed write-through deletes, defaults to 1s
+	FlushInterval time.Duration
+	// MaxPending keys in the write-behind queue, reaching it triggers a flush, defaults to 1000
+	MaxPending int
+}
+
+// CacheWriter keeps the cache consistent with the database, cache changes of a transaction become visible
+// only after its Commit succeeded and are discarded on Rollback
+type CacheWriter interface {
+	BeginTx(ctx context.Context, conn db.DbConnection) (db.DbConnection, error)
+	AddChanges(tx db.DbConnection, changes CacheChanges) error
+	Save(ctx context.Context, conn db.DbConnection, save SaveFunc) error
+	Flush(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// cacheWrite is the coalesced change of one key
+type cacheWrite struct {
+	content interface{}
+	delete  bool
+}
+
+type cacheWriter struct {
+	cache       RedisCache
+	config      CacheWriterConfig
+	mutex       *sync.Mutex
+	flushMutex  *sync.Mutex
+	queue       map[string]cacheWrite
+	flushSignal chan struct{}
+	stop        chan struct{}
+	stopped     chan struct{}
+	closed      bool
+}
+
+// NewCacheWriter creates a writer for the cache, it starts flushing the queue in the background until Close is
+// called. In WriteThrough mode the queue only holds the deletes which failed in Commit.
+func NewCacheWriter(cache RedisCache, config CacheWriterConfig) CacheWriter {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultWriteBehindFlushInterval
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = defaultWriteBehindMaxPending
+	}
+	w := &cacheWriter{
+		cache:       cache,
+		config:      config,
+		mutex:       &sync.Mutex{},
+		flushMutex:  &sync.Mutex{},
+		queue:       make(map[string]cacheWrite),
+		flushSignal: make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// BeginTx starts a transaction on conn, changes added to it with AddChanges are applied after its Commit
+func (w *cacheWriter) BeginTx(ctx context.Context, conn db.DbConnection) (db.DbConnection, error) {
+	tx, err := conn.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTxConnection{
+		DbConnection: tx,
+		writer:       w,
+		ctx:          ctx,
+		mutex:        &sync.Mutex{},
+		writes:       make(map[string]cacheWrite),
+	}, nil
+}
+
+// AddChanges adds changes to a transaction started by BeginTx, later changes of a key replace earlier ones.
+// Note: the contents are encoded when they are applied, so they must not be modified afterward
+func (w *cacheWriter) AddChanges(tx db.DbConnection, changes CacheChanges) error {
+	cacheTx, ok := tx.(*cacheTxConnection)
+	if !ok || cacheTx.writer != w {
+		return ErrNotCacheTransaction
+	}
+	cacheTx.mutex.Lock()
+	defer cacheTx.mutex.Unlock()
+	mergeCacheChanges(cacheTx.writes, changes)
+	return nil
+}
+
+// Save runs save in a new transaction and commits it, or rolls it back if save fails
+func (w *cacheWriter) Save(ctx context.Context, conn db.DbConnection, save SaveFunc) error {
+	tx, err := w.BeginTx(ctx, conn)
+	if err != nil {
+		return err
+	}
+	changes, err := save(ctx, tx)
+	if err == nil {
+		err = w.AddChanges(tx, changes)
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Warn().Ctx(ctx).Err(rollbackErr).Msg("cacheWriter: rollback after failed save failed")
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Flush applies the queued write-behind changes and deletes
+func (w *cacheWriter) Flush(ctx context.Context) error {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+	w.mutex.Lock()
+	writes := w.queue
+	w.queue = make(map[string]cacheWrite)
+	w.mutex.Unlock()
+	if len(writes) == 0 {
+		return nil
+	}
+	err := w.write(ctx, writes)
+	if err != nil {
+		w.requeueAsDeletes(writes)
+		return err
+	}
+	log.Debug().Ctx(ctx).Int("keys", len(writes)).Msg("cacheWriter: write-behind queue flushed")
+	return nil
+}
+
+// Close stops the background flushing and flushes the queue, the keys of changes committed afterward are deleted
+// synchronously like in WriteThrough mode
+func (w *cacheWriter) Close(ctx context.Context) error {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.mutex.Unlock()
+	select {
+	case <-w.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.Flush(ctx)
+}
+
+func (w *cacheWriter) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.flushSignal:
+		}
+		if err := w.Flush(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("cacheWriter: flushing write-behind queue failed")
+		}
+	}
+}
+
+// apply is called after the commit of a transaction with its changes, they are queued in WriteBehind mode, otherwise
+// their keys are deleted
+func (w *cacheWriter) apply(ctx context.Context, writes map[string]cacheWrite) {
+	if len(writes) == 0 {
+		return
+	}
+	w.mutex.Lock()
+	if w.config.Mode == WriteBehind && !w.closed {
+		for key, write := range writes {
+			w.queue[key] = write
+		}
+		if len(w.queue) >= w.config.MaxPending {
+			select {
+			case w.flushSignal <- struct{}{}:
+			default:
+			}
+		}
+		w.mutex.Unlock()
+		return
+	}
+	w.mutex.Unlock()
+	deletes := make(map[string]cacheWrite, len(writes))
+	for key := range writes {
+		deletes[key] = cacheWrite{delete: true}
+	}
+	if err := w.write(ctx, deletes); err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg("cacheWriter: deleting committed changes failed, queued for the next flush")
+		w.requeueAsDeletes(deletes)
+	}
+}
+
+// requeueAsDeletes queues the keys of failed writes as deletes, unless they were changed meanwhile, so readers fall
+// back to the database instead of reading stale values
+func (w *cacheWriter) requeueAsDeletes(writes map[string]cacheWrite) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for key := range writes {
+		if _, changed := w.queue[key]; !changed {
+			w.queue[key] = cacheWrite{delete: true}
+		}
+	}
+}
+
+// write applies the changes, if that fails the keys are deleted so the cache does not keep stale values
+func (w *cacheWriter) write(ctx context.Context, writes map[string]cacheWrite) error {
+	upserts := make(map[string]interface{})
+	keys := make([]string, 0, len(writes))
+	deletes := make([]string, 0)
+	for key, write := range writes {
+		keys = append(keys, key)
+		if write.delete {
+			deletes = append(deletes, key)
+		} else {
+			upserts[key] = write.content
+		}
+	}
+	var err error
+	if len(upserts) > 0 {
+		err = w.cache.StoreGroup(ctx, upserts, w.config.Expiration)
+	}
+	if len(deletes) > 0 {
+		err = errors.Join(err, w.cache.DeleteGroup(ctx, deletes))
+	}
+	if err == nil || errors.Is(err, ErrCachingDisabled) {
+		return nil
+	}
+	log.Warn().Ctx(ctx).Err(err).Int("keys", len(keys)).Msg("cacheWriter: writing changes failed, deleting the keys")
+	if deleteErr := w.cache.DeleteGroup(ctx, keys); deleteErr != nil {
+		return errors.Join(err, deleteErr)
+	}
+	return nil
+}
+
+func mergeCacheChanges(writes map[string]cacheWrite, changes CacheChanges) {
+	for key, content := range changes.Upserts {
+		writes[key] = cacheWrite{content: content}
+	}
+	for _, key := range changes.Deletes {
+		writes[key] = cacheWrite{delete: true}
+	}
+}
+
+// cacheTxConnection hooks into Commit and Rollback of a transaction to apply or discard its cache changes
+type cacheTxConnection struct {
+	db.DbConnection
+	writer *cacheWriter
+	ctx    context.Context
+	mutex  *sync.Mutex
+	writes map[string]cacheWrite
+}
+
+// BeginTx starts a new transaction, like the wrapped connection does
+func (t *cacheTxConnection) BeginTx(ctx context.Context) (db.DbConnection, error) {
+	return t.writer.BeginTx(ctx, t.DbConnection)
+}
+
+// Commit commits the transaction and applies its cache changes only if the commit succeeded
+func (t *cacheTxConnection) Commit() error {
+	err := t.DbConnection.Commit()
+	writes := t.takeWrites()
+	if err != nil {
+		return err
+	}
+	t.writer.apply(t.ctx, writes)
+	return nil
+}
+
+// Rollback discards the cache changes and rolls the transaction back
+func (t *cacheTxConnection) Rollback() error {
+	t.takeWrites()
+	return t.DbConnection.Rollback()
+}
+
+func (t *cacheTxConnection) takeWrites() map[string]cacheWrite {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	writes := t.writes
+	t.writes = make(map[string]cacheWrite)
+	return writes
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingCommitDbConnection struct {
+	db.DbConnection
+}
+
+func (c failingCommitDbConnection) BeginTx(ctx context.Context) (db.DbConnection, error) {
+	return c, nil
+}
+func (c failingCommitDbConnection) Commit() error {
+	return db.ErrCommitTransactionFailed
+}
+
+func TestCacheWriterWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+	writer := NewCacheWriter(cache, CacheWriterConfig{Mode: WriteThrough})
+	conn := db.NewFakeDbConnection()
+
+	// The changed keys are deleted after the commit
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("a"), 1))
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("deleted"), 1))
+	tx, err := writer.BeginTx(ctx, conn)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.AddChanges(tx, CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 2}}))
+	assert.Nil(t, writer.AddChanges(tx, CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 3}, Deletes: []string{cache.KeyForCustom("deleted")}}))
+	var value int
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("a"), &value))
+	assert.Equal(t, 1, value)
+	assert.Nil(t, tx.Commit())
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("a"), &value), ErrItemNotFound)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("deleted"), &value), ErrItemNotFound)
+
+	// Rolled back changes are discarded
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("b"), 1))
+	tx, err = writer.BeginTx(ctx, conn)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.AddChanges(tx, CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("b"): 2}}))
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("b"), &value))
+	assert.Equal(t, 1, value)
+	assert.ErrorIs(t, writer.AddChanges(conn, CacheChanges{}), ErrNotCacheTransaction)
+
+	// Save commits, or rolls back on errors
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("c"), 1))
+	saveErr := errors.New("save failed")
+	assert.ErrorIs(t, writer.Save(ctx, conn, func(ctx context.Context, tx db.DbConnection) (CacheChanges, error) {
+		return CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("c"): 2}}, saveErr
+	}), saveErr)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("c"), &value))
+	assert.Equal(t, 1, value)
+	assert.Nil(t, writer.Save(ctx, conn, func(ctx context.Context, tx db.DbConnection) (CacheChanges, error) {
+		return CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("c"): 3}}, nil
+	}))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("c"), &value), ErrItemNotFound)
+
+	// Failed commits discard the changes
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("d"), 1))
+	assert.ErrorIs(t, writer.Save(ctx, failingCommitDbConnection{DbConnection: conn}, func(ctx context.Context, tx db.DbConnection) (CacheChanges, error) {
+		return CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("d"): 2}}, nil
+	}), db.ErrCommitTransactionFailed)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("d"), &value))
+	assert.Equal(t, 1, value)
+
+	// Failed deletes don't fail the committed transaction, they are queued until the next flush
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("c"), 3))
+	cache.SetUnavailable(true)
+	assert.Nil(t, writer.Save(ctx, conn, func(ctx context.Context, tx db.DbConnection) (CacheChanges, error) {
+		return CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("c"): 4}}, nil
+	}))
+	cache.SetUnavailable(false)
+	assert.Nil(t, writer.Flush(ctx))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("c"), &value), ErrItemNotFound)
+	assert.Nil(t, writer.Close(ctx))
+}
+
+func TestCacheWriterWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+	writer := NewCacheWriter(cache, CacheWriterConfig{Mode: WriteBehind, FlushInterval: time.Hour, MaxPending: 3})
+	conn := db.NewFakeDbConnection()
+	save := func(changes CacheChanges) {
+		assert.Nil(t, writer.Save(ctx, conn, func(ctx context.Context, tx db.DbConnection) (CacheChanges, error) {
+			return changes, nil
+		}))
+	}
+
+	// Changes are coalesced until flushed
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("deleted"), "1"))
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 1}})
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 2}, Deletes: []string{cache.KeyForCustom("deleted")}})
+	var value int
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("a"), &value), ErrItemNotFound)
+	cache.ResetCalls()
+	assert.Nil(t, writer.Flush(ctx))
+	assert.Equal(t, 1, cache.CallCount("StoreGroup"))
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("a"), &value))
+	assert.Equal(t, 2, value)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("deleted"), &value), ErrItemNotFound)
+
+	// Failed flushes queue deletes of the keys
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 3}})
+	cache.SetUnavailable(true)
+	assert.ErrorIs(t, writer.Flush(ctx), ErrFakeConnectionFailed)
+	cache.SetUnavailable(false)
+	assert.Nil(t, writer.Flush(ctx))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("a"), &value), ErrItemNotFound)
+
+	// Reaching MaxPending flushes in the background
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("a"): 1, cache.KeyForCustom("b"): 2, cache.KeyForCustom("c"): 3}})
+	assert.Eventually(t, func() bool {
+		return cache.Read(ctx, cache.KeyForCustom("c"), &value) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Close flushes, afterward the keys of changes are deleted synchronously
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("d"): 4}})
+	assert.Nil(t, writer.Close(ctx))
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("d"), &value))
+	assert.Equal(t, 4, value)
+	save(CacheChanges{Upserts: map[string]interface{}{cache.KeyForCustom("d"): 5}})
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("d"), &value), ErrItemNotFound)
+}
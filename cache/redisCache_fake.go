@@ -0,0 +1,980 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// FakeRedisCache is an in-memory RedisCache for unit tests, with additional functions to control and inspect it
+type FakeRedisCache interface {
+	RedisCache
+	// SetClock replaces the clock used for expirations
+	SetClock(now func() time.Time)
+	// SetSynchronousRefresh makes RefreshCacheAsync return only after the refresh is finished
+	SetSynchronousRefresh(synchronous bool)
+	// SetUnavailable makes all operations fail like with a lost connection, the failures are counted by the circuit breaker
+	SetUnavailable(unavailable bool)
+	Calls() []FakeCacheCall
+	CallCount(method string) int
+	ResetCalls()
+}
+
+type FakeCacheCall struct {
+	Method string
+	Keys   []string
+}
+
+var (
+	ErrFakeWrongType           = errors.New("operation against a key holding the wrong kind of value")
+	ErrFakeIndexExists         = errors.New("index already exists")
+	ErrFakeQueryNotSupported   = errors.New("query syntax not supported by fake cache")
+	ErrFakeUnknownSearchField  = errors.New("unknown field in search query")
+	ErrFakeInvalidJSONDocument = errors.New("content is not a valid JSON document")
+	ErrFakeConnectionFailed    = errors.New("connection to redis failed")
+)
+
+type fakeEntryKind int
+
+const (
+	fakeEntryValue fakeEntryKind = iota
+	fakeEntrySet
+	fakeEntryFlag
+)
+
+type fakeEntry struct {
+	kind      fakeEntryKind
+	value     []byte
+	members   map[string]struct{}
+	expiresAt *time.Time
+}
+
+type fakeIndex struct {
+	onJSON   bool
+	prefixes []string
+	fields   []*redis.FieldSchema
+}
+
+type fakeRedisCache struct {
+	// helper is used for key generation and refresh hooks, it never connects to redis
+	helper               *redisCache
+	mutex                *sync.Mutex
+	now                  func() time.Time
+	synchronousRefresh   bool
+	calls                []FakeCacheCall
+	entries              map[string]*fakeEntry
+	indexes              map[string]*fakeIndex
+	aliases              map[string]string
+	jobs                 map[string]map[string]*fakeJob
+	counters             map[string]*fakeCounter
+	breaker              *circuitBreaker
+	unavailable          bool
+	config               *RedisCacheConfig
+	cacheValid           bool
+	forceUpdateRequested bool
+	refreshStatus        RefreshStatus
+	refreshFillerFunc    func(ctx context.Context) error
+	refreshInitFunc      func(ctx context.Context) error
+}
+
+func NewFakeRedisCache() FakeRedisCache {
+	name := "fake"
+	fake := &fakeRedisCache{
+		helper: &redisCache{
+			name:       name,
+			keyPrefix:  name,
+			instanceId: uuid.NewString(),
+		},
+		mutex:              &sync.Mutex{},
+		now:                time.Now,
+		synchronousRefresh: false,
+		calls:              make([]FakeCacheCall, 0),
+		entries:            make(map[string]*fakeEntry),
+		indexes:            make(map[string]*fakeIndex),
+		aliases:            make(map[string]string),
+		jobs:               make(map[string]map[string]*fakeJob),
+		counters:           make(map[string]*fakeCounter),
+	}
+	// Note: the breaker is only used with the mutex held, so it can read the clock of the fake
+	fake.breaker = newCircuitBreaker(name, func() time.Time { return fake.now() })
+	return fake
+}
+
+// Fake control and inspection
+
+func (f *fakeRedisCache) SetClock(now func() time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now
+}
+func (f *fakeRedisCache) SetSynchronousRefresh(synchronous bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.synchronousRefresh = synchronous
+}
+func (f *fakeRedisCache) SetUnavailable(unavailable bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unavailable = unavailable
+}
+func (f *fakeRedisCache) Calls() []FakeCacheCall {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	calls := make([]FakeCacheCall, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+func (f *fakeRedisCache) CallCount(method string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	count := 0
+	for i := range f.calls {
+		if f.calls[i].Method == method {
+			count++
+		}
+	}
+	return count
+}
+func (f *fakeRedisCache) ResetCalls() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = make([]FakeCacheCall, 0)
+}
+
+// Initialization
+
+func (f *fakeRedisCache) Init(config RedisCacheConfig, refreshFillerFunc func(ctx context.Context) error, refreshInitFunc func(ctx context.Context) error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Init")
+	f.config = &config
+	f.helper.config = &config
+	f.refreshFillerFunc = refreshFillerFunc
+	f.refreshInitFunc = refreshInitFunc
+	if f.helper.tenant == "" {
+		f.breaker.configure(config.CircuitBreaker)
+	}
+}
+
+// Refreshing and validity
+
+func (f *fakeRedisCache) IsValid(ctx context.Context) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("IsValid")
+	return f.isValid()
+}
+func (f *fakeRedisCache) SetToInvalid(ctx context.Context) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SetToInvalid")
+	if f.check() == nil {
+		f.cacheValid = false
+	}
+}
+func (f *fakeRedisCache) SetToValid(ctx context.Context) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SetToValid")
+	if f.check() == nil {
+		f.cacheValid = true
+	}
+}
+func (f *fakeRedisCache) RefreshCacheAsync(ctx context.Context, forceUpdate bool) {
+	f.mutex.Lock()
+	f.record("RefreshCacheAsync")
+	if f.check() != nil {
+		f.mutex.Unlock()
+		return
+	}
+	if f.refreshStatus.RefreshRunning {
+		if forceUpdate {
+			f.forceUpdateRequested = true
+		}
+		f.mutex.Unlock()
+		return
+	}
+	f.refreshStatus.RefreshRunning = true
+	mode := refreshFull
+	if !forceUpdate && f.config.IncrementalRefreshFunc != nil && f.cacheValid && f.entry(f.helper.keyForSystem(refreshCheckpointKey)) != nil {
+		mode = refreshIncremental
+	} else {
+		f.cacheValid = false
+	}
+	synchronous := f.synchronousRefresh
+	f.mutex.Unlock()
+	if synchronous {
+		f.runRefresh(ctx, mode)
+	} else {
+		go f.runRefresh(ctx, mode)
+	}
+}
+
+// runRefresh mirrors the refresh of the real cache, but retries without waiting
+func (f *fakeRedisCache) runRefresh(ctx context.Context, mode refreshMode) {
+	ctx = f.helper.withTenantContext(ctx)
+	f.mutex.Lock()
+	startedAt := f.now()
+	f.refreshStatus.RefreshAttempt = 0
+	f.refreshStatus.LastStartedAt = &startedAt
+	attempts := f.config.RefreshRetryAttempts
+	hooks := f.config.RefreshHooks
+	f.mutex.Unlock()
+	incremental := mode == refreshIncremental
+	f.helper.callRefreshHook(ctx, hooks.OnStart, RefreshEvent{StartedAt: startedAt, Incremental: incremental})
+	var err error
+	attempt := 0
+	for attempt < attempts {
+		attempt++
+		f.mutex.Lock()
+		f.refreshStatus.RefreshAttempt = attempt
+		if !incremental {
+			f.clear()
+		}
+		f.mutex.Unlock()
+		if incremental {
+			err = f.refreshIncrementally(ctx, startedAt)
+		} else {
+			err = f.refreshOnce(ctx, startedAt)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("after %d attempts, last error: %w", attempt, err)
+	} else if attempt == 0 {
+		err = fmt.Errorf("after %d attempts, no refresh executed", attempts)
+	}
+	f.mutex.Lock()
+	duration := f.now().Sub(startedAt)
+	f.refreshStatus.RefreshRunning = false
+	f.refreshStatus.LastDuration = duration
+	f.refreshStatus.LastError = err
+	if err == nil {
+		f.cacheValid = true
+		finishedAt := startedAt.Add(duration)
+		f.refreshStatus.LastSucceededAt = &finishedAt
+	}
+	forceUpdateRequested := f.forceUpdateRequested
+	f.forceUpdateRequested = false
+	f.mutex.Unlock()
+	event := RefreshEvent{StartedAt: startedAt, Attempt: attempt, Duration: duration, Err: err, Incremental: incremental}
+	if err != nil {
+		f.helper.callRefreshHook(ctx, hooks.OnFailure, event)
+	} else {
+		f.helper.callRefreshHook(ctx, hooks.OnSuccess, event)
+	}
+	if forceUpdateRequested {
+		f.RefreshCacheAsync(ctx, true)
+	}
+}
+func (f *fakeRedisCache) refreshOnce(ctx context.Context, startedAt time.Time) error {
+	if f.refreshInitFunc != nil {
+		if err := f.refreshInitFunc(ctx); err != nil {
+			return err
+		}
+	}
+	if f.refreshFillerFunc != nil {
+		if err := f.refreshFillerFunc(ctx); err != nil {
+			return err
+		}
+	}
+	if f.config.IncrementalRefreshFunc != nil {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		return f.storeValue(f.helper.keyForSystem(refreshCheckpointKey), RefreshCheckpoint{RefreshedAt: startedAt}, nil)
+	}
+	return nil
+}
+func (f *fakeRedisCache) refreshIncrementally(ctx context.Context, startedAt time.Time) error {
+	f.mutex.Lock()
+	var checkpoint RefreshCheckpoint
+	err := f.readValue(f.helper.keyForSystem(refreshCheckpointKey), &checkpoint, nil)
+	refreshFunc := f.config.IncrementalRefreshFunc
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	changes, err := refreshFunc(ctx, checkpoint)
+	if err != nil {
+		return err
+	}
+	if len(changes.Upserts) > 0 {
+		if err = f.StoreGroup(ctx, changes.Upserts, nil); err != nil {
+			return err
+		}
+	}
+	if len(changes.Deletes) > 0 {
+		if err = f.DeleteGroup(ctx, changes.Deletes); err != nil {
+			return err
+		}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.storeValue(f.helper.keyForSystem(refreshCheckpointKey), RefreshCheckpoint{Token: changes.Token, RefreshedAt: startedAt}, nil)
+}
+
+// Scheduling
+
+// Start only marks the scheduler as running and refreshes if the cache is invalid, intervals are not simulated
+func (f *fakeRedisCache) Start(ctx context.Context) error {
+	f.mutex.Lock()
+	f.record("Start")
+	if err := f.check(); err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+	if f.config.RefreshInterval == nil || *f.config.RefreshInterval <= 0 {
+		f.mutex.Unlock()
+		return ErrRefreshIntervalNotSet
+	}
+	if f.refreshStatus.SchedulerRunning {
+		f.mutex.Unlock()
+		return ErrSchedulerRunning
+	}
+	f.refreshStatus.SchedulerRunning = true
+	valid := f.cacheValid
+	f.mutex.Unlock()
+	if !valid {
+		f.RefreshCacheAsync(ctx, false)
+	}
+	return nil
+}
+func (f *fakeRedisCache) Stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Stop")
+	f.refreshStatus.SchedulerRunning = false
+}
+func (f *fakeRedisCache) RefreshStatus() RefreshStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("RefreshStatus")
+	return f.refreshStatus
+}
+func (f *fakeRedisCache) CircuitState() CircuitState {
+	return f.breaker.State()
+}
+func (f *fakeRedisCache) Status(ctx context.Context) (CacheStatus, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Status")
+	status := CacheStatus{
+		RefreshStatus: f.refreshStatus,
+		Name:          f.helper.name,
+		InstanceId:    f.helper.instanceId,
+		CircuitState:  f.breaker.State(),
+	}
+	if err := f.check(); err != nil {
+		return status, err
+	}
+	status.Valid = f.isValid()
+	if status.RefreshRunning {
+		status.RefreshInstanceId = f.helper.instanceId
+	}
+	for key := range f.entries {
+		if f.entry(key) != nil && f.helper.isContentKey(key) {
+			status.KeyCount++
+		}
+	}
+	return status, nil
+}
+
+// CRUD
+
+func (f *fakeRedisCache) Store(ctx context.Context, key string, content interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Store", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.storeValue(key, content, nil)
+}
+func (f *fakeRedisCache) StoreWithExpiration(ctx context.Context, key string, content interface{}, expirationTime *time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("StoreWithExpiration", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	expiration, err := f.expiration(expirationTime)
+	if err != nil {
+		return err
+	}
+	return f.storeValue(key, content, expiration)
+}
+func (f *fakeRedisCache) Read(ctx context.Context, key string, modelPtr interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Read", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.readValue(key, modelPtr, nil)
+}
+func (f *fakeRedisCache) ReadWithExpiration(ctx context.Context, key string, modelPtr interface{}, expirationTime *time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ReadWithExpiration", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	expiration, err := f.expiration(expirationTime)
+	if err != nil {
+		return err
+	}
+	return f.readValue(key, modelPtr, expiration)
+}
+func (f *fakeRedisCache) ReadGroup(ctx context.Context, keys []string, modelArrayPtr interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ReadGroup", keys...)
+	if err := f.check(); err != nil {
+		return err
+	}
+	if !f.cacheValid {
+		return ErrCacheInvalid
+	}
+	v := reflect.ValueOf(modelArrayPtr)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("modelArrayPtr must be a pointer")
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("modelArrayPtr must be a slice")
+	}
+	elemType := v.Type().Elem()
+	for _, key := range keys {
+		entry := f.entry(key)
+		if entry == nil || entry.kind != fakeEntryValue {
+			continue
+		}
+		newElem := reflect.New(elemType)
+		if err := json.Unmarshal(entry.value, newElem.Interface()); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, newElem.Elem()))
+	}
+	return nil
+}
+func (f *fakeRedisCache) ReadGroupAsMap(ctx context.Context, keys []string, modelMapPtr interface{}) (missingKeys []string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("ReadGroupAsMap", keys...)
+	if err = f.check(); err != nil {
+		return nil, err
+	}
+	if !f.cacheValid {
+		return nil, ErrCacheInvalid
+	}
+	v, err := modelMapValue(modelMapPtr)
+	if err != nil {
+		return nil, err
+	}
+	missingKeys = make([]string, 0)
+	elemType := v.Type().Elem()
+	for _, key := range keys {
+		entry := f.entry(key)
+		if entry == nil || entry.kind != fakeEntryValue {
+			missingKeys = append(missingKeys, key)
+			continue
+		}
+		newElem := reflect.New(elemType)
+		if err = json.Unmarshal(entry.value, newElem.Interface()); err != nil {
+			return nil, err
+		}
+		v.SetMapIndex(reflect.ValueOf(key), newElem.Elem())
+	}
+	return missingKeys, nil
+}
+func (f *fakeRedisCache) Delete(ctx context.Context, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("Delete", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	delete(f.entries, key)
+	return nil
+}
+
+func (f *fakeRedisCache) StoreGroup(ctx context.Context, contents map[string]interface{}, expirationTime *time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	keys := sortedKeys(contents)
+	f.record("StoreGroup", keys...)
+	if err := f.check(); err != nil {
+		return err
+	}
+	expiration := f.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	failures := make(map[string]error)
+	for _, key := range keys {
+		if err := f.storeValue(key, contents[key], expiration); err != nil {
+			failures[key] = err
+		}
+	}
+	if len(failures) > 0 {
+		return &GroupError{Failures: failures}
+	}
+	return nil
+}
+func (f *fakeRedisCache) DeleteGroup(ctx context.Context, keys []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("DeleteGroup", keys...)
+	if err := f.check(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(f.entries, key)
+	}
+	return nil
+}
+
+// Set handling
+
+func (f *fakeRedisCache) AddItemToSet(ctx context.Context, key string, item string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("AddItemToSet", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	entry := f.entry(key)
+	if entry == nil {
+		entry = &fakeEntry{kind: fakeEntrySet, members: make(map[string]struct{})}
+		f.entries[key] = entry
+	}
+	if entry.kind != fakeEntrySet {
+		return ErrFakeWrongType
+	}
+	entry.members[item] = struct{}{}
+	return nil
+}
+func (f *fakeRedisCache) IsItemInSet(ctx context.Context, key string, item string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("IsItemInSet", key)
+	if err := f.check(); err != nil {
+		return false, err
+	}
+	entry := f.entry(key)
+	if entry == nil {
+		return false, nil
+	}
+	if entry.kind != fakeEntrySet {
+		return false, ErrFakeWrongType
+	}
+	_, isMember := entry.members[item]
+	return isMember, nil
+}
+func (f *fakeRedisCache) GetItemsInSetAsMap(ctx context.Context, key string) (map[string]struct{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("GetItemsInSetAsMap", key)
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	items := make(map[string]struct{})
+	entry := f.entry(key)
+	if entry == nil {
+		return items, nil
+	}
+	if entry.kind != fakeEntrySet {
+		return nil, ErrFakeWrongType
+	}
+	for item := range entry.members {
+		items[item] = struct{}{}
+	}
+	return items, nil
+}
+func (f *fakeRedisCache) DeleteItemFromSet(ctx context.Context, key string, item string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("DeleteItemFromSet", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	entry := f.entry(key)
+	if entry == nil {
+		return nil
+	}
+	if entry.kind != fakeEntrySet {
+		return ErrFakeWrongType
+	}
+	delete(entry.members, item)
+	if len(entry.members) == 0 {
+		delete(f.entries, key)
+	}
+	return nil
+}
+
+// Flag handling
+
+func (f *fakeRedisCache) SetFlag(ctx context.Context, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SetFlag", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	f.entries[key] = &fakeEntry{kind: fakeEntryFlag}
+	return nil
+}
+func (f *fakeRedisCache) SetFlagWithExpiration(ctx context.Context, key string, expirationTime *time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SetFlagWithExpiration", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	expiration, err := f.expiration(expirationTime)
+	if err != nil {
+		return err
+	}
+	expiresAt := f.now().Add(*expiration)
+	f.entries[key] = &fakeEntry{kind: fakeEntryFlag, expiresAt: &expiresAt}
+	return nil
+}
+func (f *fakeRedisCache) GetFlag(ctx context.Context, key string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("GetFlag", key)
+	if err := f.check(); err != nil {
+		return false, err
+	}
+	return f.entry(key) != nil, nil
+}
+func (f *fakeRedisCache) DeleteFlag(ctx context.Context, key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("DeleteFlag", key)
+	if err := f.check(); err != nil {
+		return err
+	}
+	delete(f.entries, key)
+	return nil
+}
+
+// Index handling
+
+func (f *fakeRedisCache) CreateIndex(ctx context.Context, index string, options *redis.FTCreateOptions, fieldSchemas []*redis.FieldSchema) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("CreateIndex", index)
+	if err := f.check(); err != nil {
+		return "", err
+	}
+	if err := f.createIndex(index, options, fieldSchemas); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+func (f *fakeRedisCache) SearchInIndex(ctx context.Context, indexName string, queryString string, options *redis.FTSearchOptions, modelArrayPtr interface{}) (totalCount int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SearchInIndex", indexName)
+	if err = f.check(); err != nil {
+		return 0, err
+	}
+	if err = f.helper.checkSearchable(); err != nil {
+		return 0, err
+	}
+	return f.search(indexName, queryString, options, modelArrayPtr)
+}
+func (f *fakeRedisCache) SearchInIndexPaginated(ctx context.Context, indexName string, queryString string, page pagination.FilteredPaginatedQuery, allowedSortFields []string, modelArrayPtr interface{}) (pagination.PaginatedResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("SearchInIndexPaginated", indexName)
+	if err := f.check(); err != nil {
+		return pagination.PaginatedResponse{}, err
+	}
+	if err := f.helper.checkSearchable(); err != nil {
+		return pagination.PaginatedResponse{}, err
+	}
+	options, err := paginatedSearchOptions(page.PaginatedQuery, allowedSortFields)
+	if err != nil {
+		return pagination.PaginatedResponse{}, err
+	}
+	totalCount, err := f.search(indexName, paginatedSearchQuery(queryString, page.SearchTerm), options, modelArrayPtr)
+	if err != nil {
+		return pagination.PaginatedResponse{}, err
+	}
+	return pagination.NewPaginatedResponse(page.PageSize, page.Page, totalCount), nil
+}
+func (f *fakeRedisCache) DeleteIndex(ctx context.Context, index string, deleteDocuments bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("DeleteIndex", index)
+	if err := f.check(); err != nil {
+		return err
+	}
+	index = f.resolveIndex(index)
+	existingIndex, exists := f.indexes[index]
+	if !exists {
+		return ErrNoSuchIndexFound
+	}
+	if deleteDocuments {
+		for _, document := range f.indexedDocuments(existingIndex) {
+			delete(f.entries, document.key)
+		}
+	}
+	f.dropIndex(index)
+	return nil
+}
+func (f *fakeRedisCache) EnsureIndex(ctx context.Context, definition IndexDefinition) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record("EnsureIndex", definition.Name)
+	if err := f.check(); err != nil {
+		return "", err
+	}
+	if err := f.helper.checkSearchable(); err != nil {
+		return "", err
+	}
+	options, fieldSchemas, err := definition.Schema()
+	if err != nil {
+		return "", err
+	}
+	indexName, err := definition.IndexName()
+	if err != nil {
+		return "", err
+	}
+	indexName = f.helper.indexName(indexName)
+	currentIndex := f.resolveIndex(definition.Name)
+	if _, exists := f.indexes[currentIndex]; !exists {
+		currentIndex = ""
+	}
+	if currentIndex == indexName {
+		return indexName, nil
+	}
+	// Note: the fake indexes synchronously, so both migrations result in the same state
+	if currentIndex != "" {
+		f.dropIndex(currentIndex)
+	}
+	if _, exists := f.indexes[indexName]; !exists {
+		if err = f.createIndex(indexName, options, fieldSchemas); err != nil {
+			return "", err
+		}
+	}
+	f.aliases[f.helper.indexName(definition.Name)] = indexName
+	return indexName, nil
+}
+
+// Key handling
+
+func (f *fakeRedisCache) KeyForAll() string {
+	return f.helper.KeyForAll()
+}
+func (f *fakeRedisCache) KeyForOne(id uuid.UUID) string {
+	return f.helper.KeyForOne(id)
+}
+func (f *fakeRedisCache) KeyForPage(page pagination.PaginatedQuery) string {
+	return f.helper.KeyForPage(page)
+}
+func (f *fakeRedisCache) KeyForCustomPage(page pagination.PaginatedQuery, customKey string) string {
+	return f.helper.KeyForCustomPage(page, customKey)
+}
+func (f *fakeRedisCache) KeyForCustom(customKey string) string {
+	return f.helper.KeyForCustom(customKey)
+}
+func (f *fakeRedisCache) KeyForValuedCustom(name string, values ...string) string {
+	return f.helper.KeyForValuedCustom(name, values...)
+}
+func (f *fakeRedisCache) KeyForNotFound() string {
+	return f.helper.KeyForNotFound()
+}
+
+// Helper functions
+
+func (f *fakeRedisCache) GuidToString(id uuid.UUID) string {
+	return f.helper.GuidToString(id)
+}
+
+// record appends a call, the mutex must be held
+func (f *fakeRedisCache) record(method string, keys ...string) {
+	f.calls = append(f.calls, FakeCacheCall{Method: method, Keys: keys})
+}
+
+// check mirrors the configuration checks and the circuit breaker of the real cache, the mutex must be held
+func (f *fakeRedisCache) check() error {
+	if f.config == nil {
+		return ErrConfigNotSet
+	}
+	if f.config.IsDisabled {
+		return ErrCachingDisabled
+	}
+	probe, err := f.breaker.allow()
+	if err != nil {
+		return err
+	}
+	f.breaker.done(probe, f.unavailable)
+	if f.unavailable {
+		return ErrFakeConnectionFailed
+	}
+	return nil
+}
+func (f *fakeRedisCache) isValid() bool {
+	return f.check() == nil && f.cacheValid
+}
+func (f *fakeRedisCache) expiration(expirationTime *time.Duration) (*time.Duration, error) {
+	expiration := f.config.DefaultExpiration
+	if expirationTime != nil {
+		expiration = expirationTime
+	}
+	if expiration == nil {
+		return nil, ErrExpirationNotSet
+	}
+	return expiration, nil
+}
+
+// clear removes all keys with the cache's prefix, the mutex must be held
+func (f *fakeRedisCache) clear() {
+	for key := range f.entries {
+		if strings.HasPrefix(key, f.helper.keyPrefix+":") {
+			delete(f.entries, key)
+		}
+	}
+}
+
+// entry returns the entry of the key, removing it if expired, the mutex must be held
+func (f *fakeRedisCache) entry(key string) *fakeEntry {
+	entry, exists := f.entries[key]
+	if !exists {
+		return nil
+	}
+	if entry.expiresAt != nil && !f.now().Before(*entry.expiresAt) {
+		delete(f.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// storeValue stores the content as JSON, like RedisJSON the expiration is kept when overwriting without expiration,
+// the expiration is extended by the ExpirationJitter
+func (f *fakeRedisCache) storeValue(key string, content interface{}, expiration *time.Duration) error {
+	payload, err := marshalContent(content)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(payload) {
+		return ErrFakeInvalidJSONDocument
+	}
+	entry := f.entry(key)
+	if entry != nil && entry.kind != fakeEntryValue {
+		return ErrFakeWrongType
+	}
+	newEntry := &fakeEntry{kind: fakeEntryValue, value: payload}
+	if entry != nil {
+		newEntry.expiresAt = entry.expiresAt
+	}
+	if expiration != nil {
+		expiresAt := f.now().Add(expirationWithJitter(*expiration, f.config.ExpirationJitter))
+		newEntry.expiresAt = &expiresAt
+	}
+	f.entries[key] = newEntry
+	return nil
+}
+
+// readValue reads the JSON value, like the real cache the expiration is only updated if the key has one
+func (f *fakeRedisCache) readValue(key string, modelPtr interface{}, expiration *time.Duration) error {
+	if !f.cacheValid {
+		return ErrCacheInvalid
+	}
+	entry := f.entry(key)
+	if entry == nil {
+		return ErrItemNotFound
+	}
+	if entry.kind != fakeEntryValue {
+		return ErrFakeWrongType
+	}
+	if expiration != nil && entry.expiresAt != nil {
+		expiresAt := f.now().Add(*expiration)
+		entry.expiresAt = &expiresAt
+	}
+	return json.Unmarshal(entry.value, modelPtr)
+}
+
+// createIndex adds the index, the mutex must be held
+func (f *fakeRedisCache) createIndex(index string, options *redis.FTCreateOptions, fieldSchemas []*redis.FieldSchema) error {
+	index = f.helper.indexName(index)
+	if _, exists := f.indexes[index]; exists {
+		return ErrFakeIndexExists
+	}
+	newIndex := &fakeIndex{fields: fieldSchemas}
+	if options != nil {
+		if options.OnJSON {
+			if err := f.helper.checkSearchable(); err != nil {
+				return err
+			}
+		}
+		newIndex.onJSON = options.OnJSON
+		for _, prefix := range options.Prefix {
+			newIndex.prefixes = append(newIndex.prefixes, fmt.Sprint(prefix))
+		}
+	}
+	f.indexes[index] = newIndex
+	return nil
+}
+
+// dropIndex removes the index and its aliases, the mutex must be held
+func (f *fakeRedisCache) dropIndex(index string) {
+	delete(f.indexes, index)
+	for alias, aliasedIndex := range f.aliases {
+		if aliasedIndex == index {
+			delete(f.aliases, alias)
+		}
+	}
+}
+
+// resolveIndex returns the index an alias refers to, or the name itself scoped to the tenant, the mutex must be held
+func (f *fakeRedisCache) resolveIndex(name string) string {
+	name = f.helper.indexName(name)
+	if index, isAlias := f.aliases[name]; isAlias {
+		return index
+	}
+	return name
+}
+
+// search appends the matching documents to modelArrayPtr and returns the number of matches, the mutex must be held
+func (f *fakeRedisCache) search(indexName string, queryString string, options *redis.FTSearchOptions, modelArrayPtr interface{}) (int, error) {
+	index, exists := f.indexes[f.resolveIndex(indexName)]
+	if !exists {
+		return 0, ErrNoSuchIndexFound
+	}
+	documents, totalCount, err := f.searchDocuments(index, queryString, options)
+	if err != nil {
+		return 0, err
+	}
+	v := reflect.ValueOf(modelArrayPtr)
+	if v.Kind() != reflect.Ptr {
+		return 0, fmt.Errorf("modelArrayPtr must be a pointer")
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("modelArrayPtr must be a slice")
+	}
+	elemType := v.Type().Elem()
+	for i := range documents {
+		newElem := reflect.New(elemType)
+		if err = json.Unmarshal(documents[i].value, newElem.Interface()); err != nil {
+			return 0, err
+		}
+		v.Set(reflect.Append(v, newElem.Elem()))
+	}
+	return totalCount, nil
+}
@@ -0,0 +1,874 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/pagination"
+	"github.com/blutspende/bloodlab-common/timezone"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeRedisCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.SetSynchronousRefresh(true)
+
+	type TestStruct struct {
+		Field1 string `json:"field1"`
+		Field2 int    `json:"field2"`
+	}
+
+	// Not initialized and invalid
+	assert.ErrorIs(t, cache.Store(ctx, cache.KeyForCustom("json"), TestStruct{}), ErrConfigNotSet)
+	defaultExpiration := time.Minute
+	cache.Init(RedisCacheConfig{RefreshRetryAttempts: 2, DefaultExpiration: &defaultExpiration}, func(ctx context.Context) error {
+		return cache.Store(ctx, cache.KeyForCustom("filled"), TestStruct{Field1: "filled"})
+	}, nil)
+	var testValueRead TestStruct
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("filled"), &testValueRead), ErrCacheInvalid)
+
+	// Synchronous refresh
+	cache.RefreshCacheAsync(ctx, false)
+	assert.True(t, cache.IsValid(ctx))
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("filled"), &testValueRead))
+	assert.Equal(t, "filled", testValueRead.Field1)
+	assert.Nil(t, cache.RefreshStatus().LastError)
+	assert.Equal(t, 1, cache.RefreshStatus().RefreshAttempt)
+
+	// JSON round trip and group read
+	testValueStore := TestStruct{Field1: "value1", Field2: 42}
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("json"), testValueStore))
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("json"), &testValueRead))
+	assert.Equal(t, testValueStore, testValueRead)
+	var group []TestStruct
+	assert.Nil(t, cache.ReadGroup(ctx, []string{cache.KeyForCustom("json"), cache.KeyForCustom("missing"), cache.KeyForCustom("filled")}, &group))
+	assert.Equal(t, []TestStruct{testValueStore, {Field1: "filled"}}, group)
+	assert.Nil(t, cache.Delete(ctx, cache.KeyForCustom("json")))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("json"), &testValueRead), ErrItemNotFound)
+	assert.ErrorIs(t, cache.Store(ctx, cache.KeyForCustom("json"), "not json"), ErrFakeInvalidJSONDocument)
+
+	// Expiration by clock, reading with expiration extends it
+	assert.Nil(t, cache.StoreWithExpiration(ctx, cache.KeyForCustom("expiring"), testValueStore, nil))
+	now = now.Add(50 * time.Second)
+	assert.Nil(t, cache.ReadWithExpiration(ctx, cache.KeyForCustom("expiring"), &testValueRead, nil))
+	now = now.Add(50 * time.Second)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("expiring"), &testValueRead))
+	now = now.Add(10 * time.Second)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("expiring"), &testValueRead), ErrItemNotFound)
+
+	// Sets and flags
+	assert.Nil(t, cache.AddItemToSet(ctx, cache.KeyForNotFound(), "a"))
+	isMember, err := cache.IsItemInSet(ctx, cache.KeyForNotFound(), "a")
+	assert.Nil(t, err)
+	assert.True(t, isMember)
+	assert.Nil(t, cache.DeleteItemFromSet(ctx, cache.KeyForNotFound(), "a"))
+	items, err := cache.GetItemsInSetAsMap(ctx, cache.KeyForNotFound())
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+	assert.Nil(t, cache.SetFlagWithExpiration(ctx, cache.KeyForCustom("flag"), nil))
+	flag, err := cache.GetFlag(ctx, cache.KeyForCustom("flag"))
+	assert.Nil(t, err)
+	assert.True(t, flag)
+	now = now.Add(time.Minute)
+	flag, err = cache.GetFlag(ctx, cache.KeyForCustom("flag"))
+	assert.Nil(t, err)
+	assert.False(t, flag)
+
+	// Group handling
+	err = cache.StoreGroup(ctx, map[string]interface{}{
+		cache.KeyForCustom("group1"): testValueStore,
+		cache.KeyForCustom("group2"): "invalid json",
+	}, nil)
+	var groupErr *GroupError
+	assert.ErrorAs(t, err, &groupErr)
+	assert.ErrorIs(t, groupErr.Failures[cache.KeyForCustom("group2")], ErrFakeInvalidJSONDocument)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("group1"), &testValueRead))
+	var groupMap map[string]*TestStruct
+	missingKeys, err := cache.ReadGroupAsMap(ctx, []string{cache.KeyForCustom("group2"), cache.KeyForCustom("group1")}, &groupMap)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{cache.KeyForCustom("group2")}, missingKeys)
+	assert.Equal(t, map[string]*TestStruct{cache.KeyForCustom("group1"): &testValueStore}, groupMap)
+	_, err = cache.ReadGroupAsMap(ctx, []string{cache.KeyForCustom("group1")}, groupMap)
+	assert.NotNil(t, err)
+	assert.Nil(t, cache.DeleteGroup(ctx, []string{cache.KeyForCustom("group1")}))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("group1"), &testValueRead), ErrItemNotFound)
+
+	// Call recording
+	assert.Equal(t, 4, cache.CallCount("Store"))
+	cache.ResetCalls()
+	assert.Nil(t, cache.Delete(ctx, cache.KeyForAll()))
+	assert.Equal(t, []FakeCacheCall{{Method: "Delete", Keys: []string{cache.KeyForAll()}}}, cache.Calls())
+}
+
+func TestFakeRedisCacheRefreshFailure(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.SetSynchronousRefresh(true)
+	refreshErr := errors.New("refresh failed")
+	var failedEvent *RefreshEvent
+	cache.Init(RedisCacheConfig{
+		RefreshRetryAttempts: 3,
+		RefreshHooks: RefreshHooks{
+			OnFailure: func(ctx context.Context, event RefreshEvent) {
+				failedEvent = &event
+			},
+		},
+	}, func(ctx context.Context) error {
+		return refreshErr
+	}, nil)
+	cache.RefreshCacheAsync(ctx, false)
+	assert.False(t, cache.IsValid(ctx))
+	assert.ErrorIs(t, cache.RefreshStatus().LastError, refreshErr)
+	assert.NotNil(t, failedEvent)
+	assert.Equal(t, 3, failedEvent.Attempt)
+	assert.Equal(t, "fake", failedEvent.CacheName)
+}
+
+func TestFakeRedisCacheIncrementalRefresh(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.SetSynchronousRefresh(true)
+	fullRefreshCount := 0
+	var checkpoints []RefreshCheckpoint
+	var events []RefreshEvent
+	cache.Init(RedisCacheConfig{
+		RefreshRetryAttempts: 1,
+		RefreshHooks: RefreshHooks{
+			OnSuccess: func(ctx context.Context, event RefreshEvent) {
+				events = append(events, event)
+			},
+		},
+		IncrementalRefreshFunc: func(ctx context.Context, checkpoint RefreshCheckpoint) (IncrementalChanges, error) {
+			checkpoints = append(checkpoints, checkpoint)
+			return IncrementalChanges{
+				Upserts: map[string]interface{}{cache.KeyForCustom("2"): "\"changed\"", cache.KeyForCustom("3"): "\"new\""},
+				Deletes: []string{cache.KeyForCustom("1")},
+				Token:   strconv.Itoa(len(checkpoints)),
+			}, nil
+		},
+	}, func(ctx context.Context) error {
+		fullRefreshCount++
+		return cache.StoreGroup(ctx, map[string]interface{}{cache.KeyForCustom("1"): "\"full\"", cache.KeyForCustom("2"): "\"full\""}, nil)
+	}, nil)
+
+	// The first refresh is a full refresh, as there is no checkpoint yet
+	cache.RefreshCacheAsync(ctx, false)
+	assert.Equal(t, 1, fullRefreshCount)
+	now = now.Add(time.Minute)
+	cache.RefreshCacheAsync(ctx, false)
+	assert.Equal(t, 1, fullRefreshCount)
+	assert.Equal(t, []RefreshCheckpoint{{RefreshedAt: now.Add(-time.Minute)}}, checkpoints)
+	assert.True(t, cache.IsValid(ctx))
+	var value string
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("1"), &value), ErrItemNotFound)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("2"), &value))
+	assert.Equal(t, "changed", value)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("3"), &value))
+	assert.Equal(t, "new", value)
+
+	cache.RefreshCacheAsync(ctx, false)
+	assert.Equal(t, RefreshCheckpoint{Token: "1", RefreshedAt: now}, checkpoints[1])
+
+	// Forced refreshes are full refreshes
+	cache.RefreshCacheAsync(ctx, true)
+	assert.Equal(t, 2, fullRefreshCount)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("1"), &value))
+	assert.Equal(t, "full", value)
+	assert.Equal(t, []bool{false, true, true, false}, []bool{events[0].Incremental, events[1].Incremental, events[2].Incremental, events[3].Incremental})
+}
+
+func TestFakeRedisCacheSearch(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type Instrument struct {
+		Name   string   `json:"name"`
+		Type   string   `json:"type"`
+		Count  int      `json:"count"`
+		Labels []string `json:"labels"`
+	}
+	instruments := []Instrument{
+		{Name: "Alinity ci", Type: "ASTM", Count: 5, Labels: []string{"lab1"}},
+		{Name: "Cobas pure", Type: "HL7", Count: 10, Labels: []string{"lab1", "lab2"}},
+		{Name: "Alinity hq", Type: "HL7", Count: 15, Labels: []string{"lab2"}},
+	}
+	for i := range instruments {
+		assert.Nil(t, cache.Store(ctx, cache.KeyForValuedCustom("INSTRUMENT", instruments[i].Name), instruments[i]))
+	}
+	_, err := cache.CreateIndex(ctx, "idx", &redis.FTCreateOptions{OnJSON: true, Prefix: []interface{}{cache.KeyForCustom("INSTRUMENT")}}, []*redis.FieldSchema{
+		{FieldName: "$.name", As: "name", FieldType: redis.SearchFieldTypeText},
+		{FieldName: "$.type", As: "type", FieldType: redis.SearchFieldTypeTag},
+		{FieldName: "$.count", As: "count", FieldType: redis.SearchFieldTypeNumeric, Sortable: true},
+		{FieldName: "$.labels[*]", As: "labels", FieldType: redis.SearchFieldTypeTag},
+	})
+	assert.Nil(t, err)
+
+	search := func(query string, options *redis.FTSearchOptions, expectedTotalCount int) []string {
+		var result []Instrument
+		totalCount, err := cache.SearchInIndex(ctx, "idx", query, options, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedTotalCount, totalCount)
+		names := make([]string, 0)
+		for i := range result {
+			names = append(names, result[i].Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"Alinity ci", "Alinity hq", "Cobas pure"}, search("*", nil, 3))
+	assert.Equal(t, []string{"Alinity hq", "Cobas pure"}, search("@type:{hl7}", nil, 2))
+	assert.Equal(t, []string{"Alinity ci"}, search("-@type:{HL7}", nil, 1))
+	assert.Equal(t, []string{"Cobas pure", "Alinity hq"}, search("@count:[(5 +inf]", &redis.FTSearchOptions{SortBy: []redis.FTSearchSortBy{{FieldName: "count"}}}, 2))
+	assert.Equal(t, []string{"Alinity hq"}, search("alin* @labels:{$lab}", &redis.FTSearchOptions{Params: map[string]interface{}{"lab": "lab2"}}, 1))
+	assert.Equal(t, []string{"Cobas pure"}, search(`@name:"cobas pure"`, nil, 1))
+	assert.Equal(t, []string{"Alinity hq"}, search("*", &redis.FTSearchOptions{SortBy: []redis.FTSearchSortBy{{FieldName: "count", Desc: true}}, Limit: 1}, 3))
+	assert.Equal(t, []string{"Alinity ci", "Alinity hq"}, search("(@type:{HL7|ASTM} -@count:[10 10]) alin*", nil, 2))
+
+	// Paginated search
+	var page []Instrument
+	searchTerm := "alin"
+	response, err := cache.SearchInIndexPaginated(ctx, "idx", "@labels:{lab1|lab2}", pagination.FilteredPaginatedQuery{
+		PaginatedQuery: pagination.PaginatedQuery{PageSize: 1, Page: 1, Direction: "descending", Sort: "count"},
+		SearchTerm:     &searchTerm,
+	}, []string{"count"}, &page)
+	assert.Nil(t, err)
+	assert.Equal(t, pagination.PaginatedResponse{PageSize: 1, CurrentPage: 1, TotalCount: 2, TotalPages: 2}, response)
+	assert.Equal(t, []Instrument{instruments[0]}, page)
+	page = nil
+	response, err = cache.SearchInIndexPaginated(ctx, "idx", "", pagination.FilteredPaginatedQuery{}, nil, &page)
+	assert.Nil(t, err)
+	assert.Equal(t, pagination.PaginatedResponse{TotalCount: 3, TotalPages: 1}, response)
+	assert.Len(t, page, 3)
+	_, err = cache.SearchInIndexPaginated(ctx, "idx", "*", pagination.FilteredPaginatedQuery{
+		PaginatedQuery: pagination.PaginatedQuery{PageSize: 25, Sort: "name"},
+	}, []string{"count"}, &page)
+	assert.ErrorIs(t, err, ErrSortFieldNotAllowed)
+
+	var result []Instrument
+	_, err = cache.SearchInIndex(ctx, "idx", "@unknown:{a}", nil, &result)
+	assert.ErrorIs(t, err, ErrFakeUnknownSearchField)
+	_, err = cache.SearchInIndex(ctx, "idx", "%alinity%", nil, &result)
+	assert.ErrorIs(t, err, ErrFakeQueryNotSupported)
+	_, err = cache.SearchInIndex(ctx, "missing", "*", nil, &result)
+	assert.ErrorIs(t, err, ErrNoSuchIndexFound)
+	assert.Nil(t, cache.DeleteIndex(ctx, "idx", true))
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForValuedCustom("INSTRUMENT", "Alinity ci"), &Instrument{}), ErrItemNotFound)
+}
+
+func TestFakeRedisCacheJSONPath(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type Connection struct {
+		Status string `json:"status"`
+		Errors int    `json:"errors"`
+	}
+	type Instrument struct {
+		Name       string     `json:"name"`
+		Connection Connection `json:"connection"`
+		Labels     []string   `json:"labels"`
+	}
+	key := cache.KeyForCustom("instrument")
+	assert.ErrorIs(t, cache.StorePath(ctx, key, "$.connection.status", "\"ONLINE\""), ErrItemNotFound)
+	assert.Nil(t, cache.StorePath(ctx, key, "$", Instrument{Name: "Alinity ci", Connection: Connection{Status: "OFFLINE"}, Labels: []string{"lab1"}}))
+
+	assert.Nil(t, cache.StorePath(ctx, key, "$.connection.status", "\"ONLINE\""))
+	var status string
+	assert.Nil(t, cache.ReadPath(ctx, key, "$.connection.status", &status))
+	assert.Equal(t, "ONLINE", status)
+	assert.Nil(t, cache.MergePath(ctx, key, "$.connection", map[string]interface{}{"errors": 2}))
+	assert.Nil(t, cache.AppendToArray(ctx, key, "$.labels", "\"lab2\"", "\"lab3\""))
+	errorCount, err := cache.IncrementNumber(ctx, key, "$.connection.errors", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(5), errorCount)
+	var label string
+	assert.Nil(t, cache.ReadPath(ctx, key, "$.labels[-1]", &label))
+	assert.Equal(t, "lab3", label)
+
+	var instrument Instrument
+	assert.Nil(t, cache.Read(ctx, key, &instrument))
+	assert.Equal(t, Instrument{Name: "Alinity ci", Connection: Connection{Status: "ONLINE", Errors: 5}, Labels: []string{"lab1", "lab2", "lab3"}}, instrument)
+
+	assert.ErrorIs(t, cache.ReadPath(ctx, key, "$.missing", &status), ErrPathNotFound)
+	assert.ErrorIs(t, cache.StorePath(ctx, key, "$.missing.status", "1"), ErrPathNotFound)
+	_, err = cache.IncrementNumber(ctx, key, "$.name", 1)
+	assert.ErrorIs(t, err, ErrPathNotFound)
+	assert.ErrorIs(t, cache.ReadPath(ctx, key, "connection", &status), ErrInvalidJSONPath)
+	assert.ErrorIs(t, cache.ReadPath(ctx, key, "$..status", &status), ErrFakeJSONPathNotSupported)
+}
+
+func TestFakeRedisCacheReadOrCompute(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	expiration := time.Hour
+	cache.Init(RedisCacheConfig{DefaultExpiration: &expiration, EarlyExpirationBeta: 1e-9}, nil, nil)
+
+	computeCount := 0
+	compute := func(ctx context.Context) (interface{}, error) {
+		computeCount++
+		now = now.Add(time.Minute)
+		return computeCount, nil
+	}
+	var value int
+	key := cache.KeyForCustom("computed")
+	assert.Nil(t, cache.ReadOrCompute(ctx, key, &value, nil, compute))
+	assert.Equal(t, 1, value)
+	assert.ErrorIs(t, cache.Read(ctx, key, &value), ErrCacheInvalid)
+
+	cache.SetToValid(ctx)
+	assert.Nil(t, cache.ReadOrCompute(ctx, key, &value, nil, compute))
+	assert.Equal(t, 2, value)
+	now = now.Add(50 * time.Minute)
+	assert.Nil(t, cache.ReadOrCompute(ctx, key, &value, nil, compute))
+	assert.Equal(t, 2, value)
+	now = now.Add(10 * time.Minute)
+	assert.Nil(t, cache.ReadOrCompute(ctx, key, &value, nil, compute))
+	assert.Equal(t, 3, value)
+
+	// With a large beta the compute time of a minute triggers the recomputation long before the expiry
+	cache.Init(RedisCacheConfig{DefaultExpiration: &expiration, EarlyExpirationBeta: 1e6}, nil, nil)
+	cache.SetToValid(ctx)
+	assert.Nil(t, cache.ReadOrCompute(ctx, key, &value, nil, compute))
+	assert.Equal(t, 4, value)
+
+	computeErr := errors.New("database unavailable")
+	assert.ErrorIs(t, cache.ReadOrCompute(ctx, cache.KeyForCustom("failing"), &value, nil, func(ctx context.Context) (interface{}, error) {
+		return nil, computeErr
+	}), computeErr)
+}
+
+func TestFakeRedisCacheCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	var transitions []string
+	cache.Init(RedisCacheConfig{CircuitBreaker: &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		OnStateChange: func(from CircuitState, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}}, nil, nil)
+	cache.SetToValid(ctx)
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("key"), "1"))
+
+	cache.SetUnavailable(true)
+	var value int
+	expiration := time.Hour
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrFakeConnectionFailed)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrFakeConnectionFailed)
+	assert.Equal(t, CircuitOpen, cache.CircuitState())
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrCacheUnavailable)
+	assert.False(t, cache.IsValid(ctx))
+	assert.Nil(t, cache.ReadOrCompute(ctx, cache.KeyForCustom("computed"), &value, &expiration, func(ctx context.Context) (interface{}, error) {
+		return 2, nil
+	}))
+	assert.Equal(t, 2, value)
+
+	// The failing probe opens the circuit again, the successful probe closes it
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrFakeConnectionFailed)
+	assert.Equal(t, CircuitOpen, cache.CircuitState())
+	cache.SetUnavailable(false)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("key"), &value), ErrCacheUnavailable)
+	now = now.Add(time.Minute)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("key"), &value))
+	assert.Equal(t, 1, value)
+	assert.Equal(t, CircuitClosed, cache.CircuitState())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, transitions)
+	status, err := cache.Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, CircuitClosed, status.CircuitState)
+}
+
+func TestFakeRedisCacheTags(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	shortExpiration, longExpiration := time.Minute, time.Hour
+	assert.Nil(t, cache.StoreWithTags(ctx, cache.KeyForCustom("config1"), "1", &shortExpiration, "instrument:1"))
+	assert.Nil(t, cache.StoreWithTags(ctx, cache.KeyForCustom("config2"), "2", &longExpiration, "instrument:1", "instrument:2"))
+	assert.Nil(t, cache.StoreWithTags(ctx, cache.KeyForCustom("config3"), "3", nil, "instrument:2"))
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("other"), "4"))
+	assert.ErrorIs(t, cache.StoreWithTags(ctx, cache.KeyForCustom("config4"), "4", nil, ""), ErrInvalidTag)
+
+	members, err := cache.GetItemsInSetAsMap(ctx, cache.KeyForTag("instrument:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]struct{}{cache.KeyForCustom("config1"): {}, cache.KeyForCustom("config2"): {}}, members)
+
+	// The tag set lives as long as its longest living entry, members of expired entries are removed when tagging
+	now = now.Add(2 * time.Minute)
+	assert.Nil(t, cache.StoreWithTags(ctx, cache.KeyForCustom("config2"), "2", &longExpiration, "instrument:1"))
+	members, err = cache.GetItemsInSetAsMap(ctx, cache.KeyForTag("instrument:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]struct{}{cache.KeyForCustom("config2"): {}}, members)
+
+	deletedCount, err := cache.InvalidateTags(ctx, "instrument:2")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deletedCount)
+	var value int
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("config2"), &value), ErrItemNotFound)
+	assert.ErrorIs(t, cache.Read(ctx, cache.KeyForCustom("config3"), &value), ErrItemNotFound)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("other"), &value))
+	isMember, err := cache.IsItemInSet(ctx, cache.KeyForTag("instrument:2"), cache.KeyForCustom("config3"))
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+
+	// Stale members of other tags are ignored
+	deletedCount, err = cache.InvalidateTags(ctx, "instrument:1", "unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, deletedCount)
+	members, err = cache.GetItemsInSetAsMap(ctx, cache.KeyForTag("instrument:1"))
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+}
+
+func TestFakeRedisCacheJobs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.Init(RedisCacheConfig{JobQueue: JobQueueConfig{VisibilityTimeout: time.Minute}}, nil, nil)
+
+	// Jobs are claimed when due, deduplicated while waiting or running
+	_, _, err := cache.EnqueueJob(ctx, "", "payload", now, "")
+	assert.ErrorIs(t, err, ErrInvalidQueue)
+	retransmitId, enqueued, err := cache.EnqueueJob(ctx, "retransmit", "result1", now.Add(5*time.Minute), "result1")
+	assert.Nil(t, err)
+	assert.True(t, enqueued)
+	assert.Equal(t, "result1", retransmitId)
+	_, enqueued, err = cache.EnqueueJob(ctx, "retransmit", "result1", now, "result1")
+	assert.Nil(t, err)
+	assert.False(t, enqueued)
+	_, _, err = cache.EnqueueJob(ctx, "retransmit", "result2", now.Add(time.Minute), "")
+	assert.Nil(t, err)
+	jobs, err := cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+	now = now.Add(5 * time.Minute)
+	jobs, err = cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	if !assert.Len(t, jobs, 2) {
+		return
+	}
+	var payload string
+	assert.Nil(t, jobs[0].Decode(&payload))
+	assert.Equal(t, "result2", payload)
+	assert.Equal(t, "result1", jobs[1].ID)
+	assert.Equal(t, 1, jobs[1].Attempt)
+	_, enqueued, err = cache.EnqueueJob(ctx, "retransmit", "result1", now, "result1")
+	assert.Nil(t, err)
+	assert.False(t, enqueued)
+
+	// Completed jobs are removed, retried jobs are due after the backoff
+	assert.Nil(t, cache.CompleteJob(ctx, jobs[0]))
+	assert.Nil(t, cache.RetryJob(ctx, jobs[1], nil))
+	now = now.Add(900 * time.Millisecond)
+	jobs, err = cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+	now = now.Add(1200 * time.Millisecond)
+	jobs, err = cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	if !assert.Len(t, jobs, 1) {
+		return
+	}
+	assert.Equal(t, 2, jobs[0].Attempt)
+
+	// Jobs neither completed nor retried are claimed again after the visibility timeout
+	now = now.Add(time.Minute)
+	reclaimed, err := cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	assert.Len(t, reclaimed, 1)
+	assert.ErrorIs(t, cache.CompleteJob(ctx, jobs[0]), ErrJobClaimLost)
+	assert.ErrorIs(t, cache.RetryJob(ctx, Job{Queue: "retransmit"}, nil), ErrInvalidJobRef)
+	assert.Nil(t, cache.CompleteJob(ctx, reclaimed[0]))
+
+	// Refreshes don't remove jobs
+	_, _, err = cache.EnqueueJob(ctx, "retransmit", "result3", now, "")
+	assert.Nil(t, err)
+	cache.SetSynchronousRefresh(true)
+	cache.RefreshCacheAsync(ctx, true)
+	jobs, err = cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 1)
+}
+
+func TestFakeRedisCacheCounters(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 22, 30, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.Init(RedisCacheConfig{CounterTimeZone: timezone.EuropeBerlin}, nil, nil)
+
+	// Daily counters reset at midnight in the time zone of the cache
+	value, err := cache.IncrementCounter(ctx, "results:instrument1", 2, &WallClock{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), value)
+	value, err = cache.IncrementCounter(ctx, "results:instrument1", 3, &WallClock{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), value)
+	now = now.Add(time.Hour)
+	value, err = cache.GetCounter(ctx, "results:instrument1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), value)
+	_, err = cache.IncrementCounter(ctx, "results:instrument1", 1, &WallClock{Hour: 24})
+	assert.ErrorIs(t, err, ErrInvalidWallClock)
+	_, err = cache.IncrementCounter(ctx, "", 1, nil)
+	assert.ErrorIs(t, err, ErrInvalidCounter)
+
+	// Counters without reset are kept until they are reset
+	_, err = cache.IncrementCounter(ctx, "total", 7, nil)
+	assert.Nil(t, err)
+	now = now.Add(48 * time.Hour)
+	value, err = cache.GetCounter(ctx, "total")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), value)
+	assert.Nil(t, cache.ResetCounter(ctx, "total"))
+	value, err = cache.GetCounter(ctx, "total")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), value)
+
+	// Sequences are seeded on first use of the day and never go backwards
+	seedCalls := 0
+	seed := func(ctx context.Context, sequence string, day time.Time) (int64, error) {
+		seedCalls++
+		assert.Equal(t, "run", sequence)
+		return 41, nil
+	}
+	number, err := cache.NextSequenceNumber(ctx, "run", seed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), number.Number)
+	assert.Equal(t, "2024-01-04T00:00:00+01:00", number.Day.Format(time.RFC3339))
+	number, err = cache.NextSequenceNumber(ctx, "run", seed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(43), number.Number)
+	assert.Equal(t, 1, seedCalls)
+	now = now.Add(24 * time.Hour)
+	number, err = cache.NextSequenceNumber(ctx, "run", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), number.Number)
+	assert.Equal(t, "2024-01-05T00:00:00+01:00", number.Day.Format(time.RFC3339))
+	_, err = cache.NextSequenceNumber(ctx, "", nil)
+	assert.ErrorIs(t, err, ErrInvalidSequence)
+
+	// Refreshes don't remove counters and sequences
+	_, err = cache.IncrementCounter(ctx, "total", 1, nil)
+	assert.Nil(t, err)
+	cache.SetSynchronousRefresh(true)
+	cache.RefreshCacheAsync(ctx, true)
+	value, err = cache.GetCounter(ctx, "total")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	number, err = cache.NextSequenceNumber(ctx, "run", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), number.Number)
+}
+
+func TestFakeRedisCacheAdmin(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewFakeRedisCache()
+	cache.SetClock(func() time.Time { return now })
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	type Instrument struct {
+		Name string
+	}
+	expiration := time.Minute
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, id := range ids {
+		assert.Nil(t, cache.StoreWithExpiration(ctx, cache.KeyForOne(id), Instrument{Name: "one"}, &expiration))
+	}
+	assert.Nil(t, cache.Store(ctx, cache.KeyForAll(), []Instrument{{Name: "all"}}))
+	assert.Nil(t, cache.Store(ctx, cache.KeyForPage(pagination.PaginatedQuery{PageSize: 10}), []Instrument{}))
+	assert.Nil(t, cache.AddItemToSet(ctx, cache.KeyForCustom("codes"), "PLASMA"))
+	_, err := cache.IncrementCounter(ctx, "results", 1, nil)
+	assert.Nil(t, err)
+
+	// Keys are counted and listed by category with cursor paging
+	counts, err := cache.CountKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[KeyCategory]int64{KeyCategoryAll: 1, KeyCategoryOne: 3, KeyCategoryPage: 1, KeyCategoryCustom: 1, KeyCategoryCounter: 1}, counts)
+	page, err := cache.ListKeys(ctx, KeyCategoryOne, 0, 2)
+	assert.Nil(t, err)
+	assert.Len(t, page.Keys, 2)
+	assert.NotZero(t, page.Cursor)
+	assert.Equal(t, time.Minute, *page.Keys[0].TTL)
+	assert.Equal(t, "ReJSON-RL", page.Keys[0].Type)
+	assert.Positive(t, page.Keys[0].Size)
+	page, err = cache.ListKeys(ctx, KeyCategoryOne, page.Cursor, 2)
+	assert.Nil(t, err)
+	assert.Len(t, page.Keys, 1)
+	assert.Zero(t, page.Cursor)
+	page, err = cache.ListKeys(ctx, "", 0, 100)
+	assert.Nil(t, err)
+	assert.Len(t, page.Keys, 7)
+	_, err = cache.ListKeys(ctx, "UNKNOWN", 0, 100)
+	assert.ErrorIs(t, err, ErrInvalidKeyCategory)
+
+	// Single keys are inspected
+	info, err := cache.InspectKey(ctx, cache.KeyForCustom("codes"))
+	assert.Nil(t, err)
+	assert.Equal(t, "set", info.Type)
+	assert.Nil(t, info.TTL)
+	assert.Equal(t, "codes", info.Name)
+	_, err = cache.InspectKey(ctx, cache.KeyForCustom("missing"))
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	// Keys are deleted by category
+	deletedCount, err := cache.DeleteKeysByCategory(ctx, KeyCategoryOne)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), deletedCount)
+	_, err = cache.InspectKey(ctx, cache.KeyForOne(ids[0]))
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	_, err = cache.DeleteKeysByCategory(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidKeyCategory)
+	counts, err = cache.CountKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), counts[KeyCategoryOne])
+	assert.Equal(t, int64(1), counts[KeyCategoryAll])
+}
+
+func TestFakeRedisCacheSnapshot(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := NewFakeRedisCache()
+	source.SetClock(func() time.Time { return now })
+	source.Init(RedisCacheConfig{}, nil, nil)
+	source.SetToValid(ctx)
+
+	type Instrument struct {
+		Name string `json:"name" redisearch:"TEXT"`
+		Type string `json:"type" redisearch:"TAG"`
+	}
+	expiration := time.Minute
+	assert.Nil(t, source.StoreWithExpiration(ctx, source.KeyForValuedCustom("INSTRUMENT", "1"), Instrument{Name: "Alinity ci", Type: "ASTM"}, &expiration))
+	assert.Nil(t, source.AddItemToSet(ctx, source.KeyForCustom("codes"), "PLASMA"))
+	assert.Nil(t, source.SetFlag(ctx, source.KeyForCustom("flag")))
+	_, err := source.IncrementCounter(ctx, "results", 3, nil)
+	assert.Nil(t, err)
+	_, err = source.EnsureIndex(ctx, IndexDefinition{Name: "instruments", Prefixes: []string{source.KeyForCustom("INSTRUMENT")}, Model: Instrument{}})
+	assert.Nil(t, err)
+	_, _, err = source.EnqueueJob(ctx, "retransmit", "result", now, "")
+	assert.Nil(t, err)
+
+	var snapshot bytes.Buffer
+	stats, err := source.ExportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, SnapshotStats{Keys: 4, Indexes: 1, Skipped: 1}, stats)
+
+	// The snapshot is imported with the values, TTLs and indexes
+	now = now.Add(30 * time.Second)
+	target := NewFakeRedisCache()
+	target.SetClock(func() time.Time { return now })
+	target.Init(RedisCacheConfig{}, nil, nil)
+	target.SetToValid(ctx)
+	stats, err = target.ImportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, SnapshotStats{Keys: 4, Indexes: 1}, stats)
+	var result []Instrument
+	_, err = target.SearchInIndex(ctx, "instruments", "@type:{astm}", nil, &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	inSet, err := target.IsItemInSet(ctx, target.KeyForCustom("codes"), "PLASMA")
+	assert.Nil(t, err)
+	assert.True(t, inSet)
+	flag, err := target.GetFlag(ctx, target.KeyForCustom("flag"))
+	assert.Nil(t, err)
+	assert.True(t, flag)
+	counter, err := target.GetCounter(ctx, "results")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), counter)
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, target.Read(ctx, target.KeyForValuedCustom("INSTRUMENT", "1"), &Instrument{}), ErrItemNotFound)
+
+	// Tagged entries imported into a cache with another name are invalidated in that cache only
+	assert.Nil(t, source.StoreWithTags(ctx, source.KeyForCustom("config"), Instrument{Name: "Alinity ci"}, nil, "instrument:1"))
+	snapshot.Reset()
+	_, err = source.ExportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	assert.Contains(t, snapshot.String(), `"members":["config"]`)
+	tenant, err := source.WithTenant("berlin")
+	assert.Nil(t, err)
+	tenant.Init(RedisCacheConfig{}, nil, nil)
+	tenant.SetToValid(ctx)
+	_, err = tenant.ImportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	deleted, err := tenant.InvalidateTags(ctx, "instrument:1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	var config Instrument
+	assert.ErrorIs(t, tenant.Read(ctx, tenant.KeyForCustom("config"), &config), ErrItemNotFound)
+	assert.Nil(t, source.Read(ctx, source.KeyForCustom("config"), &config))
+
+	// Snapshots of other versions are rejected
+	_, err = target.ImportSnapshot(ctx, strings.NewReader(`{"version":2,"cache":"fake"}`))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	_, err = target.ImportSnapshot(ctx, strings.NewReader("not a snapshot"))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
+
+func TestFakeRedisCacheTenants(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.SetSynchronousRefresh(true)
+	cache.Init(RedisCacheConfig{RefreshRetryAttempts: 1}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type Instrument struct {
+		Name string `json:"name" redisearch:"TEXT"`
+		Type string `json:"type" redisearch:"TAG"`
+	}
+	tenants := make(map[string]RedisCache)
+	for _, tenant := range []string{"berlin", "munich"} {
+		tenantCache, err := cache.WithTenant(tenant)
+		assert.Nil(t, err)
+		tenantCache.Init(RedisCacheConfig{RefreshRetryAttempts: 1}, func(ctx context.Context) error {
+			return tenants[TenantFromContext(ctx)].Store(ctx, tenants[TenantFromContext(ctx)].KeyForValuedCustom("INSTRUMENT", "1"), Instrument{Name: TenantFromContext(ctx), Type: "ASTM"})
+		}, nil)
+		tenants[tenant] = tenantCache
+	}
+
+	// The keys and indexes of the tenants are separated
+	assert.Equal(t, "fake@berlin:ALL", tenants["berlin"].KeyForAll())
+	assert.Nil(t, cache.Store(ctx, cache.KeyForValuedCustom("INSTRUMENT", "1"), Instrument{Name: "shared", Type: "ASTM"}))
+	for _, tenantCache := range tenants {
+		tenantCache.RefreshCacheAsync(ctx, true)
+		assert.True(t, tenantCache.IsValid(ctx))
+		_, err := tenantCache.EnsureIndex(ctx, IndexDefinition{Name: "instruments", Prefixes: []string{tenantCache.KeyForCustom("INSTRUMENT")}, Model: Instrument{}})
+		assert.Nil(t, err)
+	}
+	var result []Instrument
+	_, err := tenants["berlin"].SearchInIndex(ctx, "instruments", "@type:{astm}", nil, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, []Instrument{{Name: "berlin", Type: "ASTM"}}, result)
+	_, err = cache.SearchInIndex(ctx, "instruments", "@type:{astm}", nil, &result)
+	assert.ErrorIs(t, err, ErrNoSuchIndexFound)
+
+	// A refresh only clears the keys of the own tenant
+	keyCounts, err := cache.CountKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), keyCounts[KeyCategoryCustom])
+	tenants["berlin"].RefreshCacheAsync(ctx, true)
+	_, err = tenants["munich"].InspectKey(ctx, tenants["munich"].KeyForValuedCustom("INSTRUMENT", "1"))
+	assert.Nil(t, err)
+	_, err = cache.InspectKey(ctx, cache.KeyForValuedCustom("INSTRUMENT", "1"))
+	assert.Nil(t, err)
+
+	// Tenants must be valid and can't be nested
+	_, err = cache.WithTenant("ber:lin")
+	assert.ErrorIs(t, err, ErrInvalidTenant)
+	_, err = tenants["berlin"].WithTenant("munich")
+	assert.ErrorIs(t, err, ErrInvalidTenant)
+}
+
+func TestFakeRedisCacheEnsureIndex(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type Instrument struct {
+		Name string `json:"name" redisearch:"TEXT"`
+		Type string `json:"type" redisearch:"TAG"`
+	}
+	assert.Nil(t, cache.Store(ctx, cache.KeyForValuedCustom("INSTRUMENT", "1"), Instrument{Name: "Alinity ci", Type: "ASTM"}))
+	definition := IndexDefinition{Name: "instruments", Prefixes: []string{cache.KeyForCustom("INSTRUMENT")}, Model: Instrument{}}
+	indexName, err := cache.EnsureIndex(ctx, definition)
+	assert.Nil(t, err)
+	sameIndexName, err := cache.EnsureIndex(ctx, definition)
+	assert.Nil(t, err)
+	assert.Equal(t, indexName, sameIndexName)
+	var result []Instrument
+	_, err = cache.SearchInIndex(ctx, "instruments", "@type:{astm}", nil, &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+
+	// Migration to a changed schema keeps the alias and the documents
+	type InstrumentV2 struct {
+		Name string `json:"name" redisearch:"TAG"`
+	}
+	definition.Model = InstrumentV2{}
+	migratedIndexName, err := cache.EnsureIndex(ctx, definition)
+	assert.Nil(t, err)
+	assert.NotEqual(t, indexName, migratedIndexName)
+	result = nil
+	_, err = cache.SearchInIndex(ctx, "instruments", "@name:{Alinity ci}", nil, &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	_, err = cache.SearchInIndex(ctx, indexName, "*", nil, &result)
+	assert.ErrorIs(t, err, ErrNoSuchIndexFound)
+}
+
+func TestFakeRedisCacheAggregate(t *testing.T) {
+	ctx := context.Background()
+	cache := NewFakeRedisCache()
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type Result struct {
+		Instrument string `json:"instrument" redisearch:"TAG"`
+		Status     string `json:"status" redisearch:"TAG"`
+		Value      int    `json:"value" redisearch:"NUMERIC"`
+	}
+	results := []Result{
+		{Instrument: "Alinity ci", Status: "FINAL", Value: 10},
+		{Instrument: "Alinity ci", Status: "PRELIMINARY", Value: 20},
+		{Instrument: "Cobas pure", Status: "FINAL", Value: 30},
+		{Instrument: "Alinity ci", Status: "FINAL", Value: 60},
+	}
+	for i := range results {
+		assert.Nil(t, cache.Store(ctx, cache.KeyForValuedCustom("RESULT", strconv.Itoa(i)), results[i]))
+	}
+	_, err := cache.EnsureIndex(ctx, IndexDefinition{Name: "results", Prefixes: []string{cache.KeyForCustom("RESULT")}, Model: Result{}})
+	assert.Nil(t, err)
+
+	type InstrumentCount struct {
+		Instrument string  `json:"instrument"`
+		Count      int     `json:"count"`
+		Average    float64 `json:"average"`
+		Maximum    *int    `json:"maximum"`
+	}
+	var counts []InstrumentCount
+	aggregation := NewAggregation("@status:{FINAL}").
+		GroupBy("instrument").
+		Reduce(ReduceCount("count"), ReduceAvg("value", "average"), ReduceMax("@value", "maximum")).
+		SortBy("count", true)
+	assert.Nil(t, cache.Aggregate(ctx, "results", aggregation, &counts))
+	maximum1, maximum2 := 60, 30
+	assert.Equal(t, []InstrumentCount{
+		{Instrument: "Alinity ci", Count: 2, Average: 35, Maximum: &maximum1},
+		{Instrument: "Cobas pure", Count: 1, Average: 30, Maximum: &maximum2},
+	}, counts)
+
+	var statusCounts []map[string]string
+	aggregation = NewAggregation("*").GroupBy("@status").Reduce(ReduceCountDistinct("instrument", "instruments")).SortBy("status", false).Limit(0, 1)
+	assert.Nil(t, cache.Aggregate(ctx, "results", aggregation, &statusCounts))
+	assert.Equal(t, []map[string]string{{"status": "FINAL", "instruments": "2"}}, statusCounts)
+
+	assert.ErrorIs(t, cache.Aggregate(ctx, "results", NewAggregation("*").Reduce(ReduceCount("count")), &statusCounts), ErrInvalidAggregation)
+	assert.ErrorIs(t, cache.Aggregate(ctx, "results", NewAggregation("*").GroupBy("status").Apply("upper(@status)", "status"), &statusCounts), ErrInvalidAggregation)
+	assert.ErrorIs(t, cache.Aggregate(ctx, "missing", NewAggregation("*"), &statusCounts), ErrNoSuchIndexFound)
+}
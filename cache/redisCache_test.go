@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"bytes"
 	"context"
 	"testing"
+	"time"
 
+	"github.com/blutspende/bloodlab-common/pagination"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
@@ -76,4 +79,389 @@ func TestPostgresConnection(t *testing.T) {
 	assert.Nil(t, err)
 	err = cache.Read(ctx, cache.KeyForCustom("json"), &testValueRead)
 	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	// Test group
+	err = cache.StoreGroup(ctx, map[string]interface{}{
+		cache.KeyForCustom("group1"): TestStruct{Field1: "value1", Field2: 1},
+		cache.KeyForCustom("group2"): TestStruct{Field1: "value2", Field2: 2},
+		cache.KeyForCustom("group3"): "invalid json",
+	}, nil)
+	var groupErr *GroupError
+	assert.ErrorAs(t, err, &groupErr)
+	assert.Len(t, groupErr.Failures, 1)
+	assert.Contains(t, groupErr.Failures, cache.KeyForCustom("group3"))
+	var testValuesRead []TestStruct
+	err = cache.ReadGroup(ctx, []string{cache.KeyForCustom("group1"), cache.KeyForCustom("group2")}, &testValuesRead)
+	assert.Nil(t, err)
+	assert.Equal(t, []TestStruct{{Field1: "value1", Field2: 1}, {Field1: "value2", Field2: 2}}, testValuesRead)
+	testValuesMap := make(map[string]TestStruct)
+	missingKeys, err := cache.ReadGroupAsMap(ctx, []string{cache.KeyForCustom("group2"), cache.KeyForCustom("group3"), cache.KeyForCustom("group1")}, &testValuesMap)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{cache.KeyForCustom("group3")}, missingKeys)
+	assert.Equal(t, map[string]TestStruct{
+		cache.KeyForCustom("group1"): {Field1: "value1", Field2: 1},
+		cache.KeyForCustom("group2"): {Field1: "value2", Field2: 2},
+	}, testValuesMap)
+	err = cache.DeleteGroup(ctx, []string{cache.KeyForCustom("group1"), cache.KeyForCustom("group2")})
+	assert.Nil(t, err)
+	err = cache.Read(ctx, cache.KeyForCustom("group1"), &testValueRead)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	// Test index definitions
+	type IndexedStruct struct {
+		Field1 string `json:"field1" redisearch:"TAG"`
+		Field2 int    `json:"field2" redisearch:"NUMERIC,SORTABLE"`
+	}
+	err = cache.Store(ctx, cache.KeyForCustom("path"), IndexedStruct{Field1: "value3", Field2: 3})
+	assert.Nil(t, err)
+	definition := IndexDefinition{Name: "test_index", Prefixes: []string{cache.KeyForCustom("path")}, Model: IndexedStruct{}}
+	indexName, err := cache.EnsureIndex(ctx, definition)
+	assert.Nil(t, err)
+	var searchResult []TestStruct
+	totalCount, err := cache.SearchInIndex(ctx, "test_index", "@field1:{value3}", nil, &searchResult)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, totalCount)
+	assert.Equal(t, []TestStruct{{Field1: "value3", Field2: 3}}, searchResult)
+	searchResult = nil
+	response, err := cache.SearchInIndexPaginated(ctx, "test_index", "*", pagination.FilteredPaginatedQuery{
+		PaginatedQuery: pagination.PaginatedQuery{PageSize: 25, Sort: "field2", Direction: "descending"},
+	}, []string{"field2"}, &searchResult)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, response.TotalCount)
+	assert.Len(t, searchResult, 1)
+	var aggregateResult []struct {
+		Field1 string `json:"field1"`
+		Count  int    `json:"count"`
+		Sum    int    `json:"sum"`
+	}
+	err = cache.Aggregate(ctx, "test_index", NewAggregation("*").GroupBy("field1").Reduce(ReduceCount("count"), ReduceSum("field2", "sum")), &aggregateResult)
+	assert.Nil(t, err)
+	assert.Len(t, aggregateResult, 1)
+	assert.Equal(t, "value3", aggregateResult[0].Field1)
+	assert.Equal(t, 1, aggregateResult[0].Count)
+	assert.Equal(t, 3, aggregateResult[0].Sum)
+	definition.Migration = IndexMigrationRecreate
+	definition.Model = TestStruct{}
+	_, err = cache.EnsureIndex(ctx, definition)
+	assert.ErrorIs(t, err, ErrInvalidIndexModel)
+	type IndexedStructV2 struct {
+		Field1 string `json:"field1" redisearch:"TEXT"`
+	}
+	definition.Model = IndexedStructV2{}
+	migratedIndexName, err := cache.EnsureIndex(ctx, definition)
+	assert.Nil(t, err)
+	assert.NotEqual(t, indexName, migratedIndexName)
+	err = cache.DeleteIndex(ctx, migratedIndexName, false)
+	assert.Nil(t, err)
+
+	// Test tags
+	expiration := time.Minute
+	err = cache.StoreWithTags(ctx, cache.KeyForCustom("tagged1"), TestStruct{Field1: "value1"}, &expiration, "tag1")
+	assert.Nil(t, err)
+	err = cache.StoreWithTags(ctx, cache.KeyForCustom("tagged2"), TestStruct{Field1: "value2"}, nil, "tag1", "tag2")
+	assert.Nil(t, err)
+	isMember, err := cache.IsItemInSet(ctx, cache.KeyForTag("tag2"), cache.KeyForCustom("tagged2"))
+	assert.Nil(t, err)
+	assert.True(t, isMember)
+	deletedCount, err := cache.InvalidateTags(ctx, "tag1", "tag2")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deletedCount)
+	err = cache.Read(ctx, cache.KeyForCustom("tagged2"), &testValueRead)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	isMember, err = cache.IsItemInSet(ctx, cache.KeyForTag("tag2"), cache.KeyForCustom("tagged2"))
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+
+	// Test read or compute
+	computeCount := 0
+	compute := func(ctx context.Context) (interface{}, error) {
+		computeCount++
+		return TestStruct{Field1: "computed", Field2: computeCount}, nil
+	}
+	err = cache.ReadOrCompute(ctx, cache.KeyForCustom("computed"), &testValueRead, &expiration, compute)
+	assert.Nil(t, err)
+	err = cache.ReadOrCompute(ctx, cache.KeyForCustom("computed"), &testValueRead, &expiration, compute)
+	assert.Nil(t, err)
+	assert.Equal(t, TestStruct{Field1: "computed", Field2: 1}, testValueRead)
+	assert.Equal(t, 1, computeCount)
+
+	// Test jobs
+	jobId, enqueued, err := cache.EnqueueJob(ctx, "retransmit", TestStruct{Field1: "job"}, time.Now(), "dedup")
+	assert.Nil(t, err)
+	assert.True(t, enqueued)
+	_, enqueued, err = cache.EnqueueJob(ctx, "retransmit", TestStruct{Field1: "job"}, time.Now(), "dedup")
+	assert.Nil(t, err)
+	assert.False(t, enqueued)
+	_, _, err = cache.EnqueueJob(ctx, "retransmit", TestStruct{Field1: "later"}, time.Now().Add(time.Hour), "")
+	assert.Nil(t, err)
+	jobs, err := cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, jobId, jobs[0].ID)
+		assert.Equal(t, 1, jobs[0].Attempt)
+		assert.Nil(t, jobs[0].Decode(&testValueRead))
+		assert.Equal(t, "job", testValueRead.Field1)
+		retryDelay := time.Duration(0)
+		assert.Nil(t, cache.RetryJob(ctx, jobs[0], &retryDelay))
+		retried, err := cache.ClaimJobs(ctx, "retransmit", 10)
+		assert.Nil(t, err)
+		assert.Len(t, retried, 1)
+		assert.ErrorIs(t, cache.CompleteJob(ctx, jobs[0]), ErrJobClaimLost)
+		assert.Nil(t, cache.CompleteJob(ctx, retried[0]))
+	}
+	jobs, err = cache.ClaimJobs(ctx, "retransmit", 10)
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+
+	// Test rate limiter
+	for _, algorithm := range []RateLimitAlgorithm{SlidingWindow, TokenBucket} {
+		limiter := NewRateLimiter(redisClient, "test", RateLimiterConfig{Algorithm: algorithm, Limit: RateLimit{Rate: 2, Period: time.Minute}})
+		for i := 0; i < 2; i++ {
+			result, err := limiter.Allow(ctx, "instrument")
+			assert.Nil(t, err)
+			assert.True(t, result.Allowed)
+		}
+		result, err := limiter.Allow(ctx, "instrument")
+		assert.Nil(t, err)
+		assert.False(t, result.Allowed)
+		assert.Greater(t, result.RetryAfter, 25*time.Second)
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		assert.ErrorIs(t, limiter.Wait(waitCtx, "instrument"), context.DeadlineExceeded)
+		cancel()
+	}
+
+	// Test idempotency
+	idempotencyStore := NewIdempotencyStore(redisClient, "test", IdempotencyConfig{})
+	record, err := idempotencyStore.Begin(ctx, "message", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	token := record.Token
+	record, err = NewIdempotencyStore(redisClient, "test", IdempotencyConfig{}).Begin(ctx, "message", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, record.Started)
+	assert.Equal(t, IdempotencyInProgress, record.State)
+	assert.Nil(t, idempotencyStore.Complete(ctx, "message", token, "ACK"))
+	record, err = idempotencyStore.Lookup(ctx, "message")
+	assert.Nil(t, err)
+	assert.Equal(t, IdempotencyCompleted, record.State)
+	assert.Equal(t, 1, record.Attempt)
+	assert.Equal(t, `"ACK"`, string(record.Result))
+	record, err = idempotencyStore.Begin(ctx, "expiring", time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	staleToken := record.Token
+	time.Sleep(5 * time.Millisecond)
+	record, err = NewIdempotencyStore(redisClient, "test", IdempotencyConfig{}).Begin(ctx, "expiring", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	assert.Equal(t, 2, record.Attempt)
+	assert.ErrorIs(t, idempotencyStore.Complete(ctx, "expiring", staleToken, "ACK"), ErrIdempotencyClaimLost)
+	assert.Nil(t, idempotencyStore.Complete(ctx, "expiring", record.Token, "ACK"))
+
+	// Test counters and sequences
+	counterValue, err := cache.IncrementCounter(ctx, "results", 2, &WallClock{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), counterValue)
+	counterValue, err = cache.IncrementCounter(ctx, "results", 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), counterValue)
+	ttl, err := redisClient.PTTL(ctx, "test:COUNTER:results").Result()
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+	assert.LessOrEqual(t, ttl, 24*time.Hour)
+	assert.Nil(t, cache.ResetCounter(ctx, "results"))
+	counterValue, err = cache.GetCounter(ctx, "results")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), counterValue)
+	sequenceSeed := func(ctx context.Context, sequence string, day time.Time) (int64, error) { return 99, nil }
+	sequenceNumber, err := cache.NextSequenceNumber(ctx, "run", sequenceSeed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), sequenceNumber.Number)
+	sequenceNumber, err = cache.NextSequenceNumber(ctx, "run", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(101), sequenceNumber.Number)
+
+	// Test snapshots
+	assert.Nil(t, cache.StoreWithTags(ctx, cache.KeyForCustom("snapshot"), TestStruct{Field1: "snapshot"}, nil, "snapshot"))
+	var snapshot bytes.Buffer
+	exportStats, err := cache.ExportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	assert.Positive(t, exportStats.Keys)
+	snapshotCache := NewRedisCache(redisClient, "snapshot")
+	snapshotCache.Init(RedisCacheConfig{}, nil, nil)
+	importStats, err := snapshotCache.ImportSnapshot(ctx, &snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, exportStats.Keys, importStats.Keys)
+	assert.Equal(t, exportStats.Indexes, importStats.Indexes)
+	snapshotCache.SetToValid(ctx)
+	var snapshotValue TestStruct
+	assert.Nil(t, snapshotCache.Read(ctx, snapshotCache.KeyForCustom("snapshot"), &snapshotValue))
+	assert.Equal(t, "snapshot", snapshotValue.Field1)
+	deletedCount, err = snapshotCache.InvalidateTags(ctx, "snapshot")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deletedCount)
+	assert.ErrorIs(t, snapshotCache.Read(ctx, snapshotCache.KeyForCustom("snapshot"), &snapshotValue), ErrItemNotFound)
+	assert.Nil(t, cache.Read(ctx, cache.KeyForCustom("snapshot"), &snapshotValue))
+
+	// Test administration
+	assert.Nil(t, cache.Store(ctx, cache.KeyForCustom("admin"), TestStruct{Field1: "admin"}))
+	keyCounts, err := cache.CountKeys(ctx)
+	assert.Nil(t, err)
+	assert.Positive(t, keyCounts[KeyCategoryCustom])
+	assert.Positive(t, keyCounts[KeyCategorySystem])
+	keyInfo, err := cache.InspectKey(ctx, cache.KeyForCustom("admin"))
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", keyInfo.Name)
+	assert.Equal(t, "ReJSON-RL", keyInfo.Type)
+	assert.Positive(t, keyInfo.Size)
+	listedKeys := make([]string, 0)
+	var keyCursor uint64
+	for {
+		keyPage, err := cache.ListKeys(ctx, KeyCategoryCustom, keyCursor, 10)
+		assert.Nil(t, err)
+		for _, info := range keyPage.Keys {
+			listedKeys = append(listedKeys, info.Key)
+		}
+		if keyCursor = keyPage.Cursor; err != nil || keyCursor == 0 {
+			break
+		}
+	}
+	assert.Contains(t, listedKeys, cache.KeyForCustom("admin"))
+	deletedKeyCount, err := cache.DeleteKeysByCategory(ctx, KeyCategoryCustom)
+	assert.Nil(t, err)
+	assert.Equal(t, keyCounts[KeyCategoryCustom], deletedKeyCount)
+	_, err = cache.InspectKey(ctx, cache.KeyForCustom("admin"))
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	// Test tenants
+	tenantCache, err := cache.WithTenant("berlin")
+	assert.Nil(t, err)
+	tenantCache.Init(redisConfig, nil, nil)
+	tenantCache.SetToValid(ctx)
+	assert.Nil(t, tenantCache.Store(ctx, tenantCache.KeyForCustom("tenant"), IndexedStruct{Field1: "berlin"}))
+	tenantIndexName, err := tenantCache.EnsureIndex(ctx, IndexDefinition{Name: "test_index", Prefixes: []string{tenantCache.KeyForCustom("tenant")}, Model: IndexedStruct{}})
+	assert.Nil(t, err)
+	assert.Equal(t, "test@berlin:test_index", tenantIndexName[:len(tenantIndexName)-indexSchemaHashLength-1])
+	_, err = cache.DeleteKeysByCategory(ctx, KeyCategoryCustom)
+	assert.Nil(t, err)
+	var tenantResult []IndexedStruct
+	totalCount, err = tenantCache.SearchInIndex(ctx, "test_index", "@field1:{berlin}", nil, &tenantResult)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, totalCount)
+	assert.Nil(t, tenantCache.DeleteIndex(ctx, tenantIndexName, true))
+
+	// Test refresh mutex ownership
+	mutexExpiration := time.Minute
+	lockingCache := NewRedisCache(redisClient, "locked").(*redisCache)
+	lockingCache.Init(RedisCacheConfig{MultiserverMode: true, MutexExpiration: &mutexExpiration}, nil, nil)
+	otherCache := NewRedisCache(redisClient, "locked").(*redisCache)
+	otherCache.Init(RedisCacheConfig{MultiserverMode: true, MutexExpiration: &mutexExpiration}, nil, nil)
+	assert.True(t, lockingCache.mutexTryLock(ctx))
+	assert.Nil(t, lockingCache.clearCache(ctx))
+	assert.False(t, otherCache.mutexTryLock(ctx))
+	otherCache.mutexUnlock(ctx)
+	assert.False(t, otherCache.mutexTryLock(ctx))
+	lockingCache.mutexUnlock(ctx)
+	assert.True(t, otherCache.mutexTryLock(ctx))
+	otherCache.mutexUnlock(ctx)
+
+	// Test rate limits kept on clear
+	lockedLimiter := NewRateLimiter(redisClient, "locked", RateLimiterConfig{Limit: RateLimit{Rate: 1, Period: time.Minute}})
+	result, err := lockedLimiter.Allow(ctx, "instrument")
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Nil(t, lockingCache.clearCache(ctx))
+	result, err = lockedLimiter.Allow(ctx, "instrument")
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+
+	// Test incremental refresh
+	incrementalCache := NewRedisCache(redisClient, "incremental")
+	incrementalCache.Init(RedisCacheConfig{
+		RefreshRetryAttempts: 1,
+		IncrementalRefreshFunc: func(ctx context.Context, checkpoint RefreshCheckpoint) (IncrementalChanges, error) {
+			return IncrementalChanges{
+				Upserts: map[string]interface{}{incrementalCache.KeyForCustom("upserted"): TestStruct{Field1: "upserted"}},
+				Deletes: []string{incrementalCache.KeyForCustom("full")},
+			}, nil
+		},
+	}, func(ctx context.Context) error {
+		return incrementalCache.Store(ctx, incrementalCache.KeyForCustom("full"), TestStruct{Field1: "full"})
+	}, nil)
+	refreshFinished := func() bool {
+		status := incrementalCache.RefreshStatus()
+		return !status.RefreshRunning && status.LastSucceededAt != nil
+	}
+	incrementalCache.RefreshCacheAsync(ctx, false)
+	assert.Eventually(t, refreshFinished, 5*time.Second, 10*time.Millisecond)
+	incrementalCache.RefreshCacheAsync(ctx, false)
+	assert.True(t, incrementalCache.IsValid(ctx))
+	assert.Eventually(t, func() bool {
+		return incrementalCache.Read(ctx, incrementalCache.KeyForCustom("upserted"), &testValueRead) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, refreshFinished, 5*time.Second, 10*time.Millisecond)
+	err = incrementalCache.Read(ctx, incrementalCache.KeyForCustom("full"), &testValueRead)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
+
+func TestRedisCacheJSONPaths(t *testing.T) {
+	ctx := context.Background()
+
+	// Setup test container
+	redisContainer, err := tcredis.Run(ctx, "redis:8.2.2")
+	assert.Nil(t, err)
+	defer func() {
+		err = testcontainers.TerminateContainer(redisContainer)
+		assert.Nil(t, err)
+	}()
+	url, err := redisContainer.ConnectionString(ctx)
+	assert.Nil(t, err)
+	opt, err := redis.ParseURL(url)
+	assert.Nil(t, err)
+	cache := NewRedisCache(redis.NewClient(opt), "test")
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	cache.SetToValid(ctx)
+
+	type PathStruct struct {
+		Field1 string `json:"field1"`
+		Field2 int    `json:"field2"`
+	}
+	err = cache.Store(ctx, cache.KeyForCustom("path"), PathStruct{Field1: "value1", Field2: 1})
+	assert.Nil(t, err)
+	err = cache.StorePath(ctx, cache.KeyForCustom("path"), "$.field1", "\"value2\"")
+	assert.Nil(t, err)
+	var field1 string
+	err = cache.ReadPath(ctx, cache.KeyForCustom("path"), "$.field1", &field1)
+	assert.Nil(t, err)
+	assert.Equal(t, "value2", field1)
+	field2, err := cache.IncrementNumber(ctx, cache.KeyForCustom("path"), "$.field2", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(3), field2)
+	err = cache.MergePath(ctx, cache.KeyForCustom("path"), "$", map[string]interface{}{"field1": "value3"})
+	assert.Nil(t, err)
+	var pathValueRead PathStruct
+	err = cache.Read(ctx, cache.KeyForCustom("path"), &pathValueRead)
+	assert.Nil(t, err)
+	assert.Equal(t, PathStruct{Field1: "value3", Field2: 3}, pathValueRead)
+	err = cache.ReadPath(ctx, cache.KeyForCustom("path"), "$.missing", &field1)
+	assert.ErrorIs(t, err, ErrPathNotFound)
+	err = cache.StorePath(ctx, cache.KeyForCustom("missing"), "$.field1", "\"value\"")
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
+
+func TestKeyPrefixByClientType(t *testing.T) {
+	singleNodeClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	cache := NewRedisCache(singleNodeClient, "test")
+	assert.Equal(t, "test:ALL", cache.KeyForAll())
+	assert.Equal(t, "test:abc:1|2", cache.KeyForValuedCustom("abc", "1", "2"))
+
+	clusterClient := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	cache = NewRedisCache(clusterClient, "test")
+	assert.Equal(t, "{test}:ALL", cache.KeyForAll())
+	assert.Equal(t, "{test}:abc:1|2", cache.KeyForValuedCustom("abc", "1", "2"))
+
+	var nilClient *redis.Client
+	cache = NewRedisCache(nilClient, "test")
+	cache.Init(RedisCacheConfig{}, nil, nil)
+	assert.ErrorIs(t, cache.Delete(context.Background(), cache.KeyForAll()), ErrNoClientSet)
 }
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/blutspende/bloodlab-common/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec defines how values are serialized and stored in redis
+type Codec interface {
+	Name() string
+	// UsesRedisJSON is true if values are stored as RedisJSON documents, which is required for indexes and searching
+	UsesRedisJSON() bool
+	Marshal(content interface{}) ([]byte, error)
+	Unmarshal(data []byte, modelPtr interface{}) error
+}
+
+var ErrCodecNotSearchable = errors.New("codec does not store RedisJSON documents, searching and indexing not supported")
+
+// NewRedisJSONCodec stores values as RedisJSON documents, this is the default codec
+func NewRedisJSONCodec() Codec {
+	return &redisJSONCodec{}
+}
+
+// NewJSONCodec stores values as plain JSON strings, RedisJSON module is not required
+func NewJSONCodec() Codec {
+	return &jsonCodec{}
+}
+
+// NewGzipJSONCodec stores values as gzip compressed JSON strings
+func NewGzipJSONCodec() Codec {
+	return &gzipJSONCodec{}
+}
+
+// NewZstdJSONCodec stores values as zstd compressed JSON strings
+func NewZstdJSONCodec() Codec {
+	// Note: creating encoder and decoder only fails with invalid options
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return &zstdJSONCodec{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+// NewMsgPackCodec stores values as MessagePack, using the json struct tags of the models
+func NewMsgPackCodec() Codec {
+	return &msgPackCodec{}
+}
+
+// RedisJSON
+
+type redisJSONCodec struct{}
+
+func (c *redisJSONCodec) Name() string {
+	return "redisjson"
+}
+func (c *redisJSONCodec) UsesRedisJSON() bool {
+	return true
+}
+func (c *redisJSONCodec) Marshal(content interface{}) ([]byte, error) {
+	return marshalContent(content)
+}
+func (c *redisJSONCodec) Unmarshal(data []byte, modelPtr interface{}) error {
+	return json.Unmarshal(data, modelPtr)
+}
+
+// JSON
+
+type jsonCodec struct{}
+
+func (c *jsonCodec) Name() string {
+	return "json"
+}
+func (c *jsonCodec) UsesRedisJSON() bool {
+	return false
+}
+func (c *jsonCodec) Marshal(content interface{}) ([]byte, error) {
+	return marshalContent(content)
+}
+func (c *jsonCodec) Unmarshal(data []byte, modelPtr interface{}) error {
+	return json.Unmarshal(data, modelPtr)
+}
+
+// Gzip JSON
+
+type gzipJSONCodec struct{}
+
+func (c *gzipJSONCodec) Name() string {
+	return "gzip+json"
+}
+func (c *gzipJSONCodec) UsesRedisJSON() bool {
+	return false
+}
+func (c *gzipJSONCodec) Marshal(content interface{}) ([]byte, error) {
+	payload, err := marshalContent(content)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err = writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+func (c *gzipJSONCodec) Unmarshal(data []byte, modelPtr interface{}) error {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, modelPtr)
+}
+
+// Zstd JSON
+
+type zstdJSONCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (c *zstdJSONCodec) Name() string {
+	return "zstd+json"
+}
+func (c *zstdJSONCodec) UsesRedisJSON() bool {
+	return false
+}
+func (c *zstdJSONCodec) Marshal(content interface{}) ([]byte, error) {
+	payload, err := marshalContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(payload, nil), nil
+}
+func (c *zstdJSONCodec) Unmarshal(data []byte, modelPtr interface{}) error {
+	payload, err := c.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, modelPtr)
+}
+
+// MessagePack
+
+type msgPackCodec struct{}
+
+func (c *msgPackCodec) Name() string {
+	return "msgpack"
+}
+func (c *msgPackCodec) UsesRedisJSON() bool {
+	return false
+}
+func (c *msgPackCodec) Marshal(content interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(content); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+func (c *msgPackCodec) Unmarshal(data []byte, modelPtr interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(modelPtr)
+}
+
+// Codec helpers of the cache
+
+func (c *redisCache) codec() Codec {
+	if c.config != nil && c.config.Codec != nil {
+		return c.config.Codec
+	}
+	return defaultCodec
+}
+
+var defaultCodec = NewRedisJSONCodec()
+
+// setValue stores the encoded payload with the command matching the codec, can be used with pipelines
+func (c *redisCache) setValue(ctx context.Context, cmdable redis.Cmdable, key string, payload []byte) redis.Cmder {
+	if c.codec().UsesRedisJSON() {
+		return cmdable.JSONSet(ctx, key, "$", payload)
+	}
+	return cmdable.Set(ctx, key, payload, redis.KeepTTL)
+}
+
+// getValue reads the encoded payload with the command matching the codec, with pipelines the returned
+// function must only be called after executing the pipeline
+func (c *redisCache) getValue(ctx context.Context, cmdable redis.Cmdable, key string) func() ([]byte, error) {
+	if c.codec().UsesRedisJSON() {
+		cmd := cmdable.JSONGet(ctx, key)
+		return func() ([]byte, error) {
+			result, err := cmd.Result()
+			return []byte(result), err
+		}
+	}
+	cmd := cmdable.Get(ctx, key)
+	return cmd.Bytes
+}
+
+// getValues reads the encoded payloads of multiple keys in chunks, missing keys result in nil entries
+func (c *redisCache) getValues(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	err := utils.Partition(len(keys), c.groupChunkSize(), func(low int, high int) error {
+		if c.codec().UsesRedisJSON() {
+			redisResult, err := c.redisClient.JSONMGet(ctx, "$", keys[low:high]...).Result()
+			if err != nil {
+				return err
+			}
+			for i := range redisResult {
+				if redisResult[i] == nil {
+					continue
+				}
+				// Note: JSON.MGET with JSONPath returns the matches of the path as array
+				var matches []json.RawMessage
+				if err = json.Unmarshal([]byte(redisResult[i].(string)), &matches); err != nil {
+					return err
+				}
+				if len(matches) > 0 {
+					values[low+i] = matches[0]
+				}
+			}
+			return nil
+		}
+		redisResult, err := c.redisClient.MGet(ctx, keys[low:high]...).Result()
+		if err != nil {
+			return err
+		}
+		for i := range redisResult {
+			if str, ok := redisResult[i].(string); ok {
+				values[low+i] = []byte(str)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// checkSearchable returns an error if the configured codec does not support RediSearch on JSON documents
+func (c *redisCache) checkSearchable() error {
+	if !c.codec().UsesRedisJSON() {
+		return fmt.Errorf("%w: %s", ErrCodecNotSearchable, c.codec().Name())
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	type TestStruct struct {
+		Field1 string   `json:"field1"`
+		Field2 int      `json:"field2"`
+		Field3 []string `json:"field3"`
+	}
+	testValue := TestStruct{
+		Field1: "value1",
+		Field2: 42,
+		Field3: []string{"a", "b"},
+	}
+	codecs := []Codec{NewRedisJSONCodec(), NewJSONCodec(), NewGzipJSONCodec(), NewZstdJSONCodec(), NewMsgPackCodec()}
+	for _, codec := range codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			payload, err := codec.Marshal(testValue)
+			assert.Nil(t, err)
+			var result TestStruct
+			err = codec.Unmarshal(payload, &result)
+			assert.Nil(t, err)
+			assert.Equal(t, testValue, result)
+		})
+	}
+}
+
+func TestCodecRawJSONContent(t *testing.T) {
+	for _, codec := range []Codec{NewRedisJSONCodec(), NewJSONCodec(), NewGzipJSONCodec(), NewZstdJSONCodec()} {
+		payload, err := codec.Marshal(`{"field1":"value1"}`)
+		assert.Nil(t, err)
+		var result map[string]string
+		err = codec.Unmarshal(payload, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, "value1", result["field1"])
+	}
+}
+
+func TestSearchNotSupportedByCodec(t *testing.T) {
+	cache := &redisCache{config: &RedisCacheConfig{Codec: NewGzipJSONCodec()}}
+	assert.ErrorIs(t, cache.checkSearchable(), ErrCodecNotSearchable)
+	cache.config.Codec = nil
+	assert.Nil(t, cache.checkSearchable())
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/internal/redisutil"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type IdempotencyState string
+
+const (
+	IdempotencyInProgress IdempotencyState = "IN_PROGRESS"
+	IdempotencyCompleted  IdempotencyState = "COMPLETED"
+)
+
+const defaultIdempotencyRetention = 24 * time.Hour
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must not be empty")
+	ErrIdempotencyNotStarted = errors.New("idempotency key was not started, the token of Begin is missing")
+	ErrIdempotencyClaimLost  = errors.New("idempotency key was reclaimed or completed by another processing")
+)
+
+type IdempotencyConfig struct {
+	// Retention of the records after the completion or after the expiration of the processing, defaults to 24h
+	Retention time.Duration
+}
+
+// IdempotencyRecord is the state of a key. Started and Token are only set by Begin, if the caller acquired the key
+// and must process the message and call Complete or Abort with the token.
+type IdempotencyRecord struct {
+	State   IdempotencyState
+	Started bool
+	// Token fences the processing, Complete and Abort fail with it after the processing was reclaimed
+	Token string
+	// Attempt is the number of acquisitions, it is higher than 1 if an expired processing was reclaimed
+	Attempt     int
+	StartedAt   time.Time
+	ExpiresAt   time.Time
+	CompletedAt *time.Time
+	Result      json.RawMessage
+}
+
+// Decode unmarshals the result of a completed record into resultPtr
+func (r IdempotencyRecord) Decode(resultPtr interface{}) error {
+	return json.Unmarshal(r.Result, resultPtr)
+}
+
+type IdempotencyStore interface {
+	Begin(ctx context.Context, key string, ttl time.Duration) (IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, token string, result interface{}) error
+	Abort(ctx context.Context, key string, token string) error
+	Lookup(ctx context.Context, key string) (IdempotencyRecord, error)
+}
+
+// A record is a hash with the fields state, token, attempt, startedAt, expiresAt, completedAt (unix milliseconds)
+// and result. The scripts use the time of the redis server.
+
+// beginIdempotencyScript acquires the key (KEYS[1]) with the token ARGV[1] for ARGV[2] milliseconds, unless it
+// is completed or in progress and not expired, and returns the record
+var beginIdempotencyScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HGET', KEYS[1], 'state')
+if state == 'COMPLETED' or (state == 'IN_PROGRESS' and tonumber(redis.call('HGET', KEYS[1], 'expiresAt')) > now) then
+	return redis.call('HGETALL', KEYS[1])
+end
+redis.call('HINCRBY', KEYS[1], 'attempt', 1)
+redis.call('HSET', KEYS[1], 'state', 'IN_PROGRESS', 'token', ARGV[1], 'startedAt', now, 'expiresAt', now + tonumber(ARGV[2]))
+redis.call('PEXPIRE', KEYS[1], tonumber(ARGV[2]) + tonumber(ARGV[3]))
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// completeIdempotencyScript stores the result ARGV[2] if the key is still in progress with the token ARGV[1]
+var completeIdempotencyScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') ~= 'IN_PROGRESS' or redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('HSET', KEYS[1], 'state', 'COMPLETED', 'result', ARGV[2], 'completedAt', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// abortIdempotencyScript expires the processing with the token ARGV[1], so the key can be acquired again
+var abortIdempotencyScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') ~= 'IN_PROGRESS' or redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('HSET', KEYS[1], 'expiresAt', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+type redisIdempotencyStore struct {
+	redisClient redis.UniversalClient
+	name        string
+	keyPrefix   string
+	config      IdempotencyConfig
+}
+
+// NewIdempotencyStore creates a store shared by all users of the redis with the same name, the keys are
+// prefixed like the keys of the cache
+func NewIdempotencyStore(redisClient redis.UniversalClient, name string, config IdempotencyConfig) IdempotencyStore {
+	redisClient = redisutil.Client(redisClient)
+	keyPrefix := redisutil.KeyPrefix(redisClient, name)
+	if config.Retention <= 0 {
+		config.Retention = defaultIdempotencyRetention
+	}
+	return &redisIdempotencyStore{
+		redisClient: redisClient,
+		name:        name,
+		keyPrefix:   keyPrefix,
+		config:      config,
+	}
+}
+
+// Begin atomically acquires the key for ttl, unless it is completed or another processing is in progress.
+// Processing which was neither completed nor aborted within ttl can be reclaimed by the next Begin.
+func (s *redisIdempotencyStore) Begin(ctx context.Context, key string, ttl time.Duration) (IdempotencyRecord, error) {
+	if err := s.check(ctx, key); err != nil {
+		return IdempotencyRecord{}, err
+	}
+	if ttl <= 0 {
+		return IdempotencyRecord{}, ErrExpirationNotSet
+	}
+	token := uuid.NewString()
+	fields, err := beginIdempotencyScript.Run(ctx, s.redisClient, []string{s.keyForIdempotency(key)}, token, ttl.Milliseconds(), s.config.Retention.Milliseconds()).StringSlice()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(s.fmtMsg("beginning idempotent processing failed"))
+		return IdempotencyRecord{}, err
+	}
+	record, recordToken := parseIdempotencyRecord(fields)
+	if recordToken == token {
+		record.Started = true
+		record.Token = token
+	}
+	return record, nil
+}
+
+// Complete stores the JSON encoded result for Retention, if the processing with the token of Begin was not
+// reclaimed
+func (s *redisIdempotencyStore) Complete(ctx context.Context, key string, token string, result interface{}) error {
+	if err := s.check(ctx, key); err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrIdempotencyNotStarted
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(s.fmtMsg("marshal result failed"))
+		return err
+	}
+	completed, err := completeIdempotencyScript.Run(ctx, s.redisClient, []string{s.keyForIdempotency(key)}, token, string(encoded), s.config.Retention.Milliseconds()).Int()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(s.fmtMsg("completing idempotent processing failed"))
+		return err
+	}
+	if completed == 0 {
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// Abort releases the processing with the token of Begin without result, e.g. after a failure, so the key can be
+// processed again
+func (s *redisIdempotencyStore) Abort(ctx context.Context, key string, token string) error {
+	if err := s.check(ctx, key); err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrIdempotencyNotStarted
+	}
+	aborted, err := abortIdempotencyScript.Run(ctx, s.redisClient, []string{s.keyForIdempotency(key)}, token, s.config.Retention.Milliseconds()).Int()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(s.fmtMsg("aborting idempotent processing failed"))
+		return err
+	}
+	if aborted == 0 {
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// Lookup returns the record of the key, or ErrItemNotFound
+func (s *redisIdempotencyStore) Lookup(ctx context.Context, key string) (IdempotencyRecord, error) {
+	if err := s.check(ctx, key); err != nil {
+		return IdempotencyRecord{}, err
+	}
+	fields, err := s.redisClient.HGetAll(ctx, s.keyForIdempotency(key)).Result()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(s.fmtMsg("looking up idempotency key failed"))
+		return IdempotencyRecord{}, err
+	}
+	if len(fields) == 0 {
+		return IdempotencyRecord{}, ErrItemNotFound
+	}
+	flat := make([]string, 0, 2*len(fields))
+	for field, value := range fields {
+		flat = append(flat, field, value)
+	}
+	record, _ := parseIdempotencyRecord(flat)
+	return record, nil
+}
+
+func (s *redisIdempotencyStore) check(ctx context.Context, key string) error {
+	if s.redisClient == nil {
+		log.Error().Ctx(ctx).Err(ErrNoClientSet).Msg(s.fmtMsg("no redis client"))
+		return ErrNoClientSet
+	}
+	if key == "" {
+		return ErrInvalidIdempotencyKey
+	}
+	return nil
+}
+
+func (s *redisIdempotencyStore) keyForIdempotency(key string) string {
+	return fmt.Sprintf("%s:IDEMPOTENCY:%s", s.keyPrefix, key)
+}
+
+func (s *redisIdempotencyStore) fmtMsg(message string) string {
+	return fmt.Sprintf("redisIdempotencyStore / %s: %s", s.name, message)
+}
+
+// parseIdempotencyRecord parses the flat field list of the hash and returns the record and its token
+func parseIdempotencyRecord(fields []string) (IdempotencyRecord, string) {
+	var record IdempotencyRecord
+	var token string
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		switch fields[i] {
+		case "state":
+			record.State = IdempotencyState(value)
+		case "token":
+			token = value
+		case "attempt":
+			record.Attempt, _ = strconv.Atoi(value)
+		case "startedAt":
+			record.StartedAt = parseUnixMilli(value)
+		case "expiresAt":
+			record.ExpiresAt = parseUnixMilli(value)
+		case "completedAt":
+			completedAt := parseUnixMilli(value)
+			record.CompletedAt = &completedAt
+		case "result":
+			record.Result = json.RawMessage(value)
+		}
+	}
+	return record, token
+}
+
+func parseUnixMilli(value string) time.Time {
+	milliseconds, _ := strconv.ParseInt(value, 10, 64)
+	return time.UnixMilli(milliseconds)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeIdempotencyStore is an in-memory IdempotencyStore for unit tests, using the clock set by SetClock
+type FakeIdempotencyStore interface {
+	IdempotencyStore
+	SetClock(now func() time.Time)
+}
+
+type fakeIdempotencyRecord struct {
+	record    IdempotencyRecord
+	token     string
+	deletedAt time.Time
+}
+
+type fakeIdempotencyStore struct {
+	mutex   *sync.Mutex
+	config  IdempotencyConfig
+	now     func() time.Time
+	records map[string]*fakeIdempotencyRecord
+}
+
+func NewFakeIdempotencyStore(config IdempotencyConfig) FakeIdempotencyStore {
+	if config.Retention <= 0 {
+		config.Retention = defaultIdempotencyRetention
+	}
+	return &fakeIdempotencyStore{
+		mutex:   &sync.Mutex{},
+		config:  config,
+		now:     time.Now,
+		records: make(map[string]*fakeIdempotencyRecord),
+	}
+}
+
+func (s *fakeIdempotencyStore) SetClock(now func() time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = now
+}
+
+func (s *fakeIdempotencyStore) Begin(ctx context.Context, key string, ttl time.Duration) (IdempotencyRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if key == "" {
+		return IdempotencyRecord{}, ErrInvalidIdempotencyKey
+	}
+	if ttl <= 0 {
+		return IdempotencyRecord{}, ErrExpirationNotSet
+	}
+	now := s.now()
+	existing := s.record(key)
+	if existing != nil && (existing.record.State == IdempotencyCompleted || existing.record.ExpiresAt.After(now)) {
+		return existing.record, nil
+	}
+	if existing == nil {
+		existing = &fakeIdempotencyRecord{}
+		s.records[key] = existing
+	}
+	existing.token = uuid.NewString()
+	existing.record.State = IdempotencyInProgress
+	existing.record.Attempt++
+	existing.record.StartedAt = time.UnixMilli(now.UnixMilli())
+	existing.record.ExpiresAt = existing.record.StartedAt.Add(ttl)
+	existing.deletedAt = existing.record.ExpiresAt.Add(s.config.Retention)
+	record := existing.record
+	record.Started = true
+	record.Token = existing.token
+	return record, nil
+}
+
+func (s *fakeIdempotencyStore) Complete(ctx context.Context, key string, token string, result interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, err := s.started(key, token)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	completedAt := time.UnixMilli(s.now().UnixMilli())
+	existing.record.State = IdempotencyCompleted
+	existing.record.CompletedAt = &completedAt
+	existing.record.Result = encoded
+	existing.deletedAt = completedAt.Add(s.config.Retention)
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Abort(ctx context.Context, key string, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, err := s.started(key, token)
+	if err != nil {
+		return err
+	}
+	existing.record.ExpiresAt = time.UnixMilli(s.now().UnixMilli())
+	existing.deletedAt = existing.record.ExpiresAt.Add(s.config.Retention)
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Lookup(ctx context.Context, key string) (IdempotencyRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if key == "" {
+		return IdempotencyRecord{}, ErrInvalidIdempotencyKey
+	}
+	existing := s.record(key)
+	if existing == nil {
+		return IdempotencyRecord{}, ErrItemNotFound
+	}
+	return existing.record, nil
+}
+
+// record returns the record of the key, removing it after the retention, the mutex must be held
+func (s *fakeIdempotencyStore) record(key string) *fakeIdempotencyRecord {
+	existing, ok := s.records[key]
+	if ok && !existing.deletedAt.After(s.now()) {
+		delete(s.records, key)
+		return nil
+	}
+	return existing
+}
+
+// started returns the record in progress with the token, the mutex must be held
+func (s *fakeIdempotencyStore) started(key string, token string) (*fakeIdempotencyRecord, error) {
+	if key == "" {
+		return nil, ErrInvalidIdempotencyKey
+	}
+	if token == "" {
+		return nil, ErrIdempotencyNotStarted
+	}
+	existing := s.record(key)
+	if existing == nil || existing.record.State != IdempotencyInProgress || existing.token != token {
+		return nil, ErrIdempotencyClaimLost
+	}
+	return existing, nil
+}
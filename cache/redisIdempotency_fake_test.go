@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewFakeIdempotencyStore(IdempotencyConfig{Retention: time.Hour})
+	store.SetClock(func() time.Time { return now })
+
+	// The first Begin acquires the key, duplicates see the processing in progress
+	_, err := store.Lookup(ctx, "message:1")
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	record, err := store.Begin(ctx, "message:1", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	assert.NotEmpty(t, record.Token)
+	assert.Equal(t, IdempotencyInProgress, record.State)
+	assert.Equal(t, 1, record.Attempt)
+	assert.True(t, now.Add(time.Minute).Equal(record.ExpiresAt))
+	token := record.Token
+	record, err = store.Begin(ctx, "message:1", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, record.Started)
+	assert.Empty(t, record.Token)
+	assert.Equal(t, IdempotencyInProgress, record.State)
+
+	// Completed keys return the result
+	assert.ErrorIs(t, store.Complete(ctx, "message:1", "", "ACK"), ErrIdempotencyNotStarted)
+	assert.Nil(t, store.Complete(ctx, "message:1", token, "ACK"))
+	assert.ErrorIs(t, store.Complete(ctx, "message:1", token, "ACK"), ErrIdempotencyClaimLost)
+	record, err = store.Begin(ctx, "message:1", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, record.Started)
+	assert.Equal(t, IdempotencyCompleted, record.State)
+	var result string
+	assert.Nil(t, record.Decode(&result))
+	assert.Equal(t, "ACK", result)
+	record, err = store.Lookup(ctx, "message:1")
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(*record.CompletedAt))
+
+	// Expired processing is reclaimed, the late completion and abort of the first attempt are rejected
+	record, err = store.Begin(ctx, "message:2", time.Minute)
+	assert.Nil(t, err)
+	staleToken := record.Token
+	now = now.Add(2 * time.Minute)
+	record, err = store.Begin(ctx, "message:2", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	assert.Equal(t, 2, record.Attempt)
+	assert.NotEqual(t, staleToken, record.Token)
+	assert.ErrorIs(t, store.Complete(ctx, "message:2", staleToken, "ACK"), ErrIdempotencyClaimLost)
+	assert.ErrorIs(t, store.Abort(ctx, "message:2", staleToken), ErrIdempotencyClaimLost)
+
+	// Aborted processing can be acquired again immediately
+	assert.Nil(t, store.Abort(ctx, "message:2", record.Token))
+	record, err = store.Begin(ctx, "message:2", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, record.Started)
+	assert.Equal(t, 3, record.Attempt)
+
+	// Records are removed after the retention
+	now = now.Add(2 * time.Hour)
+	_, err = store.Lookup(ctx, "message:1")
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	_, err = store.Begin(ctx, "", time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidIdempotencyKey)
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/internal/redisutil"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type RateLimitAlgorithm int
+
+const (
+	// SlidingWindow allows Rate requests within any Period, keeping a log of the requests of the last Period
+	SlidingWindow RateLimitAlgorithm = iota
+	// TokenBucket spaces requests evenly by Period / Rate, allowing bursts of up to Burst requests (GCRA)
+	TokenBucket
+)
+
+var (
+	ErrInvalidRateLimit  = errors.New("rate limit must have a positive rate and period")
+	ErrInvalidLimiterKey = errors.New("rate limiter key must not be empty")
+)
+
+type RateLimit struct {
+	Rate   int
+	Period time.Duration
+	// Burst is the number of requests allowed at once by the TokenBucket algorithm, defaults to Rate
+	Burst int
+}
+
+type RateLimiterConfig struct {
+	Algorithm RateLimitAlgorithm
+	// Limit applies to all keys without an entry in KeyLimits
+	Limit     RateLimit
+	KeyLimits map[string]RateLimit
+}
+
+// RateLimitResult is the result of Allow, RetryAfter is the time until a request can be allowed
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+	Wait(ctx context.Context, key string) error
+}
+
+// The scripts use the time of the redis server, so the limits do not depend on the clocks of the replicas.
+
+// slidingWindowScript allows the request if less than ARGV[1] requests were logged in the last ARGV[2]
+// milliseconds, returning allowed, remaining and retry after in milliseconds
+var slidingWindowScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], period)
+	return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, math.max(1, tonumber(oldest[2]) + period - now)}
+`)
+
+// tokenBucketScript implements GCRA with the emission interval ARGV[1] and the burst ARGV[2], storing the
+// theoretical arrival time in milliseconds, returning allowed, remaining and retry after in milliseconds
+var tokenBucketScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+local interval = tonumber(ARGV[1])
+local tolerance = interval * tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, 0, math.max(1, math.ceil(allowAt - now))}
+end
+redis.call('SET', KEYS[1], string.format('%.3f', newTat), 'PX', math.max(1, math.ceil(newTat - now)))
+return {1, math.floor((now - allowAt) / interval), 0}
+`)
+
+type redisRateLimiter struct {
+	redisClient redis.UniversalClient
+	name        string
+	keyPrefix   string
+	config      RateLimiterConfig
+}
+
+// NewRateLimiter creates a rate limiter shared by all users of the redis with the same name. Like the keys of
+// the cache, the keys are prefixed with the name and share one hash slot on a cluster client.
+func NewRateLimiter(redisClient redis.UniversalClient, name string, config RateLimiterConfig) RateLimiter {
+	redisClient = redisutil.Client(redisClient)
+	keyPrefix := redisutil.KeyPrefix(redisClient, name)
+	return &redisRateLimiter{
+		redisClient: redisClient,
+		name:        name,
+		keyPrefix:   keyPrefix,
+		config:      config,
+	}
+}
+
+// Allow counts the request if it is within the limit of the key, it does not wait
+func (l *redisRateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	if l.redisClient == nil {
+		log.Error().Ctx(ctx).Err(ErrNoClientSet).Msg(l.fmtMsg("no redis client"))
+		return RateLimitResult{}, ErrNoClientSet
+	}
+	limit, err := rateLimitFor(l.config, key)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	var values []int64
+	switch l.config.Algorithm {
+	case TokenBucket:
+		interval := float64(limit.Period.Microseconds()) / 1000 / float64(limit.Rate)
+		values, err = tokenBucketScript.Run(ctx, l.redisClient, []string{l.keyForLimit("GCRA", key)}, interval, limit.Burst).Int64Slice()
+	default:
+		values, err = slidingWindowScript.Run(ctx, l.redisClient, []string{l.keyForLimit("SLIDING", key)}, limit.Rate, limit.Period.Milliseconds(), uuid.NewString()).Int64Slice()
+	}
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("key", key).Msg(l.fmtMsg("checking rate limit failed"))
+		return RateLimitResult{}, err
+	}
+	if len(values) != 3 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script result %v", values)
+	}
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+// Wait blocks until the request is allowed, or returns the error of the context
+func (l *redisRateLimiter) Wait(ctx context.Context, key string) error {
+	return waitForRateLimit(ctx, key, l.Allow, sleepWithContext)
+}
+
+func (l *redisRateLimiter) keyForLimit(algorithm string, key string) string {
+	return fmt.Sprintf("%s:RATE:%s:%s", l.keyPrefix, algorithm, key)
+}
+
+func (l *redisRateLimiter) fmtMsg(message string) string {
+	return fmt.Sprintf("redisRateLimiter / %s: %s", l.name, message)
+}
+
+// rateLimitFor returns the validated limit of the key with the default burst
+func rateLimitFor(config RateLimiterConfig, key string) (RateLimit, error) {
+	if key == "" {
+		return RateLimit{}, ErrInvalidLimiterKey
+	}
+	limit, ok := config.KeyLimits[key]
+	if !ok {
+		limit = config.Limit
+	}
+	if limit.Rate <= 0 || limit.Period <= 0 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	return limit, nil
+}
+
+// waitForRateLimit retries allow after the returned RetryAfter until the request is allowed
+func waitForRateLimit(ctx context.Context, key string, allow func(ctx context.Context, key string) (RateLimitResult, error), sleep func(ctx context.Context, duration time.Duration) error) error {
+	for {
+		result, err := allow(ctx, key)
+		if err != nil {
+			return err
+		}
+		if result.Allowed {
+			return nil
+		}
+		if err = sleep(ctx, max(result.RetryAfter, time.Millisecond)); err != nil {
+			return err
+		}
+	}
+}
+
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FakeRateLimiter is an in-memory RateLimiter for unit tests. It implements the same algorithms with the clock
+// set by SetClock, and Wait sleeps with the function set by SetSleep, e.g. to advance the clock instead.
+type FakeRateLimiter interface {
+	RateLimiter
+	SetClock(now func() time.Time)
+	SetSleep(sleep func(ctx context.Context, duration time.Duration) error)
+}
+
+type fakeRateLimiter struct {
+	mutex  *sync.Mutex
+	config RateLimiterConfig
+	now    func() time.Time
+	sleep  func(ctx context.Context, duration time.Duration) error
+	logs   map[string][]time.Time
+	tats   map[string]time.Time
+}
+
+func NewFakeRateLimiter(config RateLimiterConfig) FakeRateLimiter {
+	return &fakeRateLimiter{
+		mutex:  &sync.Mutex{},
+		config: config,
+		now:    time.Now,
+		sleep:  sleepWithContext,
+		logs:   make(map[string][]time.Time),
+		tats:   make(map[string]time.Time),
+	}
+}
+
+func (l *fakeRateLimiter) SetClock(now func() time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.now = now
+}
+func (l *fakeRateLimiter) SetSleep(sleep func(ctx context.Context, duration time.Duration) error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sleep = sleep
+}
+
+func (l *fakeRateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	limit, err := rateLimitFor(l.config, key)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	now := l.now()
+	if l.config.Algorithm == TokenBucket {
+		interval := limit.Period / time.Duration(limit.Rate)
+		tat := l.tats[key]
+		if tat.Before(now) {
+			tat = now
+		}
+		allowAt := tat.Add(interval).Add(-interval * time.Duration(limit.Burst))
+		if allowAt.After(now) {
+			return RateLimitResult{RetryAfter: allowAt.Sub(now)}, nil
+		}
+		l.tats[key] = tat.Add(interval)
+		return RateLimitResult{Allowed: true, Remaining: int(now.Sub(allowAt) / interval)}, nil
+	}
+	requests := make([]time.Time, 0, len(l.logs[key]))
+	for _, request := range l.logs[key] {
+		if request.After(now.Add(-limit.Period)) {
+			requests = append(requests, request)
+		}
+	}
+	l.logs[key] = requests
+	if len(requests) < limit.Rate {
+		l.logs[key] = append(requests, now)
+		return RateLimitResult{Allowed: true, Remaining: limit.Rate - len(requests) - 1}, nil
+	}
+	return RateLimitResult{RetryAfter: requests[0].Add(limit.Period).Sub(now)}, nil
+}
+
+func (l *fakeRateLimiter) Wait(ctx context.Context, key string) error {
+	l.mutex.Lock()
+	sleep := l.sleep
+	l.mutex.Unlock()
+	return waitForRateLimit(ctx, key, l.Allow, sleep)
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeRateLimiterSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewFakeRateLimiter(RateLimiterConfig{
+		Algorithm: SlidingWindow,
+		Limit:     RateLimit{Rate: 2, Period: time.Second},
+		KeyLimits: map[string]RateLimit{"instrument:slow": {Rate: 1, Period: time.Minute}},
+	})
+	limiter.SetClock(func() time.Time { return now })
+
+	result, err := limiter.Allow(ctx, "instrument:1")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 1}, result)
+	now = now.Add(400 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "instrument:1")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 0}, result)
+	result, err = limiter.Allow(ctx, "instrument:1")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitResult{RetryAfter: 600 * time.Millisecond}, result)
+
+	// The window slides, keys are limited independently
+	now = now.Add(600 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "instrument:1")
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	result, err = limiter.Allow(ctx, "instrument:slow")
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	result, err = limiter.Allow(ctx, "instrument:slow")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+
+	// Wait sleeps until allowed
+	var slept time.Duration
+	limiter.SetSleep(func(ctx context.Context, duration time.Duration) error {
+		slept += duration
+		now = now.Add(duration)
+		return nil
+	})
+	assert.Nil(t, limiter.Wait(ctx, "instrument:slow"))
+	assert.Equal(t, time.Minute, slept)
+
+	_, err = limiter.Allow(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidLimiterKey)
+	_, err = NewFakeRateLimiter(RateLimiterConfig{}).Allow(ctx, "instrument:1")
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+}
+
+func TestFakeRateLimiterTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewFakeRateLimiter(RateLimiterConfig{
+		Algorithm: TokenBucket,
+		Limit:     RateLimit{Rate: 10, Period: time.Second, Burst: 3},
+	})
+	limiter.SetClock(func() time.Time { return now })
+
+	// A burst is allowed at once, then requests are spaced by the emission interval
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := limiter.Allow(ctx, "api")
+		assert.Nil(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Remaining: remaining}, result)
+	}
+	result, err := limiter.Allow(ctx, "api")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitResult{RetryAfter: 100 * time.Millisecond}, result)
+	now = now.Add(100 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "api")
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+
+	// Wait returns the error of the context
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, limiter.Wait(cancelledCtx, "api"), context.Canceled)
+}
@@ -0,0 +1,29 @@
+package redisutil
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Client returns nil for a nil client and for an interface holding a nil pointer, otherwise the client
+func Client(redisClient redis.UniversalClient) redis.UniversalClient {
+	if redisClient == nil {
+		return nil
+	}
+	v := reflect.ValueOf(redisClient)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	return redisClient
+}
+
+// KeyPrefix returns the prefix of the keys named by name, on a cluster client the name is a hash tag, so all keys
+// with the prefix share one hash slot
+func KeyPrefix(redisClient redis.UniversalClient, name string) string {
+	if _, isCluster := redisClient.(*redis.ClusterClient); isCluster {
+		return fmt.Sprintf("{%s}", name)
+	}
+	return name
+}
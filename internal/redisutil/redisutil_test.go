@@ -0,0 +1,22 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	var nilClient *redis.Client
+	assert.Nil(t, Client(nil))
+	assert.Nil(t, Client(nilClient))
+	singleNodeClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	assert.Equal(t, singleNodeClient, Client(singleNodeClient))
+}
+
+func TestKeyPrefix(t *testing.T) {
+	assert.Equal(t, "test", KeyPrefix(nil, "test"))
+	assert.Equal(t, "test", KeyPrefix(redis.NewClient(&redis.Options{Addr: "localhost:6379"}), "test"))
+	assert.Equal(t, "{test}", KeyPrefix(redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}}), "test"))
+}
@@ -0,0 +1,452 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/instrumentenum"
+	"github.com/blutspende/bloodlab-common/internal/redisutil"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type EventBus interface {
+	Publish(ctx context.Context, stream string, messageType instrumentenum.MessageType, status instrumentenum.MessageStatus, payload interface{}) (id string, err error)
+	EnsureGroup(ctx context.Context, stream string, group string) error
+	Poll(ctx context.Context, stream string, group string, handler Handler) (handled int, err error)
+	Consume(ctx context.Context, stream string, group string, handler Handler) error
+	Messages(ctx context.Context, stream string, count int64) ([]Message, error)
+	DeadLetterStream(stream string) string
+}
+
+// Message is an entry of a stream with a JSON payload
+type Message struct {
+	ID      string
+	Stream  string
+	Type    instrumentenum.MessageType
+	Status  instrumentenum.MessageStatus
+	Payload json.RawMessage
+	// Deliveries to consumers of the group including the current one, higher than 1 for reclaimed messages
+	Deliveries int64
+	// OriginalID and Error are only set for messages of a dead-letter stream
+	OriginalID string
+	Error      string
+}
+
+// Decode unmarshals the payload into modelPtr
+func (m Message) Decode(modelPtr interface{}) error {
+	return json.Unmarshal(m.Payload, modelPtr)
+}
+
+// Handler processes a message, the message is acknowledged if it returns nil
+type Handler func(ctx context.Context, message Message) error
+
+type EventBusConfig struct {
+	// MaxLength of the streams, older entries are trimmed approximately when publishing, 0 disables trimming
+	MaxLength int64
+	// Consumer name within the groups, defaults to the hostname and a random suffix
+	Consumer string
+	// BatchSize of messages read per poll, defaults to 10
+	BatchSize int64
+	// BlockTimeout of a poll waiting for new messages, defaults to 5s
+	BlockTimeout time.Duration
+	// ClaimIdleTimeout after which unacknowledged messages of any consumer are reclaimed, defaults to 1m
+	ClaimIdleTimeout time.Duration
+	// MaxDeliveries after which a failing message is moved to the dead-letter stream, defaults to 5
+	MaxDeliveries int64
+}
+
+const (
+	defaultBatchSize        = 10
+	defaultBlockTimeout     = 5 * time.Second
+	defaultClaimIdleTimeout = time.Minute
+	defaultMaxDeliveries    = 5
+	errorBackoff            = time.Second
+	deadLetterSuffix        = ":DEAD_LETTER"
+)
+
+var (
+	ErrNoClientSet    = errors.New("no redis client set")
+	ErrInvalidStream  = errors.New("stream name must not be empty")
+	ErrInvalidGroup   = errors.New("group name must not be empty")
+	ErrGroupNotFound  = errors.New("consumer group not found")
+	ErrInvalidMessage = errors.New("invalid stream message")
+)
+
+const (
+	fieldType       = "type"
+	fieldStatus     = "status"
+	fieldPayload    = "payload"
+	fieldOriginalID = "originalId"
+	fieldError      = "error"
+	fieldDeliveries = "deliveries"
+)
+
+type eventBus struct {
+	redisClient redis.UniversalClient
+	name        string
+	keyPrefix   string
+	config      EventBusConfig
+	mutex       *sync.Mutex
+	claimCursor map[string]string
+}
+
+// NewEventBus creates an event bus on the streams of the client, stream keys are prefixed with the name. On a
+// cluster client all streams of the bus share one hash slot.
+func NewEventBus(redisClient redis.UniversalClient, name string, config EventBusConfig) EventBus {
+	redisClient = redisutil.Client(redisClient)
+	keyPrefix := redisutil.KeyPrefix(redisClient, name)
+	return &eventBus{
+		redisClient: redisClient,
+		name:        name,
+		keyPrefix:   keyPrefix,
+		config:      withDefaults(config),
+		mutex:       &sync.Mutex{},
+		claimCursor: make(map[string]string),
+	}
+}
+
+func withDefaults(config EventBusConfig) EventBusConfig {
+	if config.Consumer == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "consumer"
+		}
+		config.Consumer = fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = defaultBlockTimeout
+	}
+	if config.ClaimIdleTimeout <= 0 {
+		config.ClaimIdleTimeout = defaultClaimIdleTimeout
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = defaultMaxDeliveries
+	}
+	return config
+}
+
+// Publish adds a message with the JSON encoded payload to the stream
+func (b *eventBus) Publish(ctx context.Context, stream string, messageType instrumentenum.MessageType, status instrumentenum.MessageStatus, payload interface{}) (string, error) {
+	if err := b.check(stream); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg(b.fmtMsg("encoding payload failed"))
+		return "", err
+	}
+	id, err := b.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.keyForStream(stream),
+		MaxLen: b.config.MaxLength,
+		Approx: b.config.MaxLength > 0,
+		Values: []interface{}{fieldType, string(messageType), fieldStatus, string(status), fieldPayload, string(encoded)},
+	}).Result()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("stream", stream).Msg(b.fmtMsg("publishing message failed"))
+		return "", err
+	}
+	return id, nil
+}
+
+// EnsureGroup creates the consumer group reading the stream from the beginning, and the stream if it does not
+// exist, an existing group is left unchanged
+func (b *eventBus) EnsureGroup(ctx context.Context, stream string, group string) error {
+	if err := b.checkGroup(stream, group); err != nil {
+		return err
+	}
+	err := b.redisClient.XGroupCreateMkStream(ctx, b.keyForStream(stream), group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Error().Ctx(ctx).Err(err).Str("stream", stream).Str("group", group).Msg(b.fmtMsg("creating consumer group failed"))
+		return err
+	}
+	return nil
+}
+
+// Poll reclaims messages idle for longer than ClaimIdleTimeout, then reads new messages, waiting up to
+// BlockTimeout if nothing was reclaimed, and passes them to the handler
+func (b *eventBus) Poll(ctx context.Context, stream string, group string, handler Handler) (int, error) {
+	if err := b.checkGroup(stream, group); err != nil {
+		return 0, err
+	}
+	key := b.keyForStream(stream)
+	claimed, err := b.reclaim(ctx, stream, group)
+	if err != nil {
+		return 0, err
+	}
+	block := b.config.BlockTimeout
+	if len(claimed) > 0 {
+		block = -1
+	}
+	results, err := b.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: b.config.Consumer,
+		Streams:  []string{key, ">"},
+		Count:    b.config.BatchSize,
+		Block:    block,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, b.groupErr(ctx, err, stream, group, "reading messages failed")
+	}
+	messages := claimed
+	for _, result := range results {
+		for _, entry := range result.Messages {
+			message, err := parseMessage(stream, entry)
+			if err != nil {
+				log.Warn().Ctx(ctx).Err(err).Str("id", entry.ID).Msg(b.fmtMsg("acknowledging invalid message"))
+				b.ack(ctx, stream, group, entry.ID)
+				continue
+			}
+			message.Deliveries = 1
+			messages = append(messages, message)
+		}
+	}
+	for _, message := range messages {
+		processMessage(ctx, b, b.config, group, message, handler)
+	}
+	return len(messages), nil
+}
+
+// reclaim claims the idle pending messages of the group with XAUTOCLAIM and reads their delivery counts
+func (b *eventBus) reclaim(ctx context.Context, stream string, group string) ([]Message, error) {
+	key := b.keyForStream(stream)
+	cursorKey := key + "\x00" + group
+	b.mutex.Lock()
+	start, ok := b.claimCursor[cursorKey]
+	b.mutex.Unlock()
+	if !ok {
+		start = "0-0"
+	}
+	entries, next, err := b.redisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   key,
+		Group:    group,
+		MinIdle:  b.config.ClaimIdleTimeout,
+		Start:    start,
+		Count:    b.config.BatchSize,
+		Consumer: b.config.Consumer,
+	}).Result()
+	if err != nil {
+		return nil, b.groupErr(ctx, err, stream, group, "reclaiming messages failed")
+	}
+	b.mutex.Lock()
+	b.claimCursor[cursorKey] = next
+	b.mutex.Unlock()
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	pending, err := b.redisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   key,
+		Group:    group,
+		Start:    entries[0].ID,
+		End:      entries[len(entries)-1].ID,
+		Count:    int64(len(entries)),
+		Consumer: b.config.Consumer,
+	}).Result()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("stream", stream).Msg(b.fmtMsg("reading delivery counts failed"))
+		return nil, err
+	}
+	deliveries := make(map[string]int64, len(pending))
+	for _, entry := range pending {
+		deliveries[entry.ID] = entry.RetryCount
+	}
+	messages := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		message, err := parseMessage(stream, entry)
+		if err != nil {
+			// Note: entries deleted by trimming are returned without fields
+			b.ack(ctx, stream, group, entry.ID)
+			continue
+		}
+		message.Deliveries = deliveries[entry.ID]
+		messages = append(messages, message)
+	}
+	log.Debug().Ctx(ctx).Str("stream", stream).Int("count", len(messages)).Msg(b.fmtMsg("messages reclaimed"))
+	return messages, nil
+}
+
+// Consume creates the group if needed and polls until ctx is cancelled, errors are logged and retried
+func (b *eventBus) Consume(ctx context.Context, stream string, group string, handler Handler) error {
+	if err := b.EnsureGroup(ctx, stream, group); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		if _, err := b.Poll(ctx, stream, group, handler); err != nil && ctx.Err() == nil {
+			log.Warn().Ctx(ctx).Err(err).Str("stream", stream).Str("group", group).Msg(b.fmtMsg("polling failed"))
+			select {
+			case <-ctx.Done():
+			case <-time.After(errorBackoff):
+			}
+		}
+	}
+	return nil
+}
+
+// Messages returns up to count of the oldest messages of the stream (all if count is 0), e.g. to inspect a
+// dead-letter stream
+func (b *eventBus) Messages(ctx context.Context, stream string, count int64) ([]Message, error) {
+	if err := b.check(stream); err != nil {
+		return nil, err
+	}
+	var entries []redis.XMessage
+	var err error
+	if count > 0 {
+		entries, err = b.redisClient.XRangeN(ctx, b.keyForStream(stream), "-", "+", count).Result()
+	} else {
+		entries, err = b.redisClient.XRange(ctx, b.keyForStream(stream), "-", "+").Result()
+	}
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("stream", stream).Msg(b.fmtMsg("reading messages failed"))
+		return nil, err
+	}
+	messages := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		message, err := parseMessage(stream, entry)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// DeadLetterStream is the name of the stream receiving the messages exceeding MaxDeliveries
+func (b *eventBus) DeadLetterStream(stream string) string {
+	return stream + deadLetterSuffix
+}
+
+func (b *eventBus) ack(ctx context.Context, stream string, group string, id string) {
+	err := b.redisClient.XAck(ctx, b.keyForStream(stream), group, id).Err()
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("stream", stream).Str("id", id).Msg(b.fmtMsg("acknowledging message failed"))
+	}
+}
+
+// deadLetter adds the message to the dead-letter stream and acknowledges it in one transaction
+func (b *eventBus) deadLetter(ctx context.Context, group string, message Message, cause string) {
+	pipeline := b.redisClient.TxPipeline()
+	pipeline.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.keyForStream(b.DeadLetterStream(message.Stream)),
+		MaxLen: b.config.MaxLength,
+		Approx: b.config.MaxLength > 0,
+		Values: deadLetterValues(message, cause),
+	})
+	pipeline.XAck(ctx, b.keyForStream(message.Stream), group, message.ID)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("stream", message.Stream).Str("id", message.ID).Msg(b.fmtMsg("moving message to dead-letter stream failed"))
+	}
+}
+
+func (b *eventBus) check(stream string) error {
+	if b.redisClient == nil {
+		log.Error().Err(ErrNoClientSet).Msg(b.fmtMsg("no redis client"))
+		return ErrNoClientSet
+	}
+	if stream == "" {
+		return ErrInvalidStream
+	}
+	return nil
+}
+
+func (b *eventBus) checkGroup(stream string, group string) error {
+	if err := b.check(stream); err != nil {
+		return err
+	}
+	if group == "" {
+		return ErrInvalidGroup
+	}
+	return nil
+}
+
+// groupErr logs the error and maps missing groups to ErrGroupNotFound
+func (b *eventBus) groupErr(ctx context.Context, err error, stream string, group string, message string) error {
+	if strings.HasPrefix(err.Error(), "NOGROUP") {
+		return ErrGroupNotFound
+	}
+	log.Error().Ctx(ctx).Err(err).Str("stream", stream).Str("group", group).Msg(b.fmtMsg(message))
+	return err
+}
+
+func (b *eventBus) keyForStream(stream string) string {
+	return fmt.Sprintf("%s:STREAM:%s", b.keyPrefix, stream)
+}
+
+func (b *eventBus) fmtMsg(message string) string {
+	return fmt.Sprintf("eventBus / %s: %s", b.name, message)
+}
+
+// messageProcessor is implemented by the event bus implementations to share the delivery handling
+type messageProcessor interface {
+	ack(ctx context.Context, stream string, group string, id string)
+	deadLetter(ctx context.Context, group string, message Message, cause string)
+}
+
+// processMessage acknowledges handled messages, and moves messages to the dead-letter stream when their last
+// allowed delivery failed, or when they were reclaimed after it (e.g. after a crashed consumer)
+func processMessage(ctx context.Context, processor messageProcessor, config EventBusConfig, group string, message Message, handler Handler) {
+	if message.Deliveries > config.MaxDeliveries {
+		processor.deadLetter(ctx, group, message, "maximum deliveries exceeded")
+		return
+	}
+	err := handler(ctx, message)
+	if err == nil {
+		processor.ack(ctx, message.Stream, group, message.ID)
+		return
+	}
+	if message.Deliveries >= config.MaxDeliveries {
+		log.Warn().Ctx(ctx).Err(err).Str("stream", message.Stream).Str("id", message.ID).Msg("eventBus: moving failed message to dead-letter stream")
+		processor.deadLetter(ctx, group, message, err.Error())
+		return
+	}
+	log.Warn().Ctx(ctx).Err(err).Str("stream", message.Stream).Str("id", message.ID).Int64("deliveries", message.Deliveries).Msg("eventBus: handling message failed, it is redelivered after the idle timeout")
+}
+
+func deadLetterValues(message Message, cause string) []interface{} {
+	return []interface{}{
+		fieldType, string(message.Type),
+		fieldStatus, string(message.Status),
+		fieldPayload, string(message.Payload),
+		fieldOriginalID, message.ID,
+		fieldDeliveries, strconv.FormatInt(message.Deliveries, 10),
+		fieldError, cause,
+	}
+}
+
+func parseMessage(stream string, entry redis.XMessage) (Message, error) {
+	payload, ok := entry.Values[fieldPayload].(string)
+	if !ok {
+		return Message{}, ErrInvalidMessage
+	}
+	message := Message{
+		ID:      entry.ID,
+		Stream:  stream,
+		Payload: json.RawMessage(payload),
+	}
+	if value, ok := entry.Values[fieldType].(string); ok {
+		message.Type = instrumentenum.MessageType(value)
+	}
+	if value, ok := entry.Values[fieldStatus].(string); ok {
+		message.Status = instrumentenum.MessageStatus(value)
+	}
+	if value, ok := entry.Values[fieldOriginalID].(string); ok {
+		message.OriginalID = value
+	}
+	if value, ok := entry.Values[fieldError].(string); ok {
+		message.Error = value
+	}
+	if value, ok := entry.Values[fieldDeliveries].(string); ok {
+		message.Deliveries, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return message, nil
+}
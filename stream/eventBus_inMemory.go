@@ -0,0 +1,252 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/instrumentenum"
+)
+
+// The in-memory event bus keeps the streams in the process, so it can only be used within one service, e.g. in
+// tests. Trimming to MaxLength is exact, Poll does not wait for new messages, and the idle timeout is measured
+// with the clock set by SetClock.
+
+type InMemoryEventBus interface {
+	EventBus
+	SetClock(now func() time.Time)
+}
+
+type memoryPending struct {
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+type memoryGroup struct {
+	lastDelivered uint64
+	pending       map[string]*memoryPending
+}
+
+type memoryEntry struct {
+	seq     uint64
+	message Message
+}
+
+type memoryStream struct {
+	entries []memoryEntry
+	seq     uint64
+	lastMs  int64
+	lastSeq int64
+	groups  map[string]*memoryGroup
+}
+
+type inMemoryEventBus struct {
+	mutex     *sync.Mutex
+	config    EventBusConfig
+	now       func() time.Time
+	streams   map[string]*memoryStream
+	published chan struct{}
+}
+
+func NewInMemoryEventBus(config EventBusConfig) InMemoryEventBus {
+	return &inMemoryEventBus{
+		mutex:     &sync.Mutex{},
+		config:    withDefaults(config),
+		now:       time.Now,
+		streams:   make(map[string]*memoryStream),
+		published: make(chan struct{}),
+	}
+}
+
+func (b *inMemoryEventBus) SetClock(now func() time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.now = now
+}
+
+func (b *inMemoryEventBus) Publish(ctx context.Context, stream string, messageType instrumentenum.MessageType, status instrumentenum.MessageStatus, payload interface{}) (string, error) {
+	if stream == "" {
+		return "", ErrInvalidStream
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	id := b.add(stream, Message{Type: messageType, Status: status, Payload: encoded})
+	close(b.published)
+	b.published = make(chan struct{})
+	return id, nil
+}
+
+func (b *inMemoryEventBus) EnsureGroup(ctx context.Context, stream string, group string) error {
+	if stream == "" {
+		return ErrInvalidStream
+	}
+	if group == "" {
+		return ErrInvalidGroup
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := b.stream(stream)
+	if _, ok := s.groups[group]; !ok {
+		s.groups[group] = &memoryGroup{pending: make(map[string]*memoryPending)}
+	}
+	return nil
+}
+
+func (b *inMemoryEventBus) Poll(ctx context.Context, stream string, group string, handler Handler) (int, error) {
+	if stream == "" {
+		return 0, ErrInvalidStream
+	}
+	if group == "" {
+		return 0, ErrInvalidGroup
+	}
+	b.mutex.Lock()
+	s, ok := b.streams[stream]
+	if !ok || s.groups[group] == nil {
+		b.mutex.Unlock()
+		return 0, ErrGroupNotFound
+	}
+	g, now := s.groups[group], b.now()
+	present := make(map[string]struct{}, len(s.entries))
+	messages := make([]Message, 0)
+	for _, entry := range s.entries {
+		present[entry.message.ID] = struct{}{}
+		pending, ok := g.pending[entry.message.ID]
+		if !ok || int64(len(messages)) >= b.config.BatchSize || now.Sub(pending.deliveredAt) < b.config.ClaimIdleTimeout {
+			continue
+		}
+		pending.consumer, pending.deliveredAt = b.config.Consumer, now
+		pending.deliveries++
+		messages = append(messages, b.delivery(entry, pending))
+	}
+	// Note: like XAUTOCLAIM, pending entries of trimmed messages are removed
+	for id := range g.pending {
+		if _, ok := present[id]; !ok {
+			delete(g.pending, id)
+		}
+	}
+	for _, entry := range s.entries {
+		if int64(len(messages)) >= b.config.BatchSize {
+			break
+		}
+		if entry.seq <= g.lastDelivered {
+			continue
+		}
+		g.lastDelivered = entry.seq
+		pending := &memoryPending{consumer: b.config.Consumer, deliveredAt: now, deliveries: 1}
+		g.pending[entry.message.ID] = pending
+		messages = append(messages, b.delivery(entry, pending))
+	}
+	b.mutex.Unlock()
+	for _, message := range messages {
+		processMessage(ctx, b, b.config, group, message, handler)
+	}
+	return len(messages), nil
+}
+
+func (b *inMemoryEventBus) Consume(ctx context.Context, stream string, group string, handler Handler) error {
+	if err := b.EnsureGroup(ctx, stream, group); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		b.mutex.Lock()
+		published := b.published
+		b.mutex.Unlock()
+		handled, err := b.Poll(ctx, stream, group, handler)
+		if err != nil {
+			return err
+		}
+		if handled == 0 {
+			select {
+			case <-ctx.Done():
+			case <-published:
+			case <-time.After(b.config.BlockTimeout):
+			}
+		}
+	}
+	return nil
+}
+
+func (b *inMemoryEventBus) Messages(ctx context.Context, stream string, count int64) ([]Message, error) {
+	if stream == "" {
+		return nil, ErrInvalidStream
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	messages := make([]Message, 0)
+	s, ok := b.streams[stream]
+	if !ok {
+		return messages, nil
+	}
+	for _, entry := range s.entries {
+		if count > 0 && int64(len(messages)) >= count {
+			break
+		}
+		messages = append(messages, entry.message)
+	}
+	return messages, nil
+}
+
+func (b *inMemoryEventBus) DeadLetterStream(stream string) string {
+	return stream + deadLetterSuffix
+}
+
+func (b *inMemoryEventBus) ack(ctx context.Context, stream string, group string, id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if s, ok := b.streams[stream]; ok && s.groups[group] != nil {
+		delete(s.groups[group].pending, id)
+	}
+}
+
+func (b *inMemoryEventBus) deadLetter(ctx context.Context, group string, message Message, cause string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	deadLetter := message
+	deadLetter.OriginalID, deadLetter.Error = message.ID, cause
+	b.add(b.DeadLetterStream(message.Stream), deadLetter)
+	if s, ok := b.streams[message.Stream]; ok && s.groups[group] != nil {
+		delete(s.groups[group].pending, message.ID)
+	}
+}
+
+// add appends the message with a new id and trims the stream, the mutex must be held
+func (b *inMemoryEventBus) add(stream string, message Message) string {
+	s := b.stream(stream)
+	ms := b.now().UnixMilli()
+	if ms <= s.lastMs {
+		s.lastSeq++
+	} else {
+		s.lastMs, s.lastSeq = ms, 0
+	}
+	s.seq++
+	message.ID = fmt.Sprintf("%d-%d", s.lastMs, s.lastSeq)
+	message.Stream = stream
+	s.entries = append(s.entries, memoryEntry{seq: s.seq, message: message})
+	if b.config.MaxLength > 0 && int64(len(s.entries)) > b.config.MaxLength {
+		s.entries = s.entries[int64(len(s.entries))-b.config.MaxLength:]
+	}
+	return message.ID
+}
+
+// stream returns the stream, creating it if it does not exist, the mutex must be held
+func (b *inMemoryEventBus) stream(stream string) *memoryStream {
+	s, ok := b.streams[stream]
+	if !ok {
+		s = &memoryStream{groups: make(map[string]*memoryGroup)}
+		b.streams[stream] = s
+	}
+	return s
+}
+
+func (b *inMemoryEventBus) delivery(entry memoryEntry, pending *memoryPending) Message {
+	message := entry.message
+	message.Deliveries = pending.deliveries
+	return message
+}
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blutspende/bloodlab-common/instrumentenum"
+	"github.com/stretchr/testify/assert"
+)
+
+type testOrder struct {
+	SampleCode string `json:"sampleCode"`
+}
+
+func TestInMemoryEventBus(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	bus := NewInMemoryEventBus(EventBusConfig{MaxDeliveries: 2, ClaimIdleTimeout: time.Minute, BlockTimeout: 10 * time.Millisecond})
+	bus.SetClock(func() time.Time { return now })
+	testEventBus(t, bus, func(duration time.Duration) { now = now.Add(duration) })
+
+	// Trimming
+	trimmed := NewInMemoryEventBus(EventBusConfig{MaxLength: 2})
+	ctx := context.Background()
+	for _, sampleCode := range []string{"1", "2", "3"} {
+		_, err := trimmed.Publish(ctx, "orders", instrumentenum.MessageTypeOrder, instrumentenum.MessageStatusStored, testOrder{SampleCode: sampleCode})
+		assert.Nil(t, err)
+	}
+	messages, err := trimmed.Messages(ctx, "orders", 0)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 2)
+	assert.JSONEq(t, `{"sampleCode":"2"}`, string(messages[0].Payload))
+}
+
+// testEventBus runs the same scenario on the implementations, advance has to let the ClaimIdleTimeout pass
+func testEventBus(t *testing.T, bus EventBus, advance func(duration time.Duration)) {
+	ctx := context.Background()
+	_, err := bus.Poll(ctx, "orders", "driver", func(ctx context.Context, message Message) error { return nil })
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+	assert.Nil(t, bus.EnsureGroup(ctx, "orders", "driver"))
+	assert.Nil(t, bus.EnsureGroup(ctx, "orders", "driver"))
+	assert.ErrorIs(t, bus.EnsureGroup(ctx, "orders", ""), ErrInvalidGroup)
+
+	// Successful messages are acknowledged, failed ones are redelivered after the idle timeout
+	producer := NewProducer[testOrder](bus, "orders")
+	_, err = producer.Publish(ctx, instrumentenum.MessageTypeOrder, instrumentenum.MessageStatusStored, testOrder{SampleCode: "ok"})
+	assert.Nil(t, err)
+	failingId, err := producer.Publish(ctx, instrumentenum.MessageTypeOrder, instrumentenum.MessageStatusStored, testOrder{SampleCode: "failing"})
+	assert.Nil(t, err)
+	var received []testOrder
+	var deliveries []int64
+	handler := TypedHandler(func(ctx context.Context, message Message, order testOrder) error {
+		received = append(received, order)
+		deliveries = append(deliveries, message.Deliveries)
+		assert.Equal(t, instrumentenum.MessageTypeOrder, message.Type)
+		assert.Equal(t, instrumentenum.MessageStatusStored, message.Status)
+		if order.SampleCode == "failing" {
+			return errors.New("processing failed")
+		}
+		return nil
+	})
+	handled, err := bus.Poll(ctx, "orders", "driver", handler)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, handled)
+	assert.Equal(t, []testOrder{{SampleCode: "ok"}, {SampleCode: "failing"}}, received)
+	handled, err = bus.Poll(ctx, "orders", "driver", handler)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, handled)
+
+	// After MaxDeliveries the message is moved to the dead-letter stream
+	advance(2 * time.Minute)
+	handled, err = bus.Poll(ctx, "orders", "driver", handler)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, handled)
+	assert.Equal(t, []int64{1, 1, 2}, deliveries)
+	advance(2 * time.Minute)
+	handled, err = bus.Poll(ctx, "orders", "driver", handler)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, handled)
+	deadLetters, err := bus.Messages(ctx, bus.DeadLetterStream("orders"), 10)
+	assert.Nil(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, failingId, deadLetters[0].OriginalID)
+		assert.Equal(t, "processing failed", deadLetters[0].Error)
+		assert.Equal(t, int64(2), deadLetters[0].Deliveries)
+		var order testOrder
+		assert.Nil(t, deadLetters[0].Decode(&order))
+		assert.Equal(t, "failing", order.SampleCode)
+	}
+
+	// Other groups receive all messages
+	assert.Nil(t, bus.EnsureGroup(ctx, "orders", "audit"))
+	handled, err = bus.Poll(ctx, "orders", "audit", func(ctx context.Context, message Message) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 2, handled)
+
+	// Consume until cancelled
+	var consumed atomic.Int32
+	consumeCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- bus.Consume(consumeCtx, "results", "driver", func(ctx context.Context, message Message) error {
+			consumed.Add(1)
+			return nil
+		})
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := bus.Publish(ctx, "results", instrumentenum.MessageTypeResult, instrumentenum.MessageStatusStored, "result")
+		assert.Nil(t, err)
+		return consumed.Load() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+}
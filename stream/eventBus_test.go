@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+func TestEventBusWithRedis(t *testing.T) {
+	ctx := context.Background()
+	redisContainer, err := tcredis.Run(ctx, "redis:8.2.2")
+	assert.Nil(t, err)
+	defer func() {
+		assert.Nil(t, testcontainers.TerminateContainer(redisContainer))
+	}()
+	url, err := redisContainer.ConnectionString(ctx)
+	assert.Nil(t, err)
+	opt, err := redis.ParseURL(url)
+	assert.Nil(t, err)
+	redisClient := redis.NewClient(opt)
+	defer redisClient.Close()
+
+	bus := NewEventBus(redisClient, "test", EventBusConfig{MaxDeliveries: 2, ClaimIdleTimeout: 50 * time.Millisecond, BlockTimeout: 10 * time.Millisecond})
+	testEventBus(t, bus, func(duration time.Duration) { time.Sleep(100 * time.Millisecond) })
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, "test:STREAM:orders:DEAD_LETTER").Val())
+
+	_, err = NewEventBus(nil, "test", EventBusConfig{}).Publish(ctx, "orders", "", "", nil)
+	assert.ErrorIs(t, err, ErrNoClientSet)
+}
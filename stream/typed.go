@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/blutspende/bloodlab-common/instrumentenum"
+)
+
+// Producer publishes payloads of one type to one stream
+type Producer[T any] interface {
+	Publish(ctx context.Context, messageType instrumentenum.MessageType, status instrumentenum.MessageStatus, payload T) (id string, err error)
+}
+
+type producer[T any] struct {
+	bus    EventBus
+	stream string
+}
+
+func NewProducer[T any](bus EventBus, stream string) Producer[T] {
+	return &producer[T]{bus: bus, stream: stream}
+}
+
+func (p *producer[T]) Publish(ctx context.Context, messageType instrumentenum.MessageType, status instrumentenum.MessageStatus, payload T) (string, error) {
+	return p.bus.Publish(ctx, p.stream, messageType, status, payload)
+}
+
+// TypedHandler decodes the payload before calling handler, decoding errors are handled like errors of the handler
+func TypedHandler[T any](handler func(ctx context.Context, message Message, payload T) error) Handler {
+	return func(ctx context.Context, message Message) error {
+		var payload T
+		if err := message.Decode(&payload); err != nil {
+			return err
+		}
+		return handler(ctx, message, payload)
+	}
+}